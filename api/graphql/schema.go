@@ -0,0 +1,9 @@
+// Package graphql holds the GraphQL schema of the users, the handler serves it as it is written
+package graphql
+
+import _ "embed"
+
+// Schema is the source of schema.graphql
+//
+//go:embed schema.graphql
+var Schema string
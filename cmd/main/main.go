@@ -12,7 +12,13 @@ import (
 // @host localhost:8080
 // @BasePath /
 
+// @securityDefinitions.apikey APIKey
 // @in header
+// @name X-API-Key
+
+// @securityDefinitions.apikey Bearer
+// @in header
+// @name Authorization
 func main() {
 	app.Run(config.ServerAddr, config.ServerPort)
 }
@@ -1,23 +1,52 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mbndr/figlet4go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
-	"log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	userv1 "sceyt_task/api/user/v1"
 	_ "sceyt_task/docs"
 	"sceyt_task/internal/cache"
 	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
 	"sceyt_task/internal/handler"
 	"sceyt_task/internal/repository"
+	"sceyt_task/internal/token"
 	"sceyt_task/internal/validation"
 	"sceyt_task/pkg/logging"
 	"sceyt_task/pkg/session"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var sf *session.SessionFactory
+// the shutdown delays, tests shorten them
+var (
+	shutdownReadinessDelay = config.ShutdownReadinessDelay
+	shutdownTimeout        = config.ShutdownTimeout
+)
 
 // Run initializes whole application
 func Run(address string, port string) {
@@ -35,37 +64,395 @@ func Run(address string, port string) {
 	logger.Info("logger initialized")
 
 	router := gin.New()
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(handler.NoRoute)
+	router.NoMethod(handler.NoMethod)
+	router.Use(handler.MiddlewareRequestID(logger))
+
+	// tracerProvider records the requests, cache calls and queries, spans of the request continue
+	// the trace of the caller's traceparent header
+	tracingConfig := config.LoadTracingConfig()
+	tracerProvider := newTracerProvider(tracingConfig, logger)
+	router.Use(handler.MiddlewareTracing(logger, tracingConfig.ServiceName, tracerProvider)...)
 
+	// registry holds the metrics served on the metrics listener
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	router.Use(handler.NewRequestMetrics(registry).MiddlewareMetrics)
+	router.Use(gin.LoggerWithFormatter(handler.AccessLogFormatter))
+	router.Use(gin.CustomRecovery(handler.Recovery))
+
+	// the session is opened in Run rather than at package init, so it is closed with the other components
+	sf, err := session.NewSessionFactory()
+	if err != nil {
+		logger.Fatal(err)
+	}
 	sessionRef := sf.GetSession()
 
 	// userRepository contains all the methods that interact with DB to perform CURD operations for user.
-	userRepository := repository.NewUserRepository(sessionRef, logger)
+	userRepository := repository.NewInstrumentedUserRepository(repository.NewUserRepository(sessionRef, logger, tracerProvider), registry)
 
 	// userCache contains all the methods that interact with redis cache
-	userCache := cache.NewRedisCache(fmt.Sprintf("%s:%s", config.RedisHost, config.RedisPort), config.RedisDb, config.RedisExpires)
+	cacheConfig := config.LoadCacheConfig()
+	redisClient, err := cache.NewRedisClient(cacheConfig.Mode, cacheConfig.Addresses, cacheConfig.MasterName,
+		cacheConfig.Password, cacheConfig.DB, config.RedisTimeout)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	userKeys := cache.KeyScheme{
+		Service:       config.CacheKeyPrefix,
+		Tenant:        cacheConfig.Tenant,
+		Entity:        config.CacheUserEntity,
+		SchemaVersion: data.UserSchemaVersion,
+	}
+	codec, err := cache.CodecByName(cacheConfig.Codec)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	serializer := cache.NewSerializer(codec, cacheConfig.CompressAbove)
+	redisCache := cache.NewRedisCache(redisClient, userKeys, serializer, config.RedisExpires, logger)
+
+	// breaker stops calling redis after repeated failures and falls back to the database
+	breaker := cache.NewCircuitBreaker(redisCache, logger, config.CacheBreakerThreshold, config.CacheBreakerCooldown,
+		config.RedisExpires, config.CacheBreakerMaxPending)
+	cache.RegisterMetrics(registry, redisCache, breaker)
+
+	// userCache counts the reads of every user, the hottest ones are kept cached by the warmer
+	userCache := cache.NewAccessTracker(breaker, redisClient, userKeys, config.CacheHotKeysWindow,
+		config.CacheHotKeysMaxTracked, logger)
+	warmer := cache.NewWarmer(userCache, userCache, userRepository.GetUserByUserName, config.CacheWarmUpTopN,
+		config.CacheRefreshAhead, config.CacheRefreshInterval, logger)
+	warmer.Start()
+
+	// healthHandler answers the probes of the orchestrator, the instance is ready once the cache
+	// is warmed up. Redis is not critical, users are read from cassandra while it is down. The
+	// probes are registered before the rate limiter, so they are never limited.
+	healthHandler := handler.NewHealthHandler(logger, config.HealthCheckTimeout,
+		handler.Dependency{Name: "cassandra", Critical: true, Check: func(ctx context.Context) error {
+			return sessionRef.Query(config.CassandraHealthQuery).WithContext(ctx).Exec()
+		}},
+		handler.Dependency{Name: "redis", Check: func(ctx context.Context) error {
+			return redisCache.Ping(ctx)
+		}})
+	healthHandler.Routes(router)
+	go func() {
+		<-warmer.WarmedUp()
+		healthHandler.MarkReady()
+	}()
+
+	// rateLimitHandler limits the request rate per client IP and, behind the route guard, per
+	// authenticated caller. The buckets are shared through redis and kept in memory by every
+	// instance while redis is not available
+	rateLimitKeys := cache.KeyScheme{
+		Service:       config.CacheKeyPrefix,
+		Tenant:        cacheConfig.Tenant,
+		Entity:        config.CacheRateLimitEntity,
+		SchemaVersion: 1,
+	}
+	rateLimiter := cache.NewFallbackRateLimiter(cache.NewRedisRateLimiter(redisClient, rateLimitKeys),
+		cache.NewLocalRateLimiter(config.RateLimitMaxLocalBuckets), config.CacheBreakerCooldown, logger)
+	rateLimitHandler := handler.NewRateLimitHandler(logger, rateLimiter, config.LoadRateLimitConfig())
+	router.Use(rateLimitHandler.MiddlewareRateLimit)
+
+	// idempotencyHandler replays the stored response for retried requests with an Idempotency-Key,
+	// the store calls redis through the breaker and requests are served without it while it is open
+	idempotencyKeys := cache.KeyScheme{
+		Service:       config.CacheKeyPrefix,
+		Tenant:        cacheConfig.Tenant,
+		Entity:        config.CacheIdempotencyEntity,
+		SchemaVersion: 1,
+	}
+	idempotencyStore := cache.NewIdempotencyStore(redisClient, breaker, idempotencyKeys, cacheConfig.IdempotencyWindowDuration(),
+		config.IdempotencyLockTimeout, logger)
+	idempotencyHandler := handler.NewIdempotencyHandler(logger, idempotencyStore)
 
 	// validation contains all the methods that are need to validate the user json in request
 	validator := validation.NewValidation()
 
+	// authenticator identifies callers by their API key, keys are stored hashed in the database
+	apiKeyRepository := repository.NewAPIKeyRepository(sessionRef, logger, tracerProvider)
+	// bearer tokens of the gateway are verified with the keys of a local JWKS file
+	var tokenVerifier *token.Verifier
+	var keySet *token.KeySet
+	jwtConfig := config.LoadJWTConfig()
+	if jwtConfig.Enabled {
+		keySet, err = token.NewKeySet(jwtConfig.JWKSPath, jwtConfig.ReloadIntervalDuration(), logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		keySet.Start()
+		tokenVerifier = token.NewVerifier(keySet, jwtConfig.Issuer, jwtConfig.Audience, jwtConfig.LeewayDuration())
+	}
+	authenticator := handler.NewAuthenticator(logger, apiKeyRepository, tokenVerifier, jwtConfig.Scopes,
+		config.LoadAdminConfig().Token, config.APIKeyCacheTTL)
+
 	// AuthHandler encapsulates all the services related to user
-	authHandler := handler.NewUserHandler(logger, validator, userRepository, userCache)
+	authHandler := handler.NewUserHandler(logger, validator, userRepository, cache.NewTracedUserCache(userCache, tracerProvider),
+		handler.NewPolicy(logger, config.LoadRBACConfig()))
+
+	authHandler.Routes(router, authenticator.RequireScope, rateLimitHandler.MiddlewarePrincipalRateLimit,
+		idempotencyHandler.MiddlewareIdempotency)
+	authHandler.RoutesV2(router, authenticator.RequireScope, rateLimitHandler.MiddlewarePrincipalRateLimit,
+		idempotencyHandler.MiddlewareIdempotency)
+
+	// graphQLHandler serves the users over GraphQL with the handler of the REST API
+	graphQLHandler := handler.NewGraphQLHandler(authHandler)
+	graphQLHandler.Routes(router, authenticator.RequireScope, idempotencyHandler.MiddlewareIdempotency)
+
+	// apiKeyHandler lets admins create, list, rotate and revoke API keys
+	apiKeyHandler := handler.NewAPIKeyHandler(logger, validator, apiKeyRepository, authenticator)
+	apiKeyHandler.Routes(router, authenticator.RequireScope)
 
-	authHandler.Routes(router)
+	// cacheHandler reports the state of the cache layer and lets support evict stale entries
+	cacheHandler := handler.NewCacheHandler(logger, breaker, redisCache)
+	cacheHandler.Routes(router, authenticator.RequireScope)
 	router.GET(config.SwaggerPath, ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	err := router.Run(fmt.Sprintf("%s:%v", address, port))
-	if err != nil {
-		logger.Error(err)
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%v", address, port),
+		Handler:           router,
+		ReadHeaderTimeout: config.ServerReadHeaderTimeout,
+		ReadTimeout:       config.ServerReadTimeout,
+		WriteTimeout:      config.ServerWriteTimeout,
+		IdleTimeout:       config.ServerIdleTimeout,
+	}
+	// grpcServer serves the users over gRPC with the handler, the credentials and the
+	// permissions of the REST API
+	grpcOptions := append(handler.ServerOptions(handler.InterceptRequestID(logger), handler.InterceptTracing(logger),
+		handler.InterceptAccessLog(logger)), handler.ServerTracing(tracerProvider), grpc.ConnectionTimeout(config.ServerReadHeaderTimeout),
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: config.ServerIdleTimeout}))
+	grpcServer := grpc.NewServer(grpcOptions...)
+	userv1.RegisterUserServiceServer(grpcServer, handler.NewUserRPCService(authHandler, authenticator))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	readinessCtx, stopReadiness := context.WithCancel(context.Background())
+	go handler.ReportReadiness(readinessCtx, healthHandler, healthServer, config.GRPCHealthWatchInterval,
+		"", userv1.UserService_ServiceDesc.ServiceName)
+
+	metricsServer := serveMetrics(registry, logger)
+	adminServer := serveAdmin(logger)
+	var drains []func(ctx context.Context) error
+	if serveGRPC(grpcServer, logger) {
+		drains = append(drains, func(ctx context.Context) error {
+			// the health service reports NOT_SERVING to the watchers before the calls are drained
+			stopReadiness()
+			healthServer.Shutdown()
+			return drainGRPC(ctx, grpcServer)
+		})
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	serve(server, signals, healthHandler, logger, drains...)
+	signal.Stop(signals)
+	stopReadiness()
+	grpcServer.Stop()
+	for _, s := range []*http.Server{metricsServer, adminServer} {
+		if s != nil {
+			_ = s.Close()
+		}
 	}
 
+	// the workers are stopped before the clients they use, the session is closed last
+	// as the warmer reloads users from the database until it is stopped
+	warmer.Stop()
+	if keySet != nil {
+		keySet.Stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.TracingExportInterval)
+	if err = tracerProvider.Shutdown(ctx); err != nil {
+		logger.Error("error while exporting the last spans", "error", err)
+	}
+	cancel()
+	if err = redisClient.Close(); err != nil {
+		logger.Error("error while closing the redis client", "error", err)
+	}
+	sf.Close()
+	logger.Info("shutdown complete")
 }
 
-func init() {
+// newTracerProvider returns the tracer provider of the configured exporter and registers it
+// with the W3C trace context propagator, no span is recorded when tracing is disabled or the
+// exporter can not be created
+func newTracerProvider(tracingConfig *config.TracingConfiguration, logger logging.Logger) *sdktrace.TracerProvider {
+	var exporter sdktrace.SpanExporter
 	var err error
-	sf, err = session.NewSessionFactory()
+	if tracingConfig.Enabled {
+		switch tracingConfig.Exporter {
+		case config.TracingExporterStdout:
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		case config.TracingExporterFile:
+			var file *os.File
+			file, err = os.OpenFile(tracingConfig.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err == nil {
+				exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+			}
+		case config.TracingExporterOTLP:
+			exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(tracingConfig.OTLPEndpoint),
+				otlptracehttp.WithTimeout(config.TracingExportInterval))
+		default:
+			err = fmt.Errorf("unknown span exporter %q", tracingConfig.Exporter)
+		}
+		if err != nil {
+			logger.Error("error while creating the span exporter, tracing is disabled", "error", err)
+		}
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingConfig.ServiceName))),
+	}
+	if exporter == nil {
+		options = append(options, sdktrace.WithSampler(sdktrace.NeverSample()))
+	} else {
+		// traces continued from a traceparent header follow the sampling decision of the caller
+		options = append(options,
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingConfig.SampleRatio))),
+			sdktrace.WithBatcher(exporter, sdktrace.WithMaxQueueSize(config.TracingQueueSize),
+				sdktrace.WithMaxExportBatchSize(config.TracingBatchSize), sdktrace.WithBatchTimeout(config.TracingExportInterval)))
+	}
+	tracerProvider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tracerProvider
+}
+
+// serveMetrics serves the metrics on their own listener in the background, it returns
+// nil when metrics are disabled
+func serveMetrics(registry *prometheus.Registry, logger logging.Logger) *http.Server {
+	metricsConfig := config.LoadMetricsConfig()
+	if !metricsConfig.Enabled {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(config.MetricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              metricsConfig.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: config.ServerReadHeaderTimeout,
+		ReadTimeout:       config.ServerReadTimeout,
+		WriteTimeout:      config.ServerWriteTimeout,
+		IdleTimeout:       config.ServerIdleTimeout,
+	}
+	go func() {
+		logger.Info("serving metrics on ", server.Addr, config.MetricsPath)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Error("metrics server stopped", "error", err)
+		}
+	}()
+	return server
+}
+
+// serveGRPC serves the gRPC API on its own listener in the background, it returns false
+// when gRPC is disabled or its listener can not be opened
+func serveGRPC(server *grpc.Server, logger logging.Logger) bool {
+	grpcConfig := config.LoadGRPCConfig()
+	if !grpcConfig.Enabled {
+		return false
+	}
+	if grpcConfig.Reflection {
+		reflection.Register(server)
+	}
+	listener, err := net.Listen("tcp", grpcConfig.Address)
 	if err != nil {
-		log.Panic(err)
+		logger.Error("error while opening the gRPC listener, gRPC is disabled", "error", err)
+		return false
+	}
+	go func() {
+		logger.Info("serving gRPC on ", listener.Addr())
+		if err := server.Serve(listener); err != nil {
+			logger.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	return true
+}
+
+// drainGRPC stops the server once the calls in flight completed, the calls still running
+// when ctx is done are canceled
+func drainGRPC(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// serveAdmin serves the profiles and diagnostics on the admin listener in the background,
+// it returns nil when no admin address is configured
+func serveAdmin(logger logging.Logger) *http.Server {
+	address := config.LoadAdminConfig().Address
+	if address == "" {
+		return nil
+	}
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(handler.NoRoute)
+	router.NoMethod(handler.NoMethod)
+	router.Use(handler.MiddlewareRequestID(logger))
+	router.Use(gin.LoggerWithFormatter(handler.AccessLogFormatter))
+	router.Use(gin.CustomRecovery(handler.Recovery))
+	handler.NewDiagnosticsHandler(logger, config.Effective).Routes(router)
+
+	// the write timeout is left out, CPU profiles and execution traces take up to their seconds parameter
+	server := &http.Server{
+		Addr:              address,
+		Handler:           router,
+		ReadHeaderTimeout: config.ServerReadHeaderTimeout,
+		ReadTimeout:       config.ServerReadTimeout,
+		IdleTimeout:       config.ServerIdleTimeout,
+	}
+	go func() {
+		logger.Info("serving diagnostics on ", server.Addr, config.DebugPath)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Error("admin server stopped", "error", err)
+		}
+	}()
+	return server
+}
+
+// serve runs the server until a signal is received on stop, then reports the instance as
+// not ready, stops accepting connections and waits up to ShutdownTimeout for the in-flight
+// requests. The drains of the other listeners run at the same time.
+func serve(server *http.Server, stop <-chan os.Signal, health *handler.HealthHandler, logger logging.Logger,
+	drains ...func(ctx context.Context) error) {
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening on ", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case sig := <-stop:
+		logger.Info("received ", sig, ", draining connections")
+	case err := <-serverErr:
+		logger.Error("server stopped", "error", err)
+		return
+	}
+
+	health.MarkShuttingDown()
+	time.Sleep(shutdownReadinessDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, drain := range drains {
+		wg.Add(1)
+		go func(drain func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := drain(ctx); err != nil {
+				logger.Error("error while draining calls", "error", err)
+			}
+		}(drain)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("error while draining connections, closing them", "error", err)
+		_ = server.Close()
 	}
+	wg.Wait()
 }
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/handler"
+	"sceyt_task/pkg/logging"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testLogger() logging.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return logging.Logger{Entry: logrus.NewEntry(l)}
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func shortenShutdown(t *testing.T, delay time.Duration, timeout time.Duration) {
+	readinessDelay, drainTimeout := shutdownReadinessDelay, shutdownTimeout
+	shutdownReadinessDelay, shutdownTimeout = delay, timeout
+	t.Cleanup(func() { shutdownReadinessDelay, shutdownTimeout = readinessDelay, drainTimeout })
+}
+
+// waitForServer waits until the server at addr accepts connections
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server at %s is not listening: %v", addr, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	shortenShutdown(t, 20*time.Millisecond, time.Second)
+	started, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Addr: freeAddress(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})}
+	health := handler.NewHealthHandler(testLogger(), time.Second)
+	health.MarkReady()
+	var drained int32
+	drain := func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("the drain has no deadline")
+		}
+		atomic.AddInt32(&drained, 1)
+		return nil
+	}
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		serve(server, stop, health, testLogger(), drain, drain)
+		close(done)
+	}()
+	waitForServer(t, server.Addr)
+
+	status := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://" + server.Addr)
+		if err != nil {
+			t.Error(err)
+			status <- 0
+			return
+		}
+		_ = res.Body.Close()
+		status <- res.StatusCode
+	}()
+	<-started
+	stop <- syscall.SIGTERM
+
+	// the instance is reported as not ready before the server stops accepting connections
+	time.Sleep(10 * time.Millisecond)
+	if state := health.Readiness(context.Background()).Status; state != handler.StateShuttingDown {
+		t.Errorf("readiness = %s, want %s", state, handler.StateShuttingDown)
+	}
+	if conn, err := net.Dial("tcp", server.Addr); err != nil {
+		t.Errorf("connections were refused during the readiness delay: %v", err)
+	} else {
+		_ = conn.Close()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("serve returned before the in-flight request completed")
+	default:
+	}
+	close(release)
+	if code := <-status; code != http.StatusOK {
+		t.Fatalf("in-flight request = %d, want it completed", code)
+	}
+	<-done
+	if atomic.LoadInt32(&drained) != 2 {
+		t.Fatalf("%d drains ran, want 2", drained)
+	}
+	if _, err := net.Dial("tcp", server.Addr); err == nil {
+		t.Fatal("the server still accepts connections")
+	}
+}
+
+func TestServeClosesConnectionsAfterTheTimeout(t *testing.T) {
+	shortenShutdown(t, 0, 50*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Addr: freeAddress(t), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})}
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		serve(server, stop, handler.NewHealthHandler(testLogger(), time.Second), testLogger())
+		close(done)
+	}()
+	waitForServer(t, server.Addr)
+
+	failed := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + server.Addr)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		failed <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	stop <- syscall.SIGINT
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return after the shutdown timeout")
+	}
+	if err := <-failed; err == nil {
+		t.Fatal("the stuck request was answered, want its connection closed")
+	}
+}
+
+func TestServeReturnsWhenTheServerFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	server := &http.Server{Addr: listener.Addr().String(), Handler: http.NotFoundHandler()}
+
+	done := make(chan struct{})
+	go func() {
+		serve(server, make(chan os.Signal), handler.NewHealthHandler(testLogger(), time.Second), testLogger())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return when the address is in use")
+	}
+}
+
+func TestServeAdminServesOnlyTheDiagnostics(t *testing.T) {
+	// the config files are read relative to the repository root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	address := freeAddress(t)
+	t.Setenv("ADMIN_ADDRESS", address)
+
+	server := serveAdmin(testLogger())
+	if server == nil {
+		t.Fatal("the admin listener is disabled")
+	}
+	defer server.Close()
+	waitForServer(t, address)
+
+	get := func(path string) (int, string) {
+		res, err := http.Get("http://" + address + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	if code, body := get(config.DebugPath + config.DebugLogLevelPath); code != http.StatusOK || !strings.Contains(body, "level") {
+		t.Fatalf("log level = %d %s", code, body)
+	}
+	if code, body := get(config.DebugPath + config.DebugConfigPath); code != http.StatusOK || !strings.Contains(body, address) {
+		t.Fatalf("config = %d %s", code, body)
+	}
+	// the API is not served on the admin listener
+	if code, _ := get(config.LivenessPath); code != http.StatusNotFound {
+		t.Fatalf("liveness on the admin listener = %d, want 404", code)
+	}
+}
+
+func TestDrainGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	if _, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	// a watch only ends when the client cancels it, it is canceled at the deadline
+	watch, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = drainGRPC(ctx, server); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drainGRPC() = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("drainGRPC() took %s", elapsed)
+	}
+	if _, err = watch.Recv(); err == nil {
+		t.Fatal("the watch is still running after the drain")
+	}
+	if _, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err == nil {
+		t.Fatal("the server still answers calls")
+	}
+}
+
+func TestDrainGRPCWithoutCalls(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go func() { _ = server.Serve(listener) }()
+
+	if err = drainGRPC(context.Background(), server); err != nil {
+		t.Fatalf("drainGRPC() = %v", err)
+	}
+}
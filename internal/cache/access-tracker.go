@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AccessTracker counts how often every key is read and keeps the counts in redis
+// sorted sets, one per time window, so hot keys survive restarts of the service.
+// Reads are counted in memory and written to redis in batches by Flush.
+type AccessTracker struct {
+	UserCache
+	client     redis.UniversalClient
+	keys       KeyScheme
+	window     time.Duration
+	maxTracked int
+	logger     logging.Logger
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// NewAccessTracker returns an AccessTracker counting the reads of next. At most
+// maxTracked distinct keys are counted between two flushes.
+func NewAccessTracker(next UserCache, client redis.UniversalClient, keys KeyScheme, window time.Duration, maxTracked int, l logging.Logger) *AccessTracker {
+	return &AccessTracker{
+		UserCache:  next,
+		client:     client,
+		keys:       keys,
+		window:     window,
+		maxTracked: maxTracked,
+		logger:     l,
+		counts:     map[string]int64{},
+	}
+}
+
+// Get counts the access and reads the key from the wrapped cache
+func (t *AccessTracker) Get(ctx context.Context, key string) (*data.User, error) {
+	t.mu.Lock()
+	if _, ok := t.counts[key]; ok || len(t.counts) < t.maxTracked {
+		t.counts[key]++
+	}
+	t.mu.Unlock()
+	return t.UserCache.Get(ctx, key)
+}
+
+func (t *AccessTracker) bucket(at time.Time) int64 {
+	return at.UnixNano() / int64(t.window)
+}
+
+// Flush adds the counted reads to the sorted set of the current window
+func (t *AccessTracker) Flush() error {
+	t.mu.Lock()
+	counts := t.counts
+	t.counts = map[string]int64{}
+	t.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	hotKey := t.keys.Hot(t.bucket(time.Now()))
+	pipe := t.client.Pipeline()
+	for key, count := range counts {
+		pipe.ZIncrBy(hotKey, float64(count), key)
+	}
+	// the previous window is still read by Top, so every set lives for two windows
+	pipe.Expire(hotKey, 2*t.window)
+	_, err := pipe.Exec()
+	return err
+}
+
+// Top returns up to n keys read most often in the current and the previous window
+func (t *AccessTracker) Top(n int) ([]string, error) {
+	now := t.bucket(time.Now())
+	scores := map[string]float64{}
+	for _, bucket := range []int64{now - 1, now} {
+		members, err := t.client.ZRevRangeWithScores(t.keys.Hot(bucket), 0, int64(n-1)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			scores[m.Member.(string)] += m.Score
+		}
+	}
+
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return scores[keys[i]] > scores[keys[j]]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys, nil
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis"
+	"sceyt_task/internal/data"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Admin gives support direct access to the cached entries
+type Admin interface {
+	// Inspect returns the cached entry of the key, nil if it is not cached
+	Inspect(key string) (*EntryDetails, error)
+	// EvictPattern invalidates every cached key matching the redis glob pattern and returns their number
+	EvictPattern(pattern string) (int, error)
+	// Flush removes every cached entry of the service namespace and returns their number,
+	// the invalidation versions and the access counts of the hot keys are kept
+	Flush() (int, error)
+	Stats() Stats
+}
+
+// RedisCache is a UserCache that can be administrated
+type RedisCache interface {
+	UserCache
+	Admin
+}
+
+// EntryDetails describes a cached entry
+type EntryDetails struct {
+	Key   string     `json:"key"`
+	Value *data.User `json:"value"`
+	// TTL is the remaining lifetime in seconds
+	TTL     int64 `json:"ttl"`
+	Version int64 `json:"version"`
+	EntryInfo
+}
+
+// Stats are the counters of the cache since the service started
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Errors    uint64 `json:"errors"`
+}
+
+// stats is updated atomically by redisCache
+type stats struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	errors    uint64
+}
+
+// countError counts err unless it is nil or a missing key and returns it unchanged
+func (s *stats) countError(err error) error {
+	if err != nil && err != redis.Nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	return err
+}
+
+func (r *redisCache) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&r.stats.hits),
+		Misses:    atomic.LoadUint64(&r.stats.misses),
+		Evictions: atomic.LoadUint64(&r.stats.evictions),
+		Errors:    atomic.LoadUint64(&r.stats.errors),
+	}
+}
+
+func (r *redisCache) Inspect(key string) (*EntryDetails, error) {
+	entryKey := r.keys.Entry(key)
+	res, err := r.client.Get(entryKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.stats.countError(err)
+	}
+	info, err := r.serializer.Describe(res)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.serializer.Decode(res)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := r.TTL(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	version, err := r.Version(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &EntryDetails{Key: entryKey, Value: user, TTL: int64(ttl / time.Second), Version: version, EntryInfo: info}, nil
+}
+
+func (r *redisCache) EvictPattern(pattern string) (int, error) {
+	// entries of every schema version are matched, the id is the part inside the hash tag
+	keys, err := r.scan(r.keys.Namespace() + ":v*:{" + pattern + "}")
+	if err != nil {
+		return 0, err
+	}
+	ids := map[string]struct{}{}
+	for _, key := range keys {
+		start := strings.Index(key, ":{")
+		if start < 0 || !strings.HasSuffix(key, "}") {
+			continue
+		}
+		ids[key[start+2:len(key)-1]] = struct{}{}
+	}
+	for id := range ids {
+		if err := r.Invalidate(context.Background(), id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (r *redisCache) Flush() (int, error) {
+	// only entries are removed, deleting a version key resets it and a load that read
+	// the version before a concurrent update could write its stale user back
+	keys, err := r.scan(r.keys.Namespace() + ":v*:{*}")
+	if err != nil {
+		return 0, err
+	}
+	// keys are deleted one by one, in cluster mode they are spread over different slots
+	for _, key := range keys {
+		if err := r.client.Del(key).Err(); err != nil {
+			return 0, r.stats.countError(err)
+		}
+	}
+	atomic.AddUint64(&r.stats.evictions, uint64(len(keys)))
+	return len(keys), nil
+}
+
+// scan returns all keys matching pattern, in cluster mode every master is scanned
+func (r *redisCache) scan(pattern string) ([]string, error) {
+	var mu sync.Mutex
+	var keys []string
+	scanNode := func(client *redis.Client) error {
+		it := client.Scan(0, pattern, 1000).Iterator()
+		for it.Next() {
+			mu.Lock()
+			keys = append(keys, it.Val())
+			mu.Unlock()
+		}
+		return it.Err()
+	}
+
+	var err error
+	switch client := r.client.(type) {
+	case *redis.ClusterClient:
+		err = client.ForEachMaster(scanNode)
+	case *redis.Client:
+		err = scanNode(client)
+	default:
+		return nil, fmt.Errorf("scanning keys is not supported with a %T", client)
+	}
+	return keys, r.stats.countError(err)
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+func TestFlushKeepsVersionsAndHotKeys(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedisCache(t)
+	for _, name := range []string{"alice", "bob"} {
+		if err := c.Set(ctx, name, &data.User{Username: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = server.Set(testKeys.PreviousEntry("carol"), "old")
+	_ = c.Invalidate(ctx, "dave")
+	_, _ = server.ZAdd(testKeys.Hot(1), 3, "alice")
+	_ = server.Set("other-service:user:v2:{alice}", "x")
+
+	flushed, err := c.Flush()
+	if err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if flushed != 3 {
+		t.Errorf("Flush() = %d, want the 3 entries", flushed)
+	}
+	for _, key := range []string{testKeys.Entry("alice"), testKeys.Entry("bob"), testKeys.PreviousEntry("carol")} {
+		if server.Exists(key) {
+			t.Errorf("%s was not flushed", key)
+		}
+	}
+	for _, key := range []string{testKeys.Version("dave"), testKeys.Hot(1), "other-service:user:v2:{alice}"} {
+		if !server.Exists(key) {
+			t.Errorf("%s was flushed", key)
+		}
+	}
+}
+
+func TestEvictPatternInvalidatesMatchingUsers(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedisCache(t)
+	for _, name := range []string{"john", "johnny", "jane"} {
+		_ = c.Set(ctx, name, &data.User{Username: name})
+	}
+	// an entry of the previous schema is matched as well, once per user
+	_ = server.Set(testKeys.PreviousEntry("john"), "old")
+
+	evicted, err := c.EvictPattern("john*")
+	if err != nil {
+		t.Fatalf("EvictPattern() = %v", err)
+	}
+	if evicted != 2 {
+		t.Errorf("EvictPattern() = %d, want 2", evicted)
+	}
+	for _, name := range []string{"john", "johnny"} {
+		if server.Exists(testKeys.Entry(name)) {
+			t.Errorf("%s is still cached", name)
+		}
+		if version, _ := c.Version(ctx, name); version != 1 {
+			t.Errorf("version of %s = %d, want 1", name, version)
+		}
+	}
+	if !server.Exists(testKeys.Entry("jane")) {
+		t.Error("jane was evicted")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestRedisCache(t)
+	if entry, err := c.Inspect("alice"); entry != nil || err != nil {
+		t.Fatalf("Inspect() of a missing user = %+v, %v", entry, err)
+	}
+
+	_ = c.Invalidate(ctx, "alice")
+	_ = c.SetIfVersion(ctx, "alice", &data.User{Username: "alice", FirstName: "Alice"}, 1)
+	entry, err := c.Inspect("alice")
+	if err != nil {
+		t.Fatalf("Inspect() = %v", err)
+	}
+	if entry.Key != testKeys.Entry("alice") || entry.Value.FirstName != "Alice" || entry.Version != 1 ||
+		entry.TTL != 60 || entry.Codec != "json" || entry.Compressed {
+		t.Fatalf("Inspect() = %+v", entry)
+	}
+}
+
+func TestScanRejectsUnsupportedClients(t *testing.T) {
+	c, server := newTestRedisCache(t)
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"a": server.Addr()}})
+	defer ring.Close()
+	c.client = ring
+
+	if _, err := c.Flush(); err == nil {
+		t.Fatal("Flush() through a ring client succeeded without scanning any key")
+	}
+	if _, err := c.EvictPattern("*"); err == nil {
+		t.Fatal("EvictPattern() through a ring client succeeded without scanning any key")
+	}
+}
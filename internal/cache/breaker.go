@@ -0,0 +1,318 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while the breaker bypasses Redis
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+type BreakerState string
+
+const (
+	StateClosed   BreakerState = "closed"
+	StateOpen     BreakerState = "open"
+	StateHalfOpen BreakerState = "half-open"
+)
+
+// BreakerStatus is a snapshot of the circuit breaker reported by the status endpoint
+type BreakerStatus struct {
+	State                BreakerState `json:"state"`
+	ConsecutiveFailures  int          `json:"consecutive_failures"`
+	TotalErrors          uint64       `json:"total_errors"`
+	Bypassed             uint64       `json:"bypassed"`
+	PendingInvalidations int          `json:"pending_invalidations"`
+	LastError            string       `json:"last_error,omitempty"`
+	LastStateChange      time.Time    `json:"last_state_change"`
+}
+
+// CircuitBreaker wraps a UserCache, stops calling it after repeated failures
+// and probes it in the background until it recovers.
+//
+// While the breaker is open reads are served as misses, so requests go straight
+// to the database instead of waiting for Redis timeouts. Invalidations are queued
+// and replayed before the breaker closes again, so entries that were changed
+// during the outage are never served once Redis is back.
+type CircuitBreaker struct {
+	next      UserCache
+	logger    logging.Logger
+	threshold int
+	cooldown  time.Duration
+	expires   time.Duration
+	// maxPending bounds the invalidation queue, when it overflows the breaker
+	// stays open until every entry that could not be invalidated has expired
+	maxPending int
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	errors    uint64
+	bypassed  uint64
+	lastError string
+	changedAt time.Time
+	pending   map[string]struct{}
+	holdUntil time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after threshold consecutive failures
+func NewCircuitBreaker(next UserCache, l logging.Logger, threshold int, cooldown time.Duration, expires time.Duration, maxPending int) *CircuitBreaker {
+	return &CircuitBreaker{
+		next:       next,
+		logger:     l,
+		threshold:  threshold,
+		cooldown:   cooldown,
+		expires:    expires,
+		maxPending: maxPending,
+		state:      StateClosed,
+		changedAt:  time.Now(),
+		pending:    map[string]struct{}{},
+	}
+}
+
+// Status returns the current state and error counters of the breaker
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{
+		State:                b.state,
+		ConsecutiveFailures:  b.failures,
+		TotalErrors:          b.errors,
+		Bypassed:             b.bypassed,
+		PendingInvalidations: len(b.pending),
+		LastError:            b.lastError,
+		LastStateChange:      b.changedAt,
+	}
+}
+
+// allow reports whether the call may go to Redis and counts it as bypassed otherwise
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateClosed {
+		return true
+	}
+	b.bypassed++
+	return false
+}
+
+// record updates the counters with the result of a call and trips the breaker if needed
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	if err == nil || err == ErrStaleVersion {
+		b.mu.Lock()
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errors++
+	b.failures++
+	b.lastError = err.Error()
+	logging.FromContext(ctx, b.logger).Warn("redis call failed", "error", err, "consecutive_failures", b.failures)
+	if b.state == StateClosed && b.failures >= b.threshold {
+		b.setState(StateOpen)
+		go b.probe()
+	}
+}
+
+// setState must be called with the lock held
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.logger.Warnf("cache circuit breaker changed state from %s to %s, consecutive failures: %d, total errors: %d",
+		b.state, state, b.failures, b.errors)
+	b.state = state
+	b.changedAt = time.Now()
+}
+
+// probe periodically pings Redis while the breaker is open and closes it once
+// Redis answers and every queued invalidation was replayed
+func (b *CircuitBreaker) probe() {
+	// the probe outlives the request that tripped the breaker
+	ctx := context.Background()
+	for {
+		time.Sleep(b.cooldown)
+
+		b.mu.Lock()
+		if time.Now().Before(b.holdUntil) {
+			b.mu.Unlock()
+			continue
+		}
+		b.setState(StateHalfOpen)
+		b.mu.Unlock()
+
+		err := b.next.Ping(ctx)
+		for err == nil {
+			err = b.replay(ctx)
+			if err != nil {
+				break
+			}
+			b.mu.Lock()
+			// invalidations may have been queued while replaying, the breaker
+			// only closes once the queue is drained under the lock
+			if len(b.pending) == 0 {
+				b.failures = 0
+				b.setState(StateClosed)
+				b.mu.Unlock()
+				return
+			}
+			b.mu.Unlock()
+		}
+
+		b.mu.Lock()
+		b.errors++
+		b.lastError = err.Error()
+		b.setState(StateOpen)
+		b.mu.Unlock()
+	}
+}
+
+// replay runs the invalidations that were requested while the breaker was open
+func (b *CircuitBreaker) replay(ctx context.Context) error {
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.pending))
+	for key := range b.pending {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		if err := b.next.Invalidate(ctx, key); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		delete(b.pending, key)
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// deferInvalidation queues the invalidation of key if the breaker is not closed.
+// The check and the queueing happen under one lock, so the probe cannot close
+// the breaker in between and lose the key.
+func (b *CircuitBreaker) deferInvalidation(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateClosed {
+		return false
+	}
+	b.bypassed++
+	b.queue(key)
+	return true
+}
+
+// failInvalidation queues the invalidation of key after it failed and opens the breaker,
+// so the entry that could not be removed is not served until the queue is replayed
+func (b *CircuitBreaker) failInvalidation(ctx context.Context, key string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	logging.FromContext(ctx, b.logger).Error("queueing failed cache invalidation", "key", key, "error", err)
+	b.queue(key)
+	if b.state == StateClosed {
+		b.setState(StateOpen)
+		go b.probe()
+	}
+}
+
+// queue adds key to the pending invalidations, it must be called with the lock held
+func (b *CircuitBreaker) queue(key string) {
+	if len(b.pending) >= b.maxPending {
+		b.holdUntil = time.Now().Add(b.expires * time.Second)
+		b.logger.Errorf("too many pending cache invalidations, the cache stays disabled until %s", b.holdUntil)
+		return
+	}
+	b.pending[key] = struct{}{}
+}
+
+// Call runs a Redis call of another store through the breaker, so it shares the
+// failure count with the cache and is not sent while Redis is known to be down
+func (b *CircuitBreaker) Call(ctx context.Context, call func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := call()
+	b.record(ctx, err)
+	return err
+}
+
+func (b *CircuitBreaker) Set(ctx context.Context, key string, value *data.User) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.Set(ctx, key, value)
+	b.record(ctx, err)
+	return err
+}
+
+// Get serves failures as cache misses, so the caller falls back to the database
+func (b *CircuitBreaker) Get(ctx context.Context, key string) (*data.User, error) {
+	if !b.allow() {
+		return nil, nil
+	}
+	user, err := b.next.Get(ctx, key)
+	b.record(ctx, err)
+	if err != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+func (b *CircuitBreaker) Del(ctx context.Context, key string) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.Del(ctx, key)
+	b.record(ctx, err)
+	return err
+}
+
+func (b *CircuitBreaker) Version(ctx context.Context, key string) (int64, error) {
+	if !b.allow() {
+		return 0, ErrCircuitOpen
+	}
+	version, err := b.next.Version(ctx, key)
+	b.record(ctx, err)
+	return version, err
+}
+
+func (b *CircuitBreaker) SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.SetIfVersion(ctx, key, value, version)
+	b.record(ctx, err)
+	return err
+}
+
+// Invalidate is queued while the breaker is open, reads are bypassed until
+// the queue is replayed so the old value cannot be served in the meantime.
+// An invalidation that fails is queued the same way and opens the breaker, so the
+// write it follows does not have to be reported as failed.
+func (b *CircuitBreaker) Invalidate(ctx context.Context, key string) error {
+	if b.deferInvalidation(key) {
+		return nil
+	}
+	err := b.next.Invalidate(ctx, key)
+	b.record(ctx, err)
+	if err != nil {
+		b.failInvalidation(ctx, key, err)
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if !b.allow() {
+		return 0, ErrCircuitOpen
+	}
+	ttl, err := b.next.TTL(ctx, key)
+	b.record(ctx, err)
+	return ttl, err
+}
+
+func (b *CircuitBreaker) Ping(ctx context.Context) error {
+	return b.next.Ping(ctx)
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sceyt_task/internal/data"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errRedisDown = errors.New("redis is down")
+
+// fakeCache is a UserCache whose calls fail while down is set
+type fakeCache struct {
+	mu          sync.Mutex
+	down        bool
+	users       map[string]*data.User
+	gets        int
+	invalidated []string
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{users: map[string]*data.User{}}
+}
+
+func (f *fakeCache) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeCache) call() error {
+	if f.down {
+		return errRedisDown
+	}
+	return nil
+}
+
+func (f *fakeCache) Set(ctx context.Context, key string, value *data.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(); err != nil {
+		return err
+	}
+	f.users[key] = value
+	return nil
+}
+
+func (f *fakeCache) Get(ctx context.Context, key string) (*data.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	if err := f.call(); err != nil {
+		return nil, err
+	}
+	return f.users[key], nil
+}
+
+func (f *fakeCache) Del(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(); err != nil {
+		return err
+	}
+	delete(f.users, key)
+	return nil
+}
+
+func (f *fakeCache) Version(ctx context.Context, key string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return 0, f.call()
+}
+
+func (f *fakeCache) SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error {
+	return f.Set(ctx, key, value)
+}
+
+func (f *fakeCache) Invalidate(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(); err != nil {
+		return err
+	}
+	delete(f.users, key)
+	f.invalidated = append(f.invalidated, key)
+	return nil
+}
+
+func (f *fakeCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return 0, f.call()
+}
+
+func (f *fakeCache) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.call()
+}
+
+func newTestBreaker(next UserCache, maxPending int) *CircuitBreaker {
+	return NewCircuitBreaker(next, testLogger(), 3, 5*time.Millisecond, 60, maxPending)
+}
+
+// waitForState polls the breaker until it reaches state or the test times out
+func waitForState(t *testing.T, b *CircuitBreaker, state BreakerState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Status().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker is %s, want %s", b.Status().State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	ctx := context.Background()
+	next := newFakeCache()
+	next.setDown(true)
+	b := newTestBreaker(next, 10)
+
+	for i := 0; i < 3; i++ {
+		// failed reads are served as misses
+		if user, err := b.Get(ctx, "alice"); user != nil || err != nil {
+			t.Fatalf("Get() = %+v, %v, want a miss", user, err)
+		}
+	}
+	if status := b.Status(); status.State == StateClosed || status.TotalErrors != 3 {
+		t.Fatalf("status = %+v, want an open breaker with 3 errors", status)
+	}
+	if err := b.Set(ctx, "alice", &data.User{}); err != ErrCircuitOpen {
+		t.Fatalf("Set() = %v, want ErrCircuitOpen", err)
+	}
+	if next.gets != 3 {
+		t.Fatalf("redis was read %d times, want 3", next.gets)
+	}
+
+	next.setDown(false)
+	waitForState(t, b, StateClosed)
+}
+
+func TestInvalidateWhileOpenIsReplayedBeforeClosing(t *testing.T) {
+	ctx := context.Background()
+	next := newFakeCache()
+	next.users["alice"] = &data.User{Username: "alice", FirstName: "Old"}
+	next.setDown(true)
+	b := newTestBreaker(next, 10)
+	for i := 0; i < 3; i++ {
+		_, _ = b.Get(ctx, "bob")
+	}
+
+	if err := b.Invalidate(ctx, "alice"); err != nil {
+		t.Fatalf("Invalidate() = %v, want it queued", err)
+	}
+	if status := b.Status(); status.PendingInvalidations != 1 {
+		t.Fatalf("pending = %d, want 1", status.PendingInvalidations)
+	}
+
+	next.setDown(false)
+	waitForState(t, b, StateClosed)
+	if user, _ := b.Get(ctx, "alice"); user != nil {
+		t.Fatalf("Get() = %+v after recovery, the invalidated entry was served", user)
+	}
+	if b.Status().PendingInvalidations != 0 {
+		t.Fatal("the queue was not drained")
+	}
+}
+
+func TestFailedInvalidationIsQueued(t *testing.T) {
+	ctx := context.Background()
+	next := newFakeCache()
+	next.users["alice"] = &data.User{Username: "alice", FirstName: "Old"}
+	b := newTestBreaker(next, 10)
+
+	next.setDown(true)
+	if err := b.Invalidate(ctx, "alice"); err != nil {
+		t.Fatalf("Invalidate() = %v, a failed invalidation must be queued", err)
+	}
+	status := b.Status()
+	if status.State == StateClosed || status.PendingInvalidations != 1 {
+		t.Fatalf("status = %+v, want an open breaker with the invalidation queued", status)
+	}
+
+	// the stale entry is still in redis, it must not be read before the replay
+	next.setDown(false)
+	if user, _ := b.Get(ctx, "alice"); user != nil && user.FirstName == "Old" {
+		t.Fatal("the entry that could not be invalidated was served")
+	}
+
+	waitForState(t, b, StateClosed)
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	if len(next.invalidated) != 1 || next.invalidated[0] != "alice" {
+		t.Fatalf("invalidated = %v, want [alice]", next.invalidated)
+	}
+	if _, ok := next.users["alice"]; ok {
+		t.Fatal("the entry is still cached after the replay")
+	}
+}
+
+func TestPendingOverflowHoldsBreakerOpen(t *testing.T) {
+	ctx := context.Background()
+	next := newFakeCache()
+	next.setDown(true)
+	b := newTestBreaker(next, 1)
+
+	_ = b.Invalidate(ctx, "alice")
+	_ = b.Invalidate(ctx, "bob")
+	if status := b.Status(); status.PendingInvalidations != 1 {
+		t.Fatalf("pending = %d, want the queue bounded to 1", status.PendingInvalidations)
+	}
+	b.mu.Lock()
+	hold := b.holdUntil
+	b.mu.Unlock()
+	if time.Until(hold) < 59*time.Second {
+		t.Fatalf("breaker is held until %s, want about a minute from now", hold)
+	}
+}
+
+func TestCallSharesTheBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBreaker(newFakeCache(), 10)
+	for i := 0; i < 3; i++ {
+		if err := b.Call(ctx, func() error { return errRedisDown }); err != errRedisDown {
+			t.Fatalf("Call() = %v, want the error of the call", err)
+		}
+	}
+	if b.Status().State == StateClosed {
+		t.Fatal("failed calls did not open the breaker")
+	}
+	called := false
+	if err := b.Call(ctx, func() error { called = true; return nil }); err != ErrCircuitOpen || called {
+		t.Fatalf("Call() = %v with an open breaker, called: %t", err, called)
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sceyt_task/internal/data"
+)
+
+// protobufCodec encodes users in the protobuf wire format of the message
+//
+//	message User {
+//	  string id = 1;
+//	  string username = 2;
+//	  string firstname = 3;
+//	  string lastname = 4;
+//	  string created_at = 5;
+//	  string updated_at = 6;
+//	  string deleted_at = 7;
+//	}
+//
+// Field numbers must never be reused, unknown fields are skipped when decoding.
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte     { return protobufCodecID }
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) fields(user *data.User) []*string {
+	return []*string{&user.ID, &user.Username, &user.FirstName, &user.LastName,
+		&user.CreatedAt, &user.UpdatedAt, &user.DeletedAt}
+}
+
+func (c protobufCodec) Marshal(user *data.User) ([]byte, error) {
+	var b []byte
+	for i, field := range c.fields(user) {
+		if *field == "" {
+			continue
+		}
+		b = protowire.AppendTag(b, protowire.Number(i+1), protowire.BytesType)
+		b = protowire.AppendString(b, *field)
+	}
+	return b, nil
+}
+
+func (c protobufCodec) Unmarshal(b []byte, user *data.User) error {
+	fields := c.fields(user)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && num >= 1 && int(num) <= len(fields) {
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			*fields[num-1] = v
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/ugorji/go/codec"
+	"sceyt_task/internal/data"
+)
+
+// Codec serializes cached users
+type Codec interface {
+	// ID is written into the header byte of every entry, it must never change
+	ID() byte
+	Name() string
+	Marshal(user *data.User) ([]byte, error)
+	Unmarshal(b []byte, user *data.User) error
+}
+
+const (
+	jsonCodecID     byte = 1
+	msgpackCodecID  byte = 2
+	protobufCodecID byte = 3
+
+	// compressedFlag is set in the header byte when the payload is snappy compressed
+	compressedFlag byte = 0x80
+	codecIDMask    byte = 0x7f
+
+	// legacyJSONHeader is the first byte of json entries written before headers were
+	// introduced, such entries are read as uncompressed json
+	legacyJSONHeader byte = '{'
+)
+
+var codecs = map[byte]Codec{
+	jsonCodecID:     jsonCodec{},
+	msgpackCodecID:  msgpackCodec{},
+	protobufCodecID: protobufCodec{},
+}
+
+// CodecByName returns the codec registered under name
+func CodecByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cache codec %q", name)
+}
+
+// EntryInfo describes how a cached entry was written
+type EntryInfo struct {
+	Codec      string `json:"codec"`
+	Compressed bool   `json:"compressed"`
+	Size       int    `json:"size"`
+}
+
+// Serializer writes entries with the configured codec and reads entries written
+// by any registered codec, the codec is picked from the header byte of the entry.
+// Payloads larger than compressAbove bytes are compressed, 0 disables compression.
+type Serializer struct {
+	codec         Codec
+	compressAbove int
+}
+
+// NewSerializer returns a Serializer writing entries with the given codec
+func NewSerializer(c Codec, compressAbove int) *Serializer {
+	return &Serializer{codec: c, compressAbove: compressAbove}
+}
+
+// Encode serializes the user and prepends the header byte
+func (s *Serializer) Encode(user *data.User) ([]byte, error) {
+	payload, err := s.codec.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	header := s.codec.ID()
+	if s.compressAbove > 0 && len(payload) > s.compressAbove {
+		payload = snappy.Encode(nil, payload)
+		header |= compressedFlag
+	}
+	return append([]byte{header}, payload...), nil
+}
+
+// Decode reads an entry written by Encode with any registered codec
+func (s *Serializer) Decode(b []byte) (*data.User, error) {
+	c, payload, err := s.open(b)
+	if err != nil {
+		return nil, err
+	}
+	user := &data.User{}
+	if err := c.Unmarshal(payload, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Describe returns the codec and compression of an entry without decoding it
+func (s *Serializer) Describe(b []byte) (EntryInfo, error) {
+	if len(b) == 0 {
+		return EntryInfo{}, fmt.Errorf("empty cache entry")
+	}
+	if b[0] == legacyJSONHeader {
+		return EntryInfo{Codec: jsonCodec{}.Name(), Size: len(b)}, nil
+	}
+	c, ok := codecs[b[0]&codecIDMask]
+	if !ok {
+		return EntryInfo{}, fmt.Errorf("unknown cache codec id %d", b[0]&codecIDMask)
+	}
+	return EntryInfo{Codec: c.Name(), Compressed: b[0]&compressedFlag != 0, Size: len(b)}, nil
+}
+
+// open returns the codec of the entry and its uncompressed payload
+func (s *Serializer) open(b []byte) (Codec, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("empty cache entry")
+	}
+	if b[0] == legacyJSONHeader {
+		return jsonCodec{}, b, nil
+	}
+	c, ok := codecs[b[0]&codecIDMask]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown cache codec id %d", b[0]&codecIDMask)
+	}
+	payload := b[1:]
+	if b[0]&compressedFlag != 0 {
+		var err error
+		payload, err = snappy.Decode(nil, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, payload, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte     { return jsonCodecID }
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(user *data.User) ([]byte, error) {
+	return json.Marshal(user)
+}
+
+func (jsonCodec) Unmarshal(b []byte, user *data.User) error {
+	return json.Unmarshal(b, user)
+}
+
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+// msgpackCodec encodes users as msgpack maps keyed by the json field names
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte     { return msgpackCodecID }
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(user *data.User) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(user)
+	return b, err
+}
+
+func (msgpackCodec) Unmarshal(b []byte, user *data.User) error {
+	return codec.NewDecoderBytes(b, msgpackHandle).Decode(user)
+}
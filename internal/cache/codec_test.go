@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sceyt_task/internal/data"
+	"strings"
+	"testing"
+)
+
+var testUser = &data.User{
+	ID:        "5f0c6a1e-3b1b-4c56-9c38-1a2f3b4c5d6e",
+	Username:  "alice",
+	FirstName: "Alice",
+	LastName:  "Liddell",
+	CreatedAt: "2021-09-01 10:00:00",
+	UpdatedAt: "2021-09-02 11:00:00",
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		for _, compressAbove := range []int{0, 1} {
+			c, err := CodecByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewSerializer(c, compressAbove)
+			entry, err := s.Encode(testUser)
+			if err != nil {
+				t.Fatalf("%s: Encode() = %v", name, err)
+			}
+
+			compressed := compressAbove > 0
+			header := c.ID()
+			if compressed {
+				header |= compressedFlag
+			}
+			if entry[0] != header {
+				t.Errorf("%s: header = %#x, want codec %d compressed %t", name, entry[0], c.ID(), compressed)
+			}
+			info, err := s.Describe(entry)
+			if err != nil || info.Codec != name || info.Compressed != compressed || info.Size != len(entry) {
+				t.Errorf("%s: Describe() = %+v, %v", name, info, err)
+			}
+			user, err := s.Decode(entry)
+			if err != nil {
+				t.Fatalf("%s: Decode() = %v", name, err)
+			}
+			if *user != *testUser {
+				t.Errorf("%s: Decode() = %+v, want %+v", name, user, testUser)
+			}
+		}
+	}
+}
+
+// entries are read with the codec of their header, not with the configured one,
+// so the codec can be changed without flushing the cache
+func TestSerializerReadsEntriesOfEveryCodec(t *testing.T) {
+	reader := NewSerializer(jsonCodec{}, 0)
+	for _, c := range []Codec{msgpackCodec{}, protobufCodec{}} {
+		entry, err := NewSerializer(c, 1).Encode(testUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := reader.Decode(entry)
+		if err != nil || *user != *testUser {
+			t.Errorf("Decode() of a %s entry = %+v, %v", c.Name(), user, err)
+		}
+	}
+}
+
+func TestSerializerReadsLegacyJSON(t *testing.T) {
+	legacy, _ := json.Marshal(testUser)
+	s := NewSerializer(protobufCodec{}, 0)
+
+	user, err := s.Decode(legacy)
+	if err != nil || *user != *testUser {
+		t.Fatalf("Decode() = %+v, %v", user, err)
+	}
+	if info, _ := s.Describe(legacy); info.Codec != "json" || info.Compressed {
+		t.Errorf("Describe() = %+v, want uncompressed json", info)
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	s := NewSerializer(jsonCodec{}, 1000)
+	small, _ := s.Encode(testUser)
+	if small[0]&compressedFlag != 0 {
+		t.Error("an entry below the threshold was compressed")
+	}
+
+	large := *testUser
+	large.FirstName = strings.Repeat("a", 2000)
+	entry, _ := s.Encode(&large)
+	if entry[0]&compressedFlag == 0 {
+		t.Fatal("an entry above the threshold was not compressed")
+	}
+	if len(entry) > 1000 {
+		t.Errorf("compressed entry has %d bytes", len(entry))
+	}
+	payload, err := snappy.Decode(nil, entry[1:])
+	if err != nil || !bytes.Contains(payload, []byte(large.FirstName)) {
+		t.Errorf("payload is not snappy compressed json: %v", err)
+	}
+}
+
+func TestDecodeRejectsInvalidEntries(t *testing.T) {
+	s := NewSerializer(jsonCodec{}, 0)
+	tests := map[string][]byte{
+		"empty":          {},
+		"unknown codec":  {0x7f, 'x'},
+		"bad snappy":     {jsonCodecID | compressedFlag, 0xff, 0xff},
+		"bad json":       {jsonCodecID, '{'},
+		"truncated tag":  {protobufCodecID, 0x80},
+		"truncated data": {protobufCodecID, 0x12, 0x05, 'a'},
+	}
+	for name, entry := range tests {
+		if user, err := s.Decode(entry); err == nil {
+			t.Errorf("%s: Decode() = %+v, want an error", name, user)
+		}
+	}
+}
+
+// fields added to the protobuf message later must not break old readers
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+	b, _ := protobufCodec{}.Marshal(testUser)
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 100, protowire.BytesType)
+	b = protowire.AppendString(b, "new field")
+
+	user := &data.User{}
+	if err := (protobufCodec{}).Unmarshal(b, user); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if *user != *testUser {
+		t.Fatalf("Unmarshal() = %+v, want %+v", user, testUser)
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	if _, err := CodecByName("gob"); err == nil {
+		t.Error("CodecByName(gob) succeeded")
+	}
+	names := map[string]bool{}
+	for id, c := range codecs {
+		if c.ID() != id || id&compressedFlag != 0 {
+			t.Errorf("codec %s is registered as %d with id %d", c.Name(), id, c.ID())
+		}
+		if names[c.Name()] {
+			t.Errorf("two codecs are named %s", c.Name())
+		}
+		names[c.Name()] = true
+	}
+}
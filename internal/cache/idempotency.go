@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis"
+	"net/http"
+	"sceyt_task/pkg/logging"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+	// ErrRequestInProgress is returned when the first request with the key has not completed yet
+	ErrRequestInProgress = errors.New("a request with the idempotency key is still being processed")
+)
+
+// StoredResponse is the response replayed for retries of an idempotent request
+type StoredResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// idempotencyRecord is the value stored under an idempotency key, Response is nil
+// while the first request is being processed
+type idempotencyRecord struct {
+	Fingerprint string          `json:"fingerprint"`
+	Response    *StoredResponse `json:"response,omitempty"`
+}
+
+// IdempotencyStore keeps the responses of requests sent with an Idempotency-Key.
+//
+// The first request reserves the key with its fingerprint for lockTimeout, its
+// response is then stored for window. A retry with the same fingerprint gets the
+// stored response, one with another fingerprint is rejected. Redis is called through
+// the circuit breaker of the cache, so the store fails fast while Redis is down.
+type IdempotencyStore struct {
+	client      redis.UniversalClient
+	breaker     *CircuitBreaker
+	keys        KeyScheme
+	window      time.Duration
+	lockTimeout time.Duration
+	logger      logging.Logger
+}
+
+// NewIdempotencyStore returns an IdempotencyStore keeping responses for window
+func NewIdempotencyStore(client redis.UniversalClient, b *CircuitBreaker, keys KeyScheme, window time.Duration, lockTimeout time.Duration, l logging.Logger) *IdempotencyStore {
+	return &IdempotencyStore{client: client, breaker: b, keys: keys, window: window, lockTimeout: lockTimeout, logger: l}
+}
+
+// Begin reserves the key for the request with the given fingerprint. It returns the
+// stored response if the request was already completed, nil if the caller has to
+// process it and call Complete or Release afterwards. ErrCircuitOpen is returned
+// without calling Redis while the breaker is open.
+func (s *IdempotencyStore) Begin(ctx context.Context, key string, fingerprint string) (*StoredResponse, error) {
+	record, _ := json.Marshal(&idempotencyRecord{Fingerprint: fingerprint})
+	var reserved bool
+	err := s.breaker.Call(ctx, func() (err error) {
+		reserved, err = s.client.SetNX(s.keys.Entry(key), record, s.lockTimeout).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reserved {
+		return nil, nil
+	}
+
+	var value []byte
+	err = s.breaker.Call(ctx, func() (err error) {
+		value, err = s.client.Get(s.keys.Entry(key)).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		// the reservation expired in between, the request is treated as a new one
+		return s.Begin(ctx, key, fingerprint)
+	}
+	existing := &idempotencyRecord{}
+	if err = json.Unmarshal(value, existing); err != nil {
+		return nil, err
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.Response == nil {
+		return nil, ErrRequestInProgress
+	}
+	return existing.Response, nil
+}
+
+// Complete stores the response of the request reserved by Begin
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, fingerprint string, response *StoredResponse) error {
+	record, err := json.Marshal(&idempotencyRecord{Fingerprint: fingerprint, Response: response})
+	if err != nil {
+		return err
+	}
+	return s.breaker.Call(ctx, func() error {
+		return s.client.Set(s.keys.Entry(key), record, s.window).Err()
+	})
+}
+
+// Release removes the reservation of a request that failed, so it can be retried
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.breaker.Call(ctx, func() error {
+		return s.client.Del(s.keys.Entry(key)).Err()
+	})
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestIdempotencyStore(t *testing.T, b *CircuitBreaker) (*IdempotencyStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewIdempotencyStore(client, b, testKeys, time.Hour, time.Minute, testLogger()), server
+}
+
+func TestIdempotencyStoreReplaysTheResponse(t *testing.T) {
+	store, server := newTestIdempotencyStore(t, newTestBreaker(newFakeCache(), 10))
+	ctx := context.Background()
+
+	if stored, err := store.Begin(ctx, "key", "a"); stored != nil || err != nil {
+		t.Fatalf("Begin() = %+v, %v, want the key reserved", stored, err)
+	}
+	if ttl := server.TTL(testKeys.Entry("key")); ttl != time.Minute {
+		t.Errorf("reservation ttl = %s, want the lock timeout", ttl)
+	}
+	if _, err := store.Begin(ctx, "key", "a"); err != ErrRequestInProgress {
+		t.Fatalf("Begin() while processing = %v, want ErrRequestInProgress", err)
+	}
+
+	response := &StoredResponse{Status: http.StatusCreated, Header: http.Header{"Location": {"/v2/users/alice"}}, Body: []byte(`{}`)}
+	if err := store.Complete(ctx, "key", "a", response); err != nil {
+		t.Fatalf("Complete() = %v", err)
+	}
+	if ttl := server.TTL(testKeys.Entry("key")); ttl != time.Hour {
+		t.Errorf("response ttl = %s, want the window", ttl)
+	}
+	stored, err := store.Begin(ctx, "key", "a")
+	if err != nil || !reflect.DeepEqual(stored, response) {
+		t.Fatalf("Begin() of a retry = %+v, %v, want the stored response", stored, err)
+	}
+	if _, err = store.Begin(ctx, "key", "b"); err != ErrIdempotencyKeyReused {
+		t.Fatalf("Begin() of another request = %v, want ErrIdempotencyKeyReused", err)
+	}
+}
+
+func TestIdempotencyStoreRelease(t *testing.T) {
+	store, _ := newTestIdempotencyStore(t, newTestBreaker(newFakeCache(), 10))
+	ctx := context.Background()
+	_, _ = store.Begin(ctx, "key", "a")
+	if err := store.Release(ctx, "key"); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	// the released key can be used by any request
+	if stored, err := store.Begin(ctx, "key", "b"); stored != nil || err != nil {
+		t.Fatalf("Begin() after Release() = %+v, %v", stored, err)
+	}
+}
+
+func TestIdempotencyStoreUsesTheBreaker(t *testing.T) {
+	next := newFakeCache()
+	next.setDown(true)
+	b := newTestBreaker(next, 10)
+	store, server := newTestIdempotencyStore(t, b)
+	ctx := context.Background()
+
+	// failures of the store trip the breaker of the cache
+	server.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := store.Begin(ctx, "key", "a"); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("Begin() %d = %v, want the redis error", i, err)
+		}
+	}
+	if state := b.Status().State; state != StateOpen {
+		t.Fatalf("breaker is %s after 3 failures, want open", state)
+	}
+	if _, err := store.Begin(ctx, "key", "a"); err != ErrCircuitOpen {
+		t.Fatalf("Begin() = %v, want ErrCircuitOpen", err)
+	}
+	if err := store.Complete(ctx, "key", "a", &StoredResponse{}); err != ErrCircuitOpen {
+		t.Fatalf("Complete() = %v, want ErrCircuitOpen", err)
+	}
+	if bypassed := b.Status().Bypassed; bypassed != 2 {
+		t.Errorf("bypassed = %d, want 2", bypassed)
+	}
+}
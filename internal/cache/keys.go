@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyScheme builds the redis keys of cached entities.
+//
+// Entries are stored as "<service>[:<tenant>]:<entity>:v<schema>:{<id>}" and their
+// invalidation versions as "<service>[:<tenant>]:<entity>:{<id>}:version". The
+// version key does not depend on the schema version, so an instance still running
+// the previous schema invalidates entries written by the new one during a deploy.
+// Only the id is inside the hash tag, so in Redis Cluster all keys of one entity
+// are stored in the same slot.
+type KeyScheme struct {
+	Service       string
+	Tenant        string
+	Entity        string
+	SchemaVersion int
+}
+
+// Namespace returns the prefix shared by all keys of the entity
+func (k KeyScheme) Namespace() string {
+	parts := []string{k.Service}
+	if k.Tenant != "" {
+		parts = append(parts, k.Tenant)
+	}
+	parts = append(parts, k.Entity)
+	return strings.Join(parts, ":")
+}
+
+// Entry returns the key of the entry stored with the current schema version
+func (k KeyScheme) Entry(id string) string {
+	return k.entry(id, k.SchemaVersion)
+}
+
+// PreviousEntry returns the key of the entry stored with the previous schema version
+func (k KeyScheme) PreviousEntry(id string) string {
+	return k.entry(id, k.SchemaVersion-1)
+}
+
+func (k KeyScheme) entry(id string, schemaVersion int) string {
+	return fmt.Sprintf("%s:v%d:{%s}", k.Namespace(), schemaVersion, id)
+}
+
+// Version returns the key holding the invalidation version of the entity
+func (k KeyScheme) Version(id string) string {
+	return fmt.Sprintf("%s:{%s}:version", k.Namespace(), id)
+}
+
+// Hot returns the key of the sorted set counting reads in the given time window
+func (k KeyScheme) Hot(window int64) string {
+	return fmt.Sprintf("%s:hot:%d", k.Namespace(), window)
+}
@@ -0,0 +1,74 @@
+package cache
+
+import "testing"
+
+func TestKeyScheme(t *testing.T) {
+	tests := []struct {
+		keys                                     KeyScheme
+		namespace, entry, previous, version, hot string
+	}{
+		{
+			keys:      KeyScheme{Service: "user-server", Entity: "user", SchemaVersion: 3},
+			namespace: "user-server:user",
+			entry:     "user-server:user:v3:{alice}",
+			previous:  "user-server:user:v2:{alice}",
+			version:   "user-server:user:{alice}:version",
+			hot:       "user-server:user:hot:42",
+		},
+		{
+			keys:      KeyScheme{Service: "user-server", Tenant: "acme", Entity: "user", SchemaVersion: 1},
+			namespace: "user-server:acme:user",
+			entry:     "user-server:acme:user:v1:{alice}",
+			previous:  "user-server:acme:user:v0:{alice}",
+			version:   "user-server:acme:user:{alice}:version",
+			hot:       "user-server:acme:user:hot:42",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.keys.Namespace(); got != tt.namespace {
+			t.Errorf("Namespace() = %q, want %q", got, tt.namespace)
+		}
+		if got := tt.keys.Entry("alice"); got != tt.entry {
+			t.Errorf("Entry() = %q, want %q", got, tt.entry)
+		}
+		if got := tt.keys.PreviousEntry("alice"); got != tt.previous {
+			t.Errorf("PreviousEntry() = %q, want %q", got, tt.previous)
+		}
+		if got := tt.keys.Version("alice"); got != tt.version {
+			t.Errorf("Version() = %q, want %q", got, tt.version)
+		}
+		if got := tt.keys.Hot(42); got != tt.hot {
+			t.Errorf("Hot() = %q, want %q", got, tt.hot)
+		}
+	}
+}
+
+// in Redis Cluster only the part inside the braces is hashed, every key of one
+// user must have the same hash tag so the multi-key scripts can run
+func TestKeysOfOneUserShareTheHashTag(t *testing.T) {
+	keys := KeyScheme{Service: "user-server", Tenant: "acme", Entity: "user", SchemaVersion: 2}
+	for _, key := range []string{keys.Entry("a:b"), keys.PreviousEntry("a:b"), keys.Version("a:b")} {
+		if tag := hashTag(key); tag != "a:b" {
+			t.Errorf("hash tag of %q = %q, want a:b", key, tag)
+		}
+	}
+}
+
+// hashTag returns the part of the key hashed by Redis Cluster
+func hashTag(key string) string {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterMetrics exposes the counters of the cache and the state of its circuit breaker in r
+func RegisterMetrics(r prometheus.Registerer, a Admin, b *CircuitBreaker) {
+	r.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "user_cache_hits_total", Help: "Number of users read from the cache."},
+			func() float64 { return float64(a.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "user_cache_misses_total", Help: "Number of users not found in the cache."},
+			func() float64 { return float64(a.Stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "user_cache_evictions_total", Help: "Number of users removed from the cache."},
+			func() float64 { return float64(a.Stats().Evictions) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "user_cache_errors_total", Help: "Number of failed redis calls of the cache."},
+			func() float64 { return float64(a.Stats().Errors) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "user_cache_bypassed_total", Help: "Number of cache calls skipped while the circuit breaker was open."},
+			func() float64 { return float64(b.Status().Bypassed) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "user_cache_breaker_open", Help: "1 while the cache circuit breaker bypasses redis, 0 otherwise."},
+			func() float64 {
+				if b.Status().State == StateClosed {
+					return 0
+				}
+				return 1
+			}),
+	)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sceyt_task/internal/data"
+	"strings"
+	"testing"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	c, server := newTestRedisCache(t)
+	breaker := newTestBreaker(c, 10)
+	registry := prometheus.NewRegistry()
+	RegisterMetrics(registry, c, breaker)
+	ctx := context.Background()
+
+	_, _ = breaker.Get(ctx, "alice")
+	_ = breaker.Set(ctx, "alice", &data.User{Username: "alice"})
+	_, _ = breaker.Get(ctx, "alice")
+	_ = breaker.Invalidate(ctx, "alice")
+
+	want := `
+# HELP user_cache_breaker_open 1 while the cache circuit breaker bypasses redis, 0 otherwise.
+# TYPE user_cache_breaker_open gauge
+user_cache_breaker_open 0
+# HELP user_cache_hits_total Number of users read from the cache.
+# TYPE user_cache_hits_total counter
+user_cache_hits_total 1
+# HELP user_cache_misses_total Number of users not found in the cache.
+# TYPE user_cache_misses_total counter
+user_cache_misses_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"user_cache_hits_total", "user_cache_misses_total", "user_cache_breaker_open"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed calls open the breaker, the next calls bypass redis
+	server.SetError("redis is down")
+	for i := 0; i < 4; i++ {
+		_, _ = breaker.Get(ctx, "alice")
+	}
+	for name, want := range map[string]float64{
+		"user_cache_errors_total":   3,
+		"user_cache_bypassed_total": 1,
+		"user_cache_breaker_open":   1,
+	} {
+		if got := gathered(t, registry, name); got != want {
+			t.Errorf("%s = %g, want %g", name, got, want)
+		}
+	}
+	if problems, err := testutil.GatherAndLint(registry); err != nil || len(problems) > 0 {
+		t.Fatalf("lint = %v, %v", problems, err)
+	}
+}
+
+// gathered returns the value of the counter or gauge without labels
+func gathered(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			m := family.Metric[0]
+			if m.Counter != nil {
+				return m.Counter.GetValue()
+			}
+			return m.Gauge.GetValue()
+		}
+	}
+	t.Fatalf("%s is not registered", name)
+	return 0
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"github.com/go-redis/redis"
+	"math"
+	"sceyt_task/pkg/logging"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucketScript takes one token from the bucket stored in KEYS[1]. The bucket is
+// refilled with ARGV[1] tokens per second up to ARGV[2] tokens, the time is read from
+// redis so all instances use the same clock. It returns whether the token was taken,
+// the tokens left and the milliseconds until the next token is available.
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+return {allowed, math.floor(tokens), wait}
+`)
+
+// Limit is a token bucket refilled with Rate tokens per second and holding at most Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is the outcome of taking a token from a bucket
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the time until the next token is available
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+}
+
+func newDecision(limit Limit, allowed bool, tokens float64, retryAfter time.Duration) Decision {
+	reset := time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second))
+	return Decision{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		Remaining:  int(math.Floor(tokens)),
+		RetryAfter: retryAfter,
+		Reset:      reset,
+	}
+}
+
+// RateLimiter takes tokens from the bucket of a key
+type RateLimiter interface {
+	Allow(key string, limit Limit) (Decision, error)
+}
+
+type redisRateLimiter struct {
+	client redis.UniversalClient
+	keys   KeyScheme
+}
+
+// NewRedisRateLimiter returns a RateLimiter keeping the buckets in redis, so they are shared by all instances
+func NewRedisRateLimiter(client redis.UniversalClient, keys KeyScheme) RateLimiter {
+	return &redisRateLimiter{client: client, keys: keys}
+}
+
+func (r *redisRateLimiter) Allow(key string, limit Limit) (Decision, error) {
+	res, err := tokenBucketScript.Run(r.client, []string{r.keys.Entry(key)},
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), limit.Burst).Result()
+	if err != nil {
+		return Decision{}, err
+	}
+	values := res.([]interface{})
+	allowed, _ := values[0].(int64)
+	tokens, _ := values[1].(int64)
+	wait, _ := values[2].(int64)
+	return newDecision(limit, allowed == 1, float64(tokens), time.Duration(wait)*time.Millisecond), nil
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// localRateLimiter keeps the buckets in memory, at most maxBuckets of them
+type localRateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	maxBuckets int
+}
+
+// NewLocalRateLimiter returns a RateLimiter keeping the buckets of this instance in memory
+func NewLocalRateLimiter(maxBuckets int) RateLimiter {
+	return &localRateLimiter{buckets: map[string]*bucket{}, maxBuckets: maxBuckets}
+}
+
+func (l *localRateLimiter) Allow(key string, limit Limit) (Decision, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			l.prune(now, limit)
+		}
+		b = &bucket{tokens: float64(limit.Burst), at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.at).Seconds()*limit.Rate)
+	b.at = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return newDecision(limit, false, b.tokens, wait), nil
+	}
+	b.tokens--
+	return newDecision(limit, true, b.tokens, 0), nil
+}
+
+// prune drops the buckets that are full again, they behave like new ones. If all
+// buckets are in use the whole map is dropped rather than growing without bounds.
+func (l *localRateLimiter) prune(now time.Time, limit Limit) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= l.maxBuckets {
+		l.buckets = map[string]*bucket{}
+	}
+}
+
+// FallbackRateLimiter uses the shared limiter and falls back to the local one when it fails,
+// during a redis outage every instance then enforces the limits on its own. After a
+// failure the shared limiter is not called again for cooldown, so requests are not
+// slowed down by the redis timeout.
+type FallbackRateLimiter struct {
+	// downUntil is the unix time in nanoseconds until which the local limiter is used,
+	// it is first so it is 64-bit aligned for atomic access
+	downUntil int64
+
+	shared   RateLimiter
+	local    RateLimiter
+	cooldown time.Duration
+	logger   logging.Logger
+}
+
+// NewFallbackRateLimiter returns a FallbackRateLimiter
+func NewFallbackRateLimiter(shared RateLimiter, local RateLimiter, cooldown time.Duration, l logging.Logger) *FallbackRateLimiter {
+	return &FallbackRateLimiter{shared: shared, local: local, cooldown: cooldown, logger: l}
+}
+
+func (f *FallbackRateLimiter) Allow(key string, limit Limit) (Decision, error) {
+	now := time.Now()
+	if now.UnixNano() >= atomic.LoadInt64(&f.downUntil) {
+		decision, err := f.shared.Allow(key, limit)
+		if err == nil {
+			return decision, nil
+		}
+		f.logger.Warn("shared rate limiter failed, using the local one: ", err)
+		atomic.StoreInt64(&f.downUntil, now.Add(f.cooldown).UnixNano())
+	}
+	return f.local.Allow(key, limit)
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedisRateLimiterTakesTokens(t *testing.T) {
+	c, server := newTestRedisCache(t)
+	server.SetTime(time.Unix(1600000000, 0))
+	limiter := NewRedisRateLimiter(c.client, testKeys)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i, remaining := range []int{2, 1, 0} {
+		d, err := limiter.Allow("ip:1", limit)
+		if err != nil {
+			t.Fatalf("Allow() = %v", err)
+		}
+		if !d.Allowed || d.Remaining != remaining || d.Limit != 3 {
+			t.Fatalf("Allow() %d = %+v, want allowed with %d remaining", i, d, remaining)
+		}
+	}
+	d, _ := limiter.Allow("ip:1", limit)
+	if d.Allowed || d.RetryAfter != time.Second || d.Reset != 3*time.Second {
+		t.Fatalf("Allow() over the limit = %+v, want denied for 1s", d)
+	}
+	if ttl := server.TTL(testKeys.Entry("ip:1")); ttl != 3*time.Second {
+		t.Errorf("bucket ttl = %s, want the time to refill it", ttl)
+	}
+	if d, _ = limiter.Allow("ip:2", limit); !d.Allowed {
+		t.Fatal("the bucket of another key is empty")
+	}
+
+	// the bucket is refilled with the clock of redis
+	server.SetTime(time.Unix(1600000002, 0))
+	if d, _ = limiter.Allow("ip:1", limit); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("Allow() after 2s = %+v, want allowed with 1 remaining", d)
+	}
+	server.SetTime(time.Unix(1600000100, 0))
+	if d, _ = limiter.Allow("ip:1", limit); d.Remaining != 2 {
+		t.Fatalf("Allow() after a long pause = %+v, want the bucket capped at the burst", d)
+	}
+}
+
+func TestLocalRateLimiterTakesTokens(t *testing.T) {
+	limiter := NewLocalRateLimiter(10)
+	limit := Limit{Rate: 0.001, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if d, _ := limiter.Allow("ip:1", limit); !d.Allowed || d.Remaining != 1-i {
+			t.Fatalf("Allow() %d = %+v", i, d)
+		}
+	}
+	d, _ := limiter.Allow("ip:1", limit)
+	if d.Allowed || d.RetryAfter < 999*time.Second || d.RetryAfter > 1000*time.Second {
+		t.Fatalf("Allow() over the limit = %+v, want denied for about 1000s", d)
+	}
+	if d, _ = limiter.Allow("ip:2", limit); !d.Allowed {
+		t.Fatal("the bucket of another key is empty")
+	}
+}
+
+func TestLocalRateLimiterBoundsTheBuckets(t *testing.T) {
+	limiter := NewLocalRateLimiter(2).(*localRateLimiter)
+	slow := Limit{Rate: 0.001, Burst: 1}
+	_, _ = limiter.Allow("a", slow)
+	_, _ = limiter.Allow("b", slow)
+	// both buckets are in use, the map is dropped instead of growing
+	_, _ = limiter.Allow("c", slow)
+	if len(limiter.buckets) != 1 {
+		t.Fatalf("%d buckets are kept, want only the new one", len(limiter.buckets))
+	}
+
+	// buckets that are full again behave like new ones and are pruned first
+	fast := Limit{Rate: 1000000, Burst: 1}
+	limiter = NewLocalRateLimiter(2).(*localRateLimiter)
+	_, _ = limiter.Allow("a", fast)
+	_, _ = limiter.Allow("b", fast)
+	time.Sleep(time.Millisecond)
+	if d, _ := limiter.Allow("c", fast); !d.Allowed || len(limiter.buckets) != 1 {
+		t.Fatalf("%d buckets are kept, want the full ones pruned", len(limiter.buckets))
+	}
+}
+
+// countingLimiter fails with err and counts its calls
+type countingLimiter struct {
+	calls int
+	err   error
+}
+
+func (c *countingLimiter) Allow(key string, limit Limit) (Decision, error) {
+	c.calls++
+	if c.err != nil {
+		return Decision{}, c.err
+	}
+	return Decision{Allowed: true, Limit: limit.Burst, Remaining: 42}, nil
+}
+
+func TestFallbackRateLimiter(t *testing.T) {
+	shared := &countingLimiter{err: errors.New("redis is down")}
+	local := &countingLimiter{}
+	limiter := NewFallbackRateLimiter(shared, local, 20*time.Millisecond, testLogger())
+	limit := Limit{Rate: 1, Burst: 5}
+
+	for i := 0; i < 3; i++ {
+		if d, err := limiter.Allow("ip:1", limit); err != nil || d.Remaining != 42 {
+			t.Fatalf("Allow() = %+v, %v, want the decision of the local limiter", d, err)
+		}
+	}
+	// the shared limiter is not called again during the cooldown
+	if shared.calls != 1 || local.calls != 3 {
+		t.Fatalf("shared calls = %d, local calls = %d, want 1 and 3", shared.calls, local.calls)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	shared.err = nil
+	if _, _ = limiter.Allow("ip:1", limit); shared.calls != 2 || local.calls != 3 {
+		t.Fatalf("shared calls = %d, local calls = %d after the cooldown", shared.calls, local.calls)
+	}
+}
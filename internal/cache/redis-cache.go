@@ -1,66 +1,143 @@
 package cache
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"github.com/go-redis/redis"
 	"sceyt_task/internal/data"
 	"sceyt_task/pkg/logging"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+// setIfVersionScript writes the entry only when the version key still holds the
+// version that was read before the value was loaded from the database
+var setIfVersionScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[2]) or '0'
+if current ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'EX', ARGV[3])
+return 1
+`)
+
+// invalidateScript bumps the version key and removes the entry in one step,
+// so every in-flight SetIfVersion started before it is rejected. The entry written
+// with the previous schema version is removed as well, it may still be read by
+// instances that were not redeployed yet.
+var invalidateScript = redis.NewScript(`
+redis.call('INCR', KEYS[2])
+redis.call('EXPIRE', KEYS[2], ARGV[1])
+redis.call('DEL', KEYS[1], KEYS[3])
+return 1
+`)
+
 type redisCache struct {
-	host    string
-	db      int
-	expires time.Duration
-	logger  logging.Logger
+	client     redis.UniversalClient
+	keys       KeyScheme
+	serializer *Serializer
+	expires    time.Duration
+	logger     logging.Logger
+	stats      stats
 }
 
-func NewRedisCache(host string, db int, exp time.Duration) UserCache {
-	return &redisCache{host: host, db: db, expires: exp}
+// NewRedisCache returns a RedisCache stored in the given standalone, sentinel or cluster client
+func NewRedisCache(client redis.UniversalClient, keys KeyScheme, s *Serializer, exp time.Duration, l logging.Logger) RedisCache {
+	return &redisCache{client: client, keys: keys, serializer: s, expires: exp, logger: l}
 }
 
-func (r *redisCache) getClient() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     r.host,
-		Password: "",
-		DB:       r.db,
-	})
+func (r *redisCache) Set(ctx context.Context, key string, value *data.User) error {
+	entry, err := r.serializer.Encode(value)
+	if err != nil {
+		return err
+	}
+	return r.stats.countError(r.client.Set(r.keys.Entry(key), entry, r.expires*time.Second).Err())
 }
 
-func (r *redisCache) Set(key string, value *data.User) error {
-	client := r.getClient()
-	json, err := json.Marshal(value)
+func (r *redisCache) Get(ctx context.Context, key string) (*data.User, error) {
+	res, err := r.client.Get(r.keys.Entry(key)).Bytes()
+	if err == redis.Nil {
+		atomic.AddUint64(&r.stats.misses, 1)
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, r.stats.countError(err)
 	}
-	client.Set(key, json, r.expires*time.Second)
-	_, err = client.Get(key).Result()
+	user, err := r.serializer.Decode(res)
 	if err != nil {
-		fmt.Println(err)
+		// an entry that can not be decoded is treated as a miss and removed,
+		// it is reloaded from the database by the caller
+		logging.FromContext(ctx, r.logger).Warn("dropping undecodable cache entry ", r.keys.Entry(key), ": ", err)
+		_ = r.client.Del(r.keys.Entry(key)).Err()
+		atomic.AddUint64(&r.stats.misses, 1)
+		atomic.AddUint64(&r.stats.evictions, 1)
+		return nil, nil
 	}
-	return nil
+	atomic.AddUint64(&r.stats.hits, 1)
+	return user, nil
 }
 
-func (r *redisCache) Get(key string) (*data.User, error) {
-	client := r.getClient()
-	res, err := client.Get(key).Result()
+func (r *redisCache) Del(ctx context.Context, key string) error {
+	_, err := r.client.Del(r.keys.Entry(key)).Result()
 	if err != nil {
-		return nil, nil
+		return r.stats.countError(err)
+	}
+	atomic.AddUint64(&r.stats.evictions, 1)
+	return nil
+}
+
+func (r *redisCache) Version(ctx context.Context, key string) (int64, error) {
+	version, err := r.client.Get(r.keys.Version(key)).Int64()
+	if err == redis.Nil {
+		return 0, nil
 	}
-	user := &data.User{}
-	err = json.Unmarshal([]byte(res), &user)
 	if err != nil {
-		return nil, err
+		return 0, r.stats.countError(err)
 	}
-	return user, nil
+	return version, nil
 }
 
-func (r *redisCache) Del(key string) error {
-	client := r.getClient()
-	_, err := client.Del(key).Result()
+func (r *redisCache) SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error {
+	entry, err := r.serializer.Encode(value)
 	if err != nil {
 		return err
 	}
+	ttl := int64(r.expires)
+	stored, err := setIfVersionScript.Run(r.client, []string{r.keys.Entry(key), r.keys.Version(key)},
+		strconv.FormatInt(version, 10), entry, ttl).Int64()
+	if err != nil {
+		return r.stats.countError(err)
+	}
+	if stored == 0 {
+		return ErrStaleVersion
+	}
+	return nil
+}
+
+func (r *redisCache) Invalidate(ctx context.Context, key string) error {
+	// the version key has to outlive every load that may still be in flight,
+	// keeping it as long as a cache entry is more than enough
+	ttl := int64(r.expires)
+	err := invalidateScript.Run(r.client, []string{r.keys.Entry(key), r.keys.Version(key), r.keys.PreviousEntry(key)}, ttl).Err()
+	if err != nil {
+		return r.stats.countError(err)
+	}
+	atomic.AddUint64(&r.stats.evictions, 1)
 	return nil
 }
+
+func (r *redisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(r.keys.Entry(key)).Result()
+	if err != nil {
+		return 0, r.stats.countError(err)
+	}
+	// negative values mean the key does not exist or has no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *redisCache) Ping(ctx context.Context) error {
+	return r.stats.countError(r.client.Ping().Err())
+}
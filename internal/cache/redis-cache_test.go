@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"testing"
+	"time"
+)
+
+var testKeys = KeyScheme{Service: "user-server", Entity: "user", SchemaVersion: 2}
+
+func testLogger() logging.Logger {
+	l := logrus.New()
+	l.SetLevel(logrus.PanicLevel)
+	return logging.Logger{Entry: logrus.NewEntry(l)}
+}
+
+// newTestRedisCache returns a redisCache backed by an in-memory redis server
+func newTestRedisCache(t *testing.T) (*redisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	c := NewRedisCache(client, testKeys, NewSerializer(jsonCodec{}, 0), 60, testLogger())
+	return c.(*redisCache), server
+}
+
+func TestSetIfVersionStoresUnchangedEntry(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedisCache(t)
+
+	version, err := c.Version(ctx, "alice")
+	if err != nil || version != 0 {
+		t.Fatalf("Version() = %d, %v, want 0, nil", version, err)
+	}
+	if err = c.SetIfVersion(ctx, "alice", &data.User{Username: "alice", FirstName: "Alice"}, version); err != nil {
+		t.Fatalf("SetIfVersion() = %v", err)
+	}
+
+	user, err := c.Get(ctx, "alice")
+	if err != nil || user == nil || user.FirstName != "Alice" {
+		t.Fatalf("Get() = %+v, %v, want the stored user", user, err)
+	}
+	if ttl := server.TTL(testKeys.Entry("alice")); ttl != 60*time.Second {
+		t.Errorf("entry ttl = %s, want 1m0s", ttl)
+	}
+}
+
+func TestSetIfVersionRejectsLoadStartedBeforeInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestRedisCache(t)
+
+	version, _ := c.Version(ctx, "alice")
+	// an update commits and invalidates while the load is reading the old row
+	if err := c.Invalidate(ctx, "alice"); err != nil {
+		t.Fatalf("Invalidate() = %v", err)
+	}
+	err := c.SetIfVersion(ctx, "alice", &data.User{Username: "alice", FirstName: "Old"}, version)
+	if err != ErrStaleVersion {
+		t.Fatalf("SetIfVersion() = %v, want ErrStaleVersion", err)
+	}
+	if user, _ := c.Get(ctx, "alice"); user != nil {
+		t.Fatalf("Get() = %+v, the stale user must not be cached", user)
+	}
+
+	version, _ = c.Version(ctx, "alice")
+	if version != 1 {
+		t.Fatalf("Version() = %d after one invalidation, want 1", version)
+	}
+	if err = c.SetIfVersion(ctx, "alice", &data.User{Username: "alice", FirstName: "New"}, version); err != nil {
+		t.Fatalf("SetIfVersion() with the current version = %v", err)
+	}
+}
+
+func TestInvalidateRemovesBothSchemaVersions(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedisCache(t)
+
+	if err := c.Set(ctx, "alice", &data.User{Username: "alice"}); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if err := server.Set(testKeys.PreviousEntry("alice"), "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invalidate(ctx, "alice"); err != nil {
+		t.Fatalf("Invalidate() = %v", err)
+	}
+
+	for _, key := range []string{testKeys.Entry("alice"), testKeys.PreviousEntry("alice")} {
+		if server.Exists(key) {
+			t.Errorf("%s still exists after Invalidate", key)
+		}
+	}
+	if ttl := server.TTL(testKeys.Version("alice")); ttl != 60*time.Second {
+		t.Errorf("version ttl = %s, want 1m0s", ttl)
+	}
+}
+
+func TestGetDropsUndecodableEntry(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestRedisCache(t)
+	if err := server.Set(testKeys.Entry("alice"), "\xffgarbage"); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := c.Get(ctx, "alice")
+	if user != nil || err != nil {
+		t.Fatalf("Get() = %+v, %v, want a miss", user, err)
+	}
+	if server.Exists(testKeys.Entry("alice")) {
+		t.Error("the undecodable entry was not removed")
+	}
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+// Redis deployment modes supported by NewRedisClient
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// NewRedisClient returns a client for the given deployment mode.
+//
+// In standalone mode the first address is used, in sentinel mode the addresses are
+// the sentinels and masterName selects the monitored master, in cluster mode the
+// addresses are the seed nodes and db is ignored.
+func NewRedisClient(mode string, addrs []string, masterName string, password string, db int, timeout time.Duration) (redis.UniversalClient, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no redis address configured")
+	}
+	switch mode {
+	case ModeStandalone, "":
+		return redis.NewClient(&redis.Options{
+			Addr:        addrs[0],
+			Password:    password,
+			DB:          db,
+			DialTimeout: timeout,
+			ReadTimeout: timeout,
+			MaxRetries:  0,
+		}), nil
+	case ModeSentinel:
+		if masterName == "" {
+			return nil, fmt.Errorf("redis sentinel mode requires a master name")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    masterName,
+			SentinelAddrs: addrs,
+			Password:      password,
+			DB:            db,
+			DialTimeout:   timeout,
+			ReadTimeout:   timeout,
+			MaxRetries:    0,
+		}), nil
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:       addrs,
+			Password:    password,
+			DialTimeout: timeout,
+			ReadTimeout: timeout,
+			MaxRetries:  0,
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown redis mode %q", mode)
+}
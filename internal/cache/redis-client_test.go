@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"testing"
+	"time"
+)
+
+func TestNewRedisClientModes(t *testing.T) {
+	tests := []struct {
+		mode       string
+		addrs      []string
+		masterName string
+		cluster    bool
+	}{
+		{ModeStandalone, []string{"a:6379", "b:6379"}, "", false},
+		{"", []string{"a:6379"}, "", false},
+		{ModeSentinel, []string{"a:26379", "b:26379"}, "mymaster", false},
+		{ModeCluster, []string{"a:7000", "b:7000"}, "", true},
+	}
+	for _, tt := range tests {
+		client, err := NewRedisClient(tt.mode, tt.addrs, tt.masterName, "", 0, time.Second)
+		if err != nil {
+			t.Fatalf("NewRedisClient(%q) = %v", tt.mode, err)
+		}
+		if _, cluster := client.(*redis.ClusterClient); cluster != tt.cluster {
+			t.Errorf("NewRedisClient(%q) = %T, want a cluster client: %t", tt.mode, client, tt.cluster)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestNewRedisClientRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		addrs      []string
+		masterName string
+	}{
+		{"no address", ModeStandalone, nil, ""},
+		{"sentinel without master", ModeSentinel, []string{"a:26379"}, ""},
+		{"unknown mode", "replicated", []string{"a:6379"}, ""},
+	}
+	for _, tt := range tests {
+		if _, err := NewRedisClient(tt.mode, tt.addrs, tt.masterName, "", 0, time.Second); err == nil {
+			t.Errorf("%s: NewRedisClient succeeded", tt.name)
+		}
+	}
+}
+
+func TestNewRedisClientStandaloneUsesFirstAddress(t *testing.T) {
+	server := miniredis.RunT(t)
+	client, err := NewRedisClient(ModeStandalone, []string{server.Addr(), "127.0.0.1:1"}, "", "", 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+}
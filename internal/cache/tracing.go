@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sceyt_task/internal/data"
+	"time"
+)
+
+// tracerName is the instrumentation scope of the cache spans
+const tracerName = "sceyt_task/internal/cache"
+
+// tracedUserCache records a client span for every call of the wrapped cache
+type tracedUserCache struct {
+	next   UserCache
+	tracer trace.Tracer
+}
+
+// NewTracedUserCache returns a UserCache tracing the calls of next as children of the span of the context
+func NewTracedUserCache(next UserCache, tp trace.TracerProvider) UserCache {
+	return &tracedUserCache{next: next, tracer: tp.Tracer(tracerName)}
+}
+
+func (c *tracedUserCache) start(ctx context.Context, method string, key string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.String("db.system", "redis")}
+	if key != "" {
+		attributes = append(attributes, attribute.String("cache.key", key))
+	}
+	return c.tracer.Start(ctx, "cache."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+func finish(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *tracedUserCache) Set(ctx context.Context, key string, value *data.User) error {
+	ctx, span := c.start(ctx, "Set", key)
+	err := c.next.Set(ctx, key, value)
+	finish(span, err)
+	return err
+}
+
+func (c *tracedUserCache) Get(ctx context.Context, key string) (*data.User, error) {
+	ctx, span := c.start(ctx, "Get", key)
+	user, err := c.next.Get(ctx, key)
+	if err == nil {
+		span.SetAttributes(attribute.Bool("cache.hit", user != nil))
+	}
+	finish(span, err)
+	return user, err
+}
+
+func (c *tracedUserCache) Del(ctx context.Context, key string) error {
+	ctx, span := c.start(ctx, "Del", key)
+	err := c.next.Del(ctx, key)
+	finish(span, err)
+	return err
+}
+
+func (c *tracedUserCache) Version(ctx context.Context, key string) (int64, error) {
+	ctx, span := c.start(ctx, "Version", key)
+	version, err := c.next.Version(ctx, key)
+	finish(span, err)
+	return version, err
+}
+
+func (c *tracedUserCache) SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error {
+	ctx, span := c.start(ctx, "SetIfVersion", key)
+	span.SetAttributes(attribute.Int64("cache.version", version))
+	err := c.next.SetIfVersion(ctx, key, value, version)
+	finish(span, err)
+	return err
+}
+
+func (c *tracedUserCache) Invalidate(ctx context.Context, key string) error {
+	ctx, span := c.start(ctx, "Invalidate", key)
+	err := c.next.Invalidate(ctx, key)
+	finish(span, err)
+	return err
+}
+
+func (c *tracedUserCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, span := c.start(ctx, "TTL", key)
+	ttl, err := c.next.TTL(ctx, key)
+	finish(span, err)
+	return ttl, err
+}
+
+func (c *tracedUserCache) Ping(ctx context.Context) error {
+	ctx, span := c.start(ctx, "Ping", "")
+	err := c.next.Ping(ctx)
+	finish(span, err)
+	return err
+}
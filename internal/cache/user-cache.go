@@ -1,9 +1,31 @@
 package cache
 
-import "sceyt_task/internal/data"
+import (
+	"context"
+	"errors"
+	"sceyt_task/internal/data"
+	"time"
+)
 
+// ErrStaleVersion is returned by SetIfVersion when the entry was invalidated
+// after the version was read, so the value being written may already be outdated
+var ErrStaleVersion = errors.New("cache entry was invalidated concurrently")
+
+// UserCache stores users by username, the context carries the logger of the request
 type UserCache interface {
-	Set(key string, value *data.User) error
-	Get(key string) (*data.User, error)
-	Del(key string) error
+	Set(ctx context.Context, key string, value *data.User) error
+	Get(ctx context.Context, key string) (*data.User, error)
+	Del(ctx context.Context, key string) error
+
+	// Version returns the current invalidation version of the key. It must be read
+	// before loading the value from the database and passed to SetIfVersion.
+	Version(ctx context.Context, key string) (int64, error)
+	// SetIfVersion stores the value only if the key was not invalidated since version was read
+	SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error
+	// Invalidate bumps the version of the key and removes the cached value
+	Invalidate(ctx context.Context, key string) error
+	// TTL returns the remaining lifetime of the entry, 0 if it is not cached
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Ping checks that the cache is reachable
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"sync"
+	"time"
+)
+
+// Loader loads the value of a key from the source of truth
+type Loader func(ctx context.Context, key string) (*data.User, error)
+
+// Warmer preloads the hottest keys into the cache at startup and reloads them
+// shortly before they expire, so hot users never fall out of the cache
+type Warmer struct {
+	cache        UserCache
+	tracker      *AccessTracker
+	load         Loader
+	topN         int
+	refreshAhead time.Duration
+	interval     time.Duration
+	logger       logging.Logger
+
+	stop     chan struct{}
+	warmedUp chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWarmer returns a Warmer keeping the topN keys of tracker cached. Every interval
+// the access counts are flushed and entries expiring within refreshAhead are reloaded.
+func NewWarmer(c UserCache, t *AccessTracker, load Loader, topN int, refreshAhead time.Duration, interval time.Duration, l logging.Logger) *Warmer {
+	return &Warmer{
+		cache:        c,
+		tracker:      t,
+		load:         load,
+		topN:         topN,
+		refreshAhead: refreshAhead,
+		interval:     interval,
+		logger:       l,
+		stop:         make(chan struct{}),
+		warmedUp:     make(chan struct{}),
+	}
+}
+
+// Start warms the cache up and keeps refreshing it in the background until Stop is called
+func (w *Warmer) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.logger.Info("cache warm-up started")
+		refreshed := w.refreshDue()
+		w.logger.Info("cache warm-up finished, ", refreshed, " users loaded")
+		close(w.warmedUp)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if err := w.tracker.Flush(); err != nil {
+					w.logger.Warn("error while flushing cache access counts", "error", err)
+				}
+				w.refreshDue()
+			}
+		}
+	}()
+}
+
+// WarmedUp is closed once the warm-up started by Start finished
+func (w *Warmer) WarmedUp() <-chan struct{} {
+	return w.warmedUp
+}
+
+// Stop stops the background refresh and waits for it to finish
+func (w *Warmer) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+	if err := w.tracker.Flush(); err != nil {
+		w.logger.Warn("error while flushing cache access counts", "error", err)
+	}
+}
+
+// refreshDue reloads the hot keys that are missing or expire soon and returns their number
+func (w *Warmer) refreshDue() int {
+	keys, err := w.tracker.Top(w.topN)
+	if err != nil {
+		w.logger.Warn("error while reading hot cache keys", "error", err)
+		return 0
+	}
+	refreshed := 0
+	for _, key := range keys {
+		select {
+		case <-w.stop:
+			return refreshed
+		default:
+		}
+		ttl, err := w.cache.TTL(context.Background(), key)
+		if err == ErrCircuitOpen {
+			return refreshed
+		}
+		if err != nil {
+			w.logger.Warn("error while reading cache ttl", "error", err)
+			return refreshed
+		}
+		if ttl > w.refreshAhead {
+			continue
+		}
+		if w.refresh(key) {
+			refreshed++
+		}
+	}
+	return refreshed
+}
+
+// refresh reloads one key, the versioned write makes sure a concurrent update wins
+func (w *Warmer) refresh(key string) bool {
+	ctx := context.Background()
+	version, err := w.cache.Version(ctx, key)
+	if err != nil {
+		return false
+	}
+	user, err := w.load(ctx, key)
+	if err != nil {
+		w.logger.Debug("skipping refresh of ", key, ": ", err)
+		return false
+	}
+	err = w.cache.SetIfVersion(ctx, key, user, version)
+	if err != nil && err != ErrStaleVersion {
+		w.logger.Warn("error while refreshing cached user", "error", err)
+		return false
+	}
+	return err == nil
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"reflect"
+	"sceyt_task/internal/data"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTracker(t *testing.T, maxTracked int) (*AccessTracker, *redisCache) {
+	t.Helper()
+	c, server := newTestRedisCache(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewAccessTracker(c, client, testKeys, time.Minute, maxTracked, testLogger()), c
+}
+
+func TestAccessTrackerRanksKeysByReads(t *testing.T) {
+	ctx := context.Background()
+	tracker, _ := newTestTracker(t, 10)
+	for _, key := range []string{"alice", "bob", "alice", "carol", "alice", "bob"} {
+		_, _ = tracker.Get(ctx, key)
+	}
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	// counts of later flushes are added to the same window
+	_, _ = tracker.Get(ctx, "carol")
+	_, _ = tracker.Get(ctx, "carol")
+	_, _ = tracker.Get(ctx, "carol")
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	top, err := tracker.Top(2)
+	if err != nil {
+		t.Fatalf("Top() = %v", err)
+	}
+	if want := []string{"carol", "alice"}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("Top(2) = %v, want %v", top, want)
+	}
+}
+
+func TestAccessTrackerBoundsTrackedKeys(t *testing.T) {
+	ctx := context.Background()
+	tracker, _ := newTestTracker(t, 2)
+	for _, key := range []string{"alice", "bob", "carol", "alice"} {
+		_, _ = tracker.Get(ctx, key)
+	}
+	_ = tracker.Flush()
+
+	top, _ := tracker.Top(10)
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("Top() = %v, want %v, carol is over the limit", top, want)
+	}
+}
+
+func TestAccessTrackerReadsThePreviousWindow(t *testing.T) {
+	ctx := context.Background()
+	tracker, c := newTestTracker(t, 10)
+	previous := tracker.keys.Hot(tracker.bucket(time.Now()) - 1)
+	if err := c.client.ZIncrBy(previous, 5, "alice").Err(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = tracker.Get(ctx, "bob")
+	_ = tracker.Flush()
+
+	top, _ := tracker.Top(10)
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("Top() = %v, want %v", top, want)
+	}
+}
+
+// countingLoader loads users from a map and counts the loads
+type countingLoader struct {
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (l *countingLoader) load(ctx context.Context, key string) (*data.User, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loads[key]++
+	return &data.User{Username: key, FirstName: "loaded"}, nil
+}
+
+func (l *countingLoader) count(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loads[key]
+}
+
+func TestWarmerLoadsHotKeysAtStartup(t *testing.T) {
+	ctx := context.Background()
+	tracker, c := newTestTracker(t, 10)
+	for _, key := range []string{"alice", "alice", "alice", "bob", "bob", "carol"} {
+		_, _ = tracker.Get(ctx, key)
+	}
+	_ = tracker.Flush()
+	// bob is cached for long enough, it is not reloaded
+	_ = c.Set(ctx, "bob", &data.User{Username: "bob"})
+
+	loader := &countingLoader{loads: map[string]int{}}
+	w := NewWarmer(tracker, tracker, loader.load, 2, 10*time.Second, time.Hour, testLogger())
+	// the warm-up at startup refreshes every hot key that is missing or expires soon
+	if refreshed := w.refreshDue(); refreshed != 1 {
+		t.Fatalf("refreshDue() = %d, want 1", refreshed)
+	}
+
+	if user, _ := c.Get(ctx, "alice"); user == nil || user.FirstName != "loaded" {
+		t.Fatalf("alice = %+v, want it loaded", user)
+	}
+	if loader.count("bob") != 0 {
+		t.Error("bob was reloaded although it does not expire soon")
+	}
+	if loader.count("carol") != 0 {
+		t.Error("carol was loaded although it is not in the top 2")
+	}
+}
+
+func TestWarmerRefreshesEntriesAboutToExpire(t *testing.T) {
+	ctx := context.Background()
+	tracker, c := newTestTracker(t, 10)
+	_, _ = tracker.Get(ctx, "alice")
+	_ = tracker.Flush()
+	_ = c.client.Set(testKeys.Entry("alice"), "stale", 5*time.Second).Err()
+
+	loader := &countingLoader{loads: map[string]int{}}
+	w := NewWarmer(tracker, tracker, loader.load, 10, 10*time.Second, time.Hour, testLogger())
+	if refreshed := w.refreshDue(); refreshed != 1 {
+		t.Fatalf("refreshDue() = %d, want 1", refreshed)
+	}
+	if user, _ := c.Get(ctx, "alice"); user == nil || user.FirstName != "loaded" {
+		t.Fatalf("alice = %+v, want it reloaded", user)
+	}
+}
+
+// a user updated while the warmer loads it must not be overwritten with the old row
+func TestWarmerRefreshLosesAgainstConcurrentInvalidation(t *testing.T) {
+	ctx := context.Background()
+	tracker, c := newTestTracker(t, 10)
+	w := NewWarmer(tracker, tracker, func(ctx context.Context, key string) (*data.User, error) {
+		_ = c.Invalidate(ctx, key)
+		return &data.User{Username: key, FirstName: "old"}, nil
+	}, 10, 10*time.Second, time.Hour, testLogger())
+
+	if w.refresh("alice") {
+		t.Fatal("refresh() stored a user that was invalidated while loading")
+	}
+	if user, _ := c.Get(ctx, "alice"); user != nil {
+		t.Fatalf("alice = %+v, want it not cached", user)
+	}
+}
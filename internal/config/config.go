@@ -1,25 +1,109 @@
 package config
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/tkanos/gonfig"
 	"sceyt_task/pkg/logging"
 	"sync"
+	"time"
 )
 
 const (
 	ServerAddr = ""
 	ServerPort = "8080"
+	// connections sending the headers slower than ServerReadHeaderTimeout or the request slower than
+	// ServerReadTimeout are dropped, idle keep-alive connections are closed after ServerIdleTimeout
+	ServerReadHeaderTimeout = 5 * time.Second
+	ServerReadTimeout       = 15 * time.Second
+	ServerWriteTimeout      = 30 * time.Second
+	ServerIdleTimeout       = 2 * time.Minute
+	// ShutdownTimeout bounds the draining of in-flight requests after SIGINT or SIGTERM
+	ShutdownTimeout = 20 * time.Second
+	// the instance is reported as not ready for ShutdownReadinessDelay before it stops accepting
+	// connections, so the orchestrator stops routing requests to it first
+	ShutdownReadinessDelay = 5 * time.Second
+	// HealthCheckTimeout bounds the checks of the readiness endpoint
+	HealthCheckTimeout = time.Second
+	// CassandraHealthQuery is run by the readiness endpoint to check the cassandra session
+	CassandraHealthQuery = "SELECT release_version FROM system.local"
 
 	RedisHost    = "redis_db"
 	RedisPort    = "6379"
 	RedisDb      = 0
 	RedisExpires = 300
+	// CacheKeyPrefix is the service namespace of every key written to redis
+	CacheKeyPrefix  = "user-server"
+	CacheUserEntity = "user"
+	// RedisTimeout bounds every call to Redis, so an outage fails fast instead of stalling requests
+	RedisTimeout = 200 * time.Millisecond
 
-	LogConfigFileName = "logConfig"
-	ServerConfigPath  = "./properties"
-	DbConfigPath      = "./properties/dbConfig.yml"
+	// the cache circuit breaker opens after CacheBreakerThreshold consecutive Redis failures
+	// and probes Redis every CacheBreakerCooldown until it answers again
+	CacheBreakerThreshold  = 5
+	CacheBreakerCooldown   = 10 * time.Second
+	CacheBreakerMaxPending = 10000
+
+	// reads are counted per CacheHotKeysWindow, the CacheWarmUpTopN most read users are loaded
+	// at startup and reloaded when they expire within CacheRefreshAhead, checked every CacheRefreshInterval
+	CacheHotKeysWindow     = time.Hour
+	CacheHotKeysMaxTracked = 100000
+	CacheWarmUpTopN        = 1000
+	CacheRefreshAhead      = 60 * time.Second
+	CacheRefreshInterval   = 20 * time.Second
+
+	// responses of requests sent with an Idempotency-Key are kept for IdempotencyWindow unless
+	// configured otherwise, a key is reserved for IdempotencyLockTimeout while its request is processed
+	CacheIdempotencyEntity = "idempotency"
+	IdempotencyWindow      = 24 * time.Hour
+	IdempotencyLockTimeout = 30 * time.Second
+
+	// requests are rate limited per client IP and per authenticated caller, RateLimitMaxLocalBuckets
+	// buckets are kept in memory by each instance while redis is not available
+	CacheRateLimitEntity     = "ratelimit"
+	RateLimitMaxLocalBuckets = 100000
+
+	// API keys are kept in memory for APIKeyCacheTTL, a revoked key may be accepted that long by other instances
+	APIKeyCacheTTL = 30 * time.Second
+
+	// JWKSReloadInterval is how often the JWKS file is checked for changes unless configured otherwise
+	JWKSReloadInterval = time.Minute
+
+	LogConfigFileName   = "logConfig"
+	ServerConfigPath    = "./properties"
+	DbConfigPath        = "./properties/dbConfig.yml"
+	CacheConfigPath     = "./properties/cacheConfig.yml"
+	AdminConfigPath     = "./properties/adminConfig.yml"
+	RateLimitConfigPath = "./properties/rateLimitConfig.yml"
+	JWTConfigPath       = "./properties/jwtConfig.yml"
+	RBACConfigPath      = "./properties/rbacConfig.yml"
+	MetricsConfigPath   = "./properties/metricsConfig.yml"
+	TracingConfigPath   = "./properties/tracingConfig.yml"
+	GRPCConfigPath      = "./properties/grpcConfig.yml"
+
+	// ListUsers of the gRPC API returns GRPCDefaultPageSize users per page unless the client
+	// asks for another size, at most GRPCMaxPageSize
+	GRPCDefaultPageSize = 50
+	GRPCMaxPageSize     = 500
+	// GRPCHealthWatchInterval is how often the readiness is reported to the gRPC health service
+	GRPCHealthWatchInterval = 5 * time.Second
+
+	// GraphQL operations may nest fields GraphQLMaxDepth deep and have a complexity of at most
+	// GraphQLMaxComplexity, every field costs 1 and the fields of a page of users once per user
+	GraphQLMaxDepth      = 8
+	GraphQLMaxComplexity = 1000
+	// the users connection returns GraphQLDefaultPageSize users unless first is given, at most GraphQLMaxPageSize
+	GraphQLDefaultPageSize = 20
+	GraphQLMaxPageSize     = 100
+	// GraphQLMaxRequestSize bounds the body of GraphQL requests, in bytes
+	GraphQLMaxRequestSize = 64 << 10
+
+	// ended spans are exported in batches of TracingBatchSize or every TracingExportInterval,
+	// at most TracingQueueSize spans wait for the exporter and the others are dropped
+	TracingQueueSize      = 2048
+	TracingBatchSize      = 256
+	TracingExportInterval = 5 * time.Second
 )
 
 const (
@@ -29,6 +113,51 @@ const (
 	UpdatePath  = "update/"
 	SearchPath  = "search"
 	SwaggerPath = "/swagger/*any"
+
+	V2UsersPath = "/v2/users"
+	V2UserPath  = "/:username"
+
+	GraphQLPath = "/graphql"
+
+	ProblemContentType    = "application/problem+json"
+	ProblemTypePath       = "/problems/"
+	RequestIDHeader       = "X-Request-ID"
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for a retried request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	CacheStatusPath = "/cache/status"
+
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+	MetricsPath   = "/metrics"
+
+	DebugPath           = "/debug"
+	DebugPprofPath      = "/pprof/*profile"
+	DebugGoroutinesPath = "/goroutines"
+	DebugBuildInfoPath  = "/buildinfo"
+	DebugConfigPath     = "/config"
+	DebugLogLevelPath   = "/loglevel"
+
+	CacheAdminPath      = "/admin/cache"
+	CacheAdminStatsPath = "stats"
+	CacheAdminUserPath  = "users/:username"
+	CacheAdminUsersPath = "users"
+	AdminTokenHeader    = "X-Admin-Token"
+
+	APIKeysPath      = "/admin/api-keys"
+	APIKeyPath       = "/:id"
+	APIKeyRotatePath = "/:id/rotate"
+	APIKeyHeader     = "X-API-Key"
+	// APIKeyPrefix starts every API key, so leaked keys are easy to recognize
+	APIKeyPrefix = "usk"
+
+	RetryAfterHeader         = "Retry-After"
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
 )
 
 // Configuration wraps all the configs variables required by the auth service
@@ -41,6 +170,178 @@ type Configuration struct {
 	CQLVersion   string
 }
 
+// CacheConfiguration describes the redis deployment used by the cache
+type CacheConfiguration struct {
+	Mode       string   `env:"REDIS_MODE"`
+	Addresses  []string `env:"REDIS_ADDRESSES"`
+	MasterName string   `env:"REDIS_MASTER_NAME"`
+	Password   string   `env:"REDIS_PASSWORD"`
+	DB         int      `env:"REDIS_DB"`
+	Tenant     string   `env:"REDIS_TENANT"`
+	// Codec is json, msgpack or protobuf, entries written with another codec stay readable
+	Codec string `env:"REDIS_CODEC"`
+	// CompressAbove is the size in bytes above which entries are compressed, 0 disables compression
+	CompressAbove int `env:"REDIS_COMPRESS_ABOVE"`
+	// IdempotencyWindow is how long responses of idempotent requests are kept, in seconds
+	IdempotencyWindow int `env:"REDIS_IDEMPOTENCY_WINDOW"`
+}
+
+// IdempotencyWindowDuration returns the configured idempotency window, IdempotencyWindow if none is set
+func (c *CacheConfiguration) IdempotencyWindowDuration() time.Duration {
+	if c.IdempotencyWindow <= 0 {
+		return IdempotencyWindow
+	}
+	return time.Duration(c.IdempotencyWindow) * time.Second
+}
+
+// AdminConfiguration holds the credentials of the admin endpoints and the listener of the diagnostics
+type AdminConfiguration struct {
+	// Token has to be sent in the X-Admin-Token header, the admin endpoints are disabled when it is empty
+	Token string `env:"ADMIN_TOKEN"`
+	// Address is the listener of the profiling and diagnostics endpoints, it is not authenticated
+	// so it should stay bound to localhost. The diagnostics are disabled when it is empty.
+	Address string `env:"ADMIN_ADDRESS"`
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second and holding at most Burst tokens,
+// a zero Rate disables the limit
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RouteRateLimits are the limits of one route for every client IP and every authenticated caller
+type RouteRateLimits struct {
+	PerIP        RateLimit
+	PerPrincipal RateLimit
+}
+
+// RateLimitConfiguration holds the request rate limits
+type RateLimitConfiguration struct {
+	Enabled bool `env:"RATE_LIMIT_ENABLED"`
+	// Default applies to every route without an entry in Routes
+	Default RouteRateLimits
+	// Routes are keyed by the route path, e.g. "/search" or "/v2/users/:username"
+	Routes map[string]RouteRateLimits
+}
+
+// For returns the limits of the route
+func (c *RateLimitConfiguration) For(route string) RouteRateLimits {
+	if limits, ok := c.Routes[route]; ok {
+		return limits
+	}
+	return c.Default
+}
+
+// JWTConfiguration describes the bearer tokens accepted by the service
+type JWTConfiguration struct {
+	Enabled bool `env:"JWT_ENABLED"`
+	// JWKSPath is the local JWKS file holding the verification keys, it is reloaded when it changes
+	JWKSPath string `env:"JWT_JWKS_PATH"`
+	// ReloadInterval is how often the JWKS file is checked for changes, in seconds
+	ReloadInterval int    `env:"JWT_RELOAD_INTERVAL"`
+	Issuer         string `env:"JWT_ISSUER"`
+	Audience       string `env:"JWT_AUDIENCE"`
+	// Leeway is the clock skew tolerated when checking exp and nbf, in seconds
+	Leeway int `env:"JWT_LEEWAY"`
+	// Scopes maps the scopes of a token to the read, write and admin scopes of the routes
+	Scopes map[string][]string
+}
+
+// ReloadIntervalDuration returns the configured JWKS reload interval, JWKSReloadInterval if none is set
+func (c *JWTConfiguration) ReloadIntervalDuration() time.Duration {
+	if c.ReloadInterval <= 0 {
+		return JWKSReloadInterval
+	}
+	return time.Duration(c.ReloadInterval) * time.Second
+}
+
+// LeewayDuration returns the configured clock skew tolerance
+func (c *JWTConfiguration) LeewayDuration() time.Duration {
+	return time.Duration(c.Leeway) * time.Second
+}
+
+// RBACConfiguration holds the roles of the callers and the permissions they are granted on users
+type RBACConfiguration struct {
+	Enabled bool `env:"RBAC_ENABLED"`
+	// Roles maps a role to its permissions, "<action>:own" allows the action on the caller's own
+	// user and "<action>:any" on every user. The action is create, read, update, delete or *.
+	Roles map[string][]string
+	// APIKeyRoles are the roles of callers authenticated with an API key without the admin scope
+	APIKeyRoles []string
+}
+
+// MetricsConfiguration describes the listener of the metrics endpoint, it is kept apart
+// from the API so it is not exposed to clients
+type MetricsConfiguration struct {
+	Enabled bool   `env:"METRICS_ENABLED"`
+	Address string `env:"METRICS_ADDRESS"`
+}
+
+// GRPCConfiguration describes the listener of the gRPC API
+type GRPCConfiguration struct {
+	Enabled bool   `env:"GRPC_ENABLED"`
+	Address string `env:"GRPC_ADDRESS"`
+	// Reflection lets clients like grpcurl list and describe the services
+	Reflection bool `env:"GRPC_REFLECTION"`
+}
+
+// exporters of the spans
+const (
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+	TracingExporterOTLP   = "otlp"
+)
+
+// TracingConfiguration describes which requests are traced and where the spans are exported
+type TracingConfiguration struct {
+	Enabled     bool   `env:"TRACING_ENABLED"`
+	ServiceName string `env:"TRACING_SERVICE_NAME"`
+	// SampleRatio is the share of the traces started by this service that are recorded, traces
+	// continued from a traceparent header follow the decision of the caller
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO"`
+	// Exporter is stdout, file or otlp
+	Exporter     string `env:"TRACING_EXPORTER"`
+	FilePath     string `env:"TRACING_FILE_PATH"`
+	OTLPEndpoint string `env:"TRACING_OTLP_ENDPOINT"`
+}
+
+// redacted replaces the secrets in the effective configuration
+const redacted = "[REDACTED]"
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// Effective returns the configuration the service runs with, keyed by config file, with
+// the passwords and tokens redacted
+func Effective() map[string]interface{} {
+	effective := map[string]interface{}{
+		"log":       GetLogConfiguration(),
+		"rateLimit": LoadRateLimitConfig(),
+		"jwt":       LoadJWTConfig(),
+		"rbac":      LoadRBACConfig(),
+		"metrics":   LoadMetricsConfig(),
+		"tracing":   LoadTracingConfig(),
+		"grpc":      LoadGRPCConfig(),
+	}
+	if db := LoadConfig(); db != nil {
+		c := *db
+		c.Password = redact(c.Password)
+		effective["db"] = c
+	}
+	c := *LoadCacheConfig()
+	c.Password = redact(c.Password)
+	effective["cache"] = c
+	a := *LoadAdminConfig()
+	a.Token = redact(a.Token)
+	effective["admin"] = a
+	return effective
+}
+
 var instance *logging.Configuration
 var logOnce sync.Once
 
@@ -77,3 +378,145 @@ func LoadConfig() *Configuration {
 	})
 	return dbConfig
 }
+
+var cacheConfig *CacheConfiguration
+var cacheOnce sync.Once
+
+// LoadCacheConfig get redis connection parameters, a standalone redis on
+// RedisHost:RedisPort is used when the config file can not be read
+func LoadCacheConfig() *CacheConfiguration {
+	cacheOnce.Do(func() {
+		config := &CacheConfiguration{}
+		err := gonfig.GetConf(CacheConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the cache config file, using standalone redis.")
+			config = &CacheConfiguration{
+				Mode:      "standalone",
+				Addresses: []string{fmt.Sprintf("%s:%s", RedisHost, RedisPort)},
+				DB:        RedisDb,
+				Codec:     "json",
+			}
+		}
+		cacheConfig = config
+	})
+	return cacheConfig
+}
+
+var adminConfig *AdminConfiguration
+var adminOnce sync.Once
+
+// LoadAdminConfig get the admin endpoints credentials
+func LoadAdminConfig() *AdminConfiguration {
+	adminOnce.Do(func() {
+		config := &AdminConfiguration{}
+		err := gonfig.GetConf(AdminConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the admin config file, admin endpoints are disabled.")
+		}
+		adminConfig = config
+	})
+	return adminConfig
+}
+
+var rateLimitConfig *RateLimitConfiguration
+var rateLimitOnce sync.Once
+
+// LoadRateLimitConfig get the request rate limits, rate limiting is disabled when the config file can not be read
+func LoadRateLimitConfig() *RateLimitConfiguration {
+	rateLimitOnce.Do(func() {
+		config := &RateLimitConfiguration{}
+		err := gonfig.GetConf(RateLimitConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the rate limit config file, rate limiting is disabled.")
+			config = &RateLimitConfiguration{}
+		}
+		rateLimitConfig = config
+	})
+	return rateLimitConfig
+}
+
+var jwtConfig *JWTConfiguration
+var jwtOnce sync.Once
+
+// LoadJWTConfig get the bearer token settings, bearer tokens are not accepted when the config file can not be read
+func LoadJWTConfig() *JWTConfiguration {
+	jwtOnce.Do(func() {
+		config := &JWTConfiguration{}
+		err := gonfig.GetConf(JWTConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the jwt config file, bearer tokens are disabled.")
+			config = &JWTConfiguration{}
+		}
+		jwtConfig = config
+	})
+	return jwtConfig
+}
+
+var rbacConfig *RBACConfiguration
+var rbacOnce sync.Once
+
+// LoadRBACConfig get the roles and their permissions, only admins are allowed to access users
+// when the config file can not be read
+func LoadRBACConfig() *RBACConfiguration {
+	rbacOnce.Do(func() {
+		config := &RBACConfiguration{}
+		err := gonfig.GetConf(RBACConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the rbac config file, only admins are allowed to access users.")
+			config = &RBACConfiguration{Enabled: true}
+		}
+		rbacConfig = config
+	})
+	return rbacConfig
+}
+
+var metricsConfig *MetricsConfiguration
+var metricsOnce sync.Once
+
+// LoadMetricsConfig get the metrics listener, metrics are served on :9090 when the config file can not be read
+func LoadMetricsConfig() *MetricsConfiguration {
+	metricsOnce.Do(func() {
+		config := &MetricsConfiguration{}
+		err := gonfig.GetConf(MetricsConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the metrics config file, serving metrics on :9090.")
+			config = &MetricsConfiguration{Enabled: true, Address: ":9090"}
+		}
+		metricsConfig = config
+	})
+	return metricsConfig
+}
+
+var tracingConfig *TracingConfiguration
+var tracingOnce sync.Once
+
+// LoadTracingConfig get the tracing parameters, tracing is disabled when the config file can not be read
+func LoadTracingConfig() *TracingConfiguration {
+	tracingOnce.Do(func() {
+		config := &TracingConfiguration{}
+		err := gonfig.GetConf(TracingConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the tracing config file, tracing is disabled.")
+			config = &TracingConfiguration{}
+		}
+		tracingConfig = config
+	})
+	return tracingConfig
+}
+
+var grpcConfig *GRPCConfiguration
+var grpcOnce sync.Once
+
+// LoadGRPCConfig get the gRPC listener, the gRPC API is served on :50051 when the config file can not be read
+func LoadGRPCConfig() *GRPCConfiguration {
+	grpcOnce.Do(func() {
+		config := &GRPCConfiguration{}
+		err := gonfig.GetConf(GRPCConfigPath, config)
+		if err != nil {
+			logrus.Error("An error was generated while reading the grpc config file, serving gRPC on :50051.")
+			config = &GRPCConfiguration{Enabled: true, Address: ":50051"}
+		}
+		grpcConfig = config
+	})
+	return grpcConfig
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveRedactsTheSecrets(t *testing.T) {
+	// the config files are read relative to the repository root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("REDIS_PASSWORD", "redis-secret")
+
+	effective := Effective()
+	if LoadConfig() == nil || LoadConfig().Password == "" || LoadAdminConfig().Token != "admin-secret" {
+		t.Fatal("the secrets were not loaded")
+	}
+	body, err := json.Marshal(effective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"admin-secret", "redis-secret"} {
+		if strings.Contains(string(body), secret) {
+			t.Errorf("the effective configuration holds %q: %s", secret, body)
+		}
+	}
+	if effective["db"].(Configuration).Password != redacted || effective["cache"].(CacheConfiguration).Password != redacted ||
+		effective["admin"].(AdminConfiguration).Token != redacted {
+		t.Errorf("effective = %s, want the secrets redacted", body)
+	}
+	// the configured values are not changed
+	if LoadAdminConfig().Token != "admin-secret" || LoadCacheConfig().Password != "redis-secret" {
+		t.Error("redacting changed the loaded configuration")
+	}
+}
+
+func TestRedactKeepsEmptySecrets(t *testing.T) {
+	if redact("") != "" {
+		t.Error("an unset secret was redacted, want it reported as unset")
+	}
+}
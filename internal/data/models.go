@@ -1,5 +1,9 @@
 package data
 
+// UserSchemaVersion is the version of the cached User representation,
+// it has to be increased whenever the shape of User changes
+const UserSchemaVersion = 1
+
 type (
 	// User is the data type for user object
 	User struct {
@@ -11,4 +15,15 @@ type (
 		UpdatedAt string `json:"updated_at"`
 		DeletedAt string `json:"deleted_at"`
 	}
+
+	// UserChanges holds the fields changed by a partial update, nil fields stay unchanged
+	UserChanges struct {
+		FirstName *string
+		LastName  *string
+	}
 )
+
+// Empty reports whether no field is changed
+func (c UserChanges) Empty() bool {
+	return c.FirstName == nil && c.LastName == nil
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/internal/validation"
+	"sceyt_task/pkg/logging"
+	"time"
+)
+
+// ErrAPIKeyNotFound is the detail of the problem answered for unknown key ids
+var ErrAPIKeyNotFound = "no api key exists with the given id"
+
+// APIKeyHandler wraps instances needed to manage API keys
+type APIKeyHandler struct {
+	logger        logging.Logger
+	validator     *validation.Validation
+	repo          repository.APIKeyRepository
+	authenticator *Authenticator
+}
+
+// NewAPIKeyHandler returns a new APIKeyHandler instance
+func NewAPIKeyHandler(l logging.Logger, v *validation.Validation, r repository.APIKeyRepository, a *Authenticator) *APIKeyHandler {
+	return &APIKeyHandler{
+		logger:        l,
+		validator:     v,
+		repo:          r,
+		authenticator: a,
+	}
+}
+
+// Routes registers the key management routes, they require the admin scope
+func (k *APIKeyHandler) Routes(engine *gin.Engine, guard RouteGuard) {
+	keys := engine.Group(config.APIKeysPath)
+	{
+		keys.Use(guard(data.ScopeAdmin))
+		keys.POST("", k.Create)
+		keys.GET("", k.List)
+		keys.POST(config.APIKeyRotatePath, k.Rotate)
+		keys.DELETE(config.APIKeyPath, k.Revoke)
+	}
+}
+
+// CreateAPIKeyRequest is the body of POST /admin/api-keys
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required,dive,oneof=read write admin"`
+	// ExpiresAt is optional, the key does not expire when it is not set
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse is an API key together with its secret, the secret is only
+// returned when the key is created or rotated
+type APIKeyResponse struct {
+	*data.APIKey
+	Key string `json:"key"`
+}
+
+// Create creates an API key
+// @Summary create api key
+// @Tags api keys
+// @Description create an API key, the key is only returned in this response
+// @ID api-key-create
+// @Accept json
+// @Produce json
+// @Security APIKey
+// @Param input body CreateAPIKeyRequest true "api key"
+// @Success 201 {object} APIKeyResponse
+// @Failure 400,401,403,500 {object} Problem
+// @Router /admin/api-keys [post]
+func (k *APIKeyHandler) Create(ctx *gin.Context) {
+	req := &CreateAPIKeyRequest{}
+	if err := decodeStrict(ctx.Request.Body, req); err != nil {
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return
+	}
+	if errs := k.validator.Validate(req); len(errs) != 0 {
+		abortWithValidationErrors(ctx, k.validator, errs)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		abortWithProblem(ctx, CodeInvalidBody, "expires_at must be in the future")
+		return
+	}
+
+	id, secret, err := NewAPIKey()
+	if err != nil {
+		requestLogger(ctx, k.logger).Error("error while generating api key", "error", err)
+		abortWithProblem(ctx, CodeInternalError, "error while generating api key")
+		return
+	}
+	key := &data.APIKey{ID: id, Name: req.Name, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt}
+	if err = k.repo.Create(ctx.Request.Context(), key, hashSecret(secret)); err != nil {
+		requestLogger(ctx, k.logger).Error("error while adding api key", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while adding api key")
+		return
+	}
+	requestLogger(ctx, k.logger).Info("api key ", key.ID, " (", key.Name, ") created by ", PrincipalFrom(ctx))
+	writeJSON(ctx, http.StatusCreated, &APIKeyResponse{APIKey: key, Key: FormatAPIKey(id, secret)})
+}
+
+// List returns all API keys
+// @Summary list api keys
+// @Tags api keys
+// @Description list all API keys without their secrets, revoked keys included
+// @ID api-key-list
+// @Produce json
+// @Security APIKey
+// @Success 200 {array} data.APIKey
+// @Failure 401,403,500 {object} Problem
+// @Router /admin/api-keys [get]
+func (k *APIKeyHandler) List(ctx *gin.Context) {
+	keys, err := k.repo.List(ctx.Request.Context())
+	if err != nil {
+		requestLogger(ctx, k.logger).Error("error while listing api keys", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while listing api keys")
+		return
+	}
+	writeJSON(ctx, http.StatusOK, keys)
+}
+
+// Rotate replaces the secret of an API key
+// @Summary rotate api key
+// @Tags api keys
+// @Description replace the secret of an API key, the previous key stops working
+// @ID api-key-rotate
+// @Produce json
+// @Security APIKey
+// @Param id path string true "api key id"
+// @Success 200 {object} APIKeyResponse
+// @Failure 401,403,404,409,500 {object} Problem
+// @Router /admin/api-keys/{id}/rotate [post]
+func (k *APIKeyHandler) Rotate(ctx *gin.Context) {
+	id := ctx.Param("id")
+	key, _, err := k.repo.Get(ctx.Request.Context(), id)
+	if !k.checkKeyError(ctx, err) {
+		return
+	}
+
+	_, secret, err := NewAPIKey()
+	if err != nil {
+		requestLogger(ctx, k.logger).Error("error while generating api key", "error", err)
+		abortWithProblem(ctx, CodeInternalError, "error while generating api key")
+		return
+	}
+	// the key keeps its id, only the secret is replaced
+	if !k.checkKeyError(ctx, k.repo.Rotate(ctx.Request.Context(), id, hashSecret(secret))) {
+		return
+	}
+	k.authenticator.Forget(id)
+	requestLogger(ctx, k.logger).Info("api key ", id, " rotated by ", PrincipalFrom(ctx))
+	now := time.Now().UTC()
+	key.RotatedAt = &now
+	writeJSON(ctx, http.StatusOK, &APIKeyResponse{APIKey: key, Key: FormatAPIKey(id, secret)})
+}
+
+// Revoke revokes an API key
+// @Summary revoke api key
+// @Tags api keys
+// @Description revoke an API key, revoked keys are kept and listed
+// @ID api-key-revoke
+// @Security APIKey
+// @Param id path string true "api key id"
+// @Success 204
+// @Failure 401,403,404,409,500 {object} Problem
+// @Router /admin/api-keys/{id} [delete]
+func (k *APIKeyHandler) Revoke(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if !k.checkKeyError(ctx, k.repo.Revoke(ctx.Request.Context(), id)) {
+		return
+	}
+	k.authenticator.Forget(id)
+	requestLogger(ctx, k.logger).Info("api key ", id, " revoked by ", PrincipalFrom(ctx))
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+// checkKeyError answers the request with the problem of a repository error, it reports whether there was none
+func (k *APIKeyHandler) checkKeyError(ctx *gin.Context, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case repository.ErrAPIKeyNotFound:
+		abortWithProblem(ctx, CodeAPIKeyNotFound, ErrAPIKeyNotFound)
+	case repository.ErrAPIKeyRevoked:
+		abortWithProblem(ctx, CodeAPIKeyRevoked, "the api key is revoked")
+	default:
+		requestLogger(ctx, k.logger).Error("error while changing api key", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while changing api key")
+	}
+	return false
+}
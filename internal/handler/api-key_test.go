@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/internal/validation"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPIKeyRepository keeps the keys in memory, it fails like the cassandra repository
+// for unknown and revoked keys
+type fakeAPIKeyRepository struct {
+	mu     sync.Mutex
+	keys   map[string]data.APIKey
+	hashes map[string]string
+	gets   int
+}
+
+func newFakeAPIKeyRepository() *fakeAPIKeyRepository {
+	return &fakeAPIKeyRepository{keys: map[string]data.APIKey{}, hashes: map[string]string{}}
+}
+
+// add stores a key with the secret and returns the key sent by clients
+func (r *fakeAPIKeyRepository) add(key data.APIKey, secret string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[key.ID] = key
+	r.hashes[key.ID] = hashSecret(secret)
+	return FormatAPIKey(key.ID, secret)
+}
+
+func (r *fakeAPIKeyRepository) Create(ctx context.Context, key *data.APIKey, secretHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.CreatedAt = time.Now().UTC()
+	r.keys[key.ID] = *key
+	r.hashes[key.ID] = secretHash
+	return nil
+}
+
+func (r *fakeAPIKeyRepository) Get(ctx context.Context, id string) (*data.APIKey, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gets++
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, "", repository.ErrAPIKeyNotFound
+	}
+	return &key, r.hashes[id], nil
+}
+
+func (r *fakeAPIKeyRepository) List(ctx context.Context) ([]*data.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []*data.APIKey{}
+	for _, key := range r.keys {
+		copied := key
+		keys = append(keys, &copied)
+	}
+	return keys, nil
+}
+
+func (r *fakeAPIKeyRepository) update(id string, f func(key *data.APIKey)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return repository.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return repository.ErrAPIKeyRevoked
+	}
+	f(&key)
+	r.keys[id] = key
+	return nil
+}
+
+func (r *fakeAPIKeyRepository) Rotate(ctx context.Context, id string, secretHash string) error {
+	return r.update(id, func(key *data.APIKey) {
+		now := time.Now().UTC()
+		key.RotatedAt = &now
+		r.hashes[id] = secretHash
+	})
+}
+
+func (r *fakeAPIKeyRepository) Revoke(ctx context.Context, id string) error {
+	return r.update(id, func(key *data.APIKey) {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+	})
+}
+
+var _ repository.APIKeyRepository = (*fakeAPIKeyRepository)(nil)
+
+const testAdminToken = "admin-secret"
+
+// newTestAPIKeyRouter serves the key management routes and a read route, both
+// authenticated by a real Authenticator
+func newTestAPIKeyRouter(repo *fakeAPIKeyRepository) *gin.Engine {
+	authenticator := NewAuthenticator(testLogger(), repo, nil, nil, testAdminToken, time.Minute)
+	router := gin.New()
+	NewAPIKeyHandler(testLogger(), validation.NewValidation(), repo, authenticator).Routes(router, authenticator.RequireScope)
+	router.GET("/read", route(authenticator.RequireScope, data.ScopeRead, nil, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, PrincipalFrom(ctx))
+	})...)
+	return router
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	repo := newFakeAPIKeyRepository()
+	router := newTestAPIKeyRouter(repo)
+
+	res := do(t, router, http.MethodPost, config.APIKeysPath, `{"name":"mobile","scopes":["read"]}`, config.AdminTokenHeader, testAdminToken)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", res.Code, res.Body)
+	}
+	var created APIKeyResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Name != "mobile" || created.Key == "" {
+		t.Fatalf("created = %s", res.Body)
+	}
+	if hash := repo.hashes[created.ID]; hash == "" || hash == created.Key {
+		t.Fatalf("stored secret hash = %q", hash)
+	}
+
+	if res = do(t, router, http.MethodGet, "/read", "", config.APIKeyHeader, created.Key); res.Code != http.StatusOK {
+		t.Fatalf("read with the new key = %d %s", res.Code, res.Body)
+	}
+
+	res = do(t, router, http.MethodPost, config.APIKeysPath+"/"+created.ID+"/rotate", "", config.AdminTokenHeader, testAdminToken)
+	var rotated APIKeyResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &rotated); err != nil || res.Code != http.StatusOK || rotated.RotatedAt == nil {
+		t.Fatalf("rotate = %d %s", res.Code, res.Body)
+	}
+	if res = do(t, router, http.MethodGet, "/read", "", config.APIKeyHeader, created.Key); res.Code != http.StatusUnauthorized {
+		t.Fatalf("read with the rotated key = %d, want 401", res.Code)
+	}
+	if res = do(t, router, http.MethodGet, "/read", "", config.APIKeyHeader, rotated.Key); res.Code != http.StatusOK {
+		t.Fatalf("read with the new secret = %d %s", res.Code, res.Body)
+	}
+
+	path := config.APIKeysPath + "/" + created.ID
+	if res = do(t, router, http.MethodDelete, path, "", config.AdminTokenHeader, testAdminToken); res.Code != http.StatusNoContent {
+		t.Fatalf("revoke = %d %s", res.Code, res.Body)
+	}
+	// the revoked key is dropped from the cache of the authenticator at once
+	if res = do(t, router, http.MethodGet, "/read", "", config.APIKeyHeader, rotated.Key); res.Code != http.StatusUnauthorized {
+		t.Fatalf("read with the revoked key = %d, want 401", res.Code)
+	}
+	res = do(t, router, http.MethodDelete, path, "", config.AdminTokenHeader, testAdminToken)
+	if res.Code != http.StatusConflict || problemCode(t, res) != CodeAPIKeyRevoked {
+		t.Fatalf("second revoke = %d %s, want 409", res.Code, res.Body)
+	}
+	res = do(t, router, http.MethodPost, path+"/rotate", "", config.AdminTokenHeader, testAdminToken)
+	if res.Code != http.StatusConflict || problemCode(t, res) != CodeAPIKeyRevoked {
+		t.Fatalf("rotate of a revoked key = %d %s, want 409", res.Code, res.Body)
+	}
+
+	res = do(t, router, http.MethodGet, config.APIKeysPath, "", config.AdminTokenHeader, testAdminToken)
+	var keys []data.APIKey
+	if err := json.Unmarshal(res.Body.Bytes(), &keys); err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Fatalf("list = %d %s, want the revoked key", res.Code, res.Body)
+	}
+}
+
+func TestAPIKeyOfAnUnknownID(t *testing.T) {
+	repo := newFakeAPIKeyRepository()
+	router := newTestAPIKeyRouter(repo)
+	for _, r := range []struct{ method, path string }{
+		{http.MethodDelete, config.APIKeysPath + "/nope"},
+		{http.MethodPost, config.APIKeysPath + "/nope/rotate"},
+	} {
+		res := do(t, router, r.method, r.path, "", config.AdminTokenHeader, testAdminToken)
+		if res.Code != http.StatusNotFound || problemCode(t, res) != CodeAPIKeyNotFound {
+			t.Errorf("%s %s = %d %s, want 404", r.method, r.path, res.Code, res.Body)
+		}
+	}
+	if len(repo.keys) != 0 {
+		t.Fatalf("keys = %v, want no key created for the unknown id", repo.keys)
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	router := newTestAPIKeyRouter(newFakeAPIKeyRepository())
+	tests := map[string]struct {
+		body string
+		code ErrorCode
+	}{
+		"unknown scope":    {`{"name":"x","scopes":["root"]}`, CodeValidationFailed},
+		"no scopes":        {`{"name":"x"}`, CodeValidationFailed},
+		"expired":          {`{"name":"x","scopes":["read"],"expires_at":"2001-01-01T00:00:00Z"}`, CodeInvalidBody},
+		"unknown field":    {`{"name":"x","scopes":["read"],"id":"mine"}`, CodeInvalidBody},
+		"malformed":        {`{"name":`, CodeInvalidBody},
+		"missing the name": {`{"scopes":["read"]}`, CodeValidationFailed},
+	}
+	for name, tt := range tests {
+		res := do(t, router, http.MethodPost, config.APIKeysPath, tt.body, config.AdminTokenHeader, testAdminToken)
+		if res.Code != http.StatusBadRequest || problemCode(t, res) != tt.code {
+			t.Errorf("%s: create = %d %s, want 400 %s", name, res.Code, res.Body, tt.code)
+		}
+	}
+}
+
+func TestParseAPIKey(t *testing.T) {
+	id, secret, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := FormatAPIKey(id, secret)
+	if gotID, gotSecret, ok := parseAPIKey(key); !ok || gotID != id || gotSecret != secret {
+		t.Fatalf("parseAPIKey(%q) = %q, %q, %t", key, gotID, gotSecret, ok)
+	}
+	for _, invalid := range []string{"", "usk_", "usk_abc", "usk_.secret", "usk_abc."} {
+		if _, _, ok := parseAPIKey(invalid); ok {
+			t.Errorf("parseAPIKey(%q) succeeded", invalid)
+		}
+	}
+}
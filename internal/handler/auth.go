@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/internal/token"
+	"sceyt_task/pkg/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+// principalKey is the gin context key of the authenticated Principal
+const principalKey = "principal"
+
+// authentication methods of a Principal
+const (
+	MethodAPIKey     = "api_key"
+	MethodAdminToken = "admin_token"
+	MethodBearer     = "bearer"
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errInactiveAPIKey     = errors.New("api key is revoked or expired")
+)
+
+// Principal is the authenticated caller of a request, ID is the key id of API keys
+// and the subject of bearer tokens. Roles are the roles claimed by bearer tokens.
+type Principal struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Method string   `json:"method"`
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// HasScope reports whether the principal was granted the scope, admin grants every scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == data.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the principal in logs
+func (p *Principal) String() string {
+	return p.Method + ":" + p.ID
+}
+
+// PrincipalFrom returns the authenticated caller of the request, nil if it is not authenticated
+func PrincipalFrom(ctx *gin.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// SubjectFrom returns the subject of the bearer token the request was authenticated with,
+// an empty string for other callers
+func SubjectFrom(ctx *gin.Context) string {
+	if p := PrincipalFrom(ctx); p != nil && p.Method == MethodBearer {
+		return p.ID
+	}
+	return ""
+}
+
+// isCredentialError reports whether the credentials of the request were rejected
+func isCredentialError(err error) bool {
+	switch err {
+	case errInvalidCredentials, errInactiveAPIKey, token.ErrMalformed, token.ErrSignature, token.ErrExpired, token.ErrClaims:
+		return true
+	}
+	return false
+}
+
+// RouteGuard returns the middleware that only lets callers with the scope through
+type RouteGuard func(scope string) gin.HandlerFunc
+
+// route returns the handler chain of a route, the guard runs first
+func route(guard RouteGuard, scope string, middlewares []gin.HandlerFunc, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	chain := append([]gin.HandlerFunc{guard(scope)}, middlewares...)
+	return append(chain, handlers...)
+}
+
+// NewAPIKey returns a random API key id and secret
+func NewAPIKey() (id string, secret string, err error) {
+	b := make([]byte, 6+32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(b[:6]), base64.RawURLEncoding.EncodeToString(b[6:]), nil
+}
+
+// FormatAPIKey returns the key handed to the client as "<prefix>_<id>.<secret>"
+func FormatAPIKey(id string, secret string) string {
+	return fmt.Sprintf("%s_%s.%s", config.APIKeyPrefix, id, secret)
+}
+
+// parseAPIKey splits a key made by FormatAPIKey into its id and secret
+func parseAPIKey(key string) (id string, secret string, ok bool) {
+	key = strings.TrimPrefix(key, config.APIKeyPrefix+"_")
+	i := strings.Index(key, ".")
+	if i <= 0 || i == len(key)-1 {
+		return "", "", false
+	}
+	return key[:i], key[i+1:], true
+}
+
+// hashSecret returns the hash stored for the secret of an API key. The secrets are
+// random, so a plain hash is enough to make the stored value useless if it leaks.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type cachedAPIKey struct {
+	key        *data.APIKey
+	secretHash string
+	loadedAt   time.Time
+}
+
+// Authenticator identifies the caller of a request by its X-API-Key header, its bearer
+// token or the X-Admin-Token header which is granted the admin scope. API keys are read
+// from the database and kept in memory for cacheTTL, so a revoked key may be accepted by
+// other instances for up to cacheTTL. The scopes of bearer tokens are mapped to the
+// scopes of the routes with tokenScopes.
+type Authenticator struct {
+	logger      logging.Logger
+	keys        repository.APIKeyRepository
+	tokens      *token.Verifier
+	tokenScopes map[string][]string
+	adminToken  string
+	cacheTTL    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+}
+
+// NewAuthenticator returns a new Authenticator instance, bearer tokens are not accepted
+// when tokens is nil and the admin token is not accepted when it is empty
+func NewAuthenticator(l logging.Logger, keys repository.APIKeyRepository, tokens *token.Verifier, tokenScopes map[string][]string,
+	adminToken string, cacheTTL time.Duration) *Authenticator {
+	return &Authenticator{
+		logger:      l,
+		keys:        keys,
+		tokens:      tokens,
+		tokenScopes: tokenScopes,
+		adminToken:  adminToken,
+		cacheTTL:    cacheTTL,
+		cache:       map[string]cachedAPIKey{},
+	}
+}
+
+// Forget drops the key from the in-memory cache after it was changed
+func (a *Authenticator) Forget(id string) {
+	a.mu.Lock()
+	delete(a.cache, id)
+	a.mu.Unlock()
+}
+
+func (a *Authenticator) loadKey(ctx context.Context, id string) (*data.APIKey, string, error) {
+	a.mu.Lock()
+	cached, ok := a.cache[id]
+	a.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < a.cacheTTL {
+		return cached.key, cached.secretHash, nil
+	}
+
+	key, secretHash, err := a.keys.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	a.mu.Lock()
+	a.cache[id] = cachedAPIKey{key: key, secretHash: secretHash, loadedAt: time.Now()}
+	a.mu.Unlock()
+	return key, secretHash, nil
+}
+
+// authenticate returns the caller of the request with the headers, nil if no credentials
+// were sent. gRPC calls send the same headers as metadata.
+func (a *Authenticator) authenticate(ctx context.Context, header http.Header) (*Principal, error) {
+	if token := header.Get(config.AdminTokenHeader); token != "" {
+		if a.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			return nil, errInvalidCredentials
+		}
+		return &Principal{ID: "admin", Name: "admin token", Method: MethodAdminToken, Scopes: []string{data.ScopeAdmin}}, nil
+	}
+
+	if authorization := header.Get("Authorization"); authorization != "" {
+		return a.authenticateBearer(authorization)
+	}
+
+	value := header.Get(config.APIKeyHeader)
+	if value == "" {
+		return nil, nil
+	}
+	id, secret, ok := parseAPIKey(value)
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	key, secretHash, err := a.loadKey(ctx, id)
+	if err == repository.ErrAPIKeyNotFound {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(secretHash)) != 1 {
+		return nil, errInvalidCredentials
+	}
+	if !key.Active(time.Now()) {
+		return nil, errInactiveAPIKey
+	}
+	return &Principal{ID: key.ID, Name: key.Name, Method: MethodAPIKey, Scopes: key.Scopes}, nil
+}
+
+// authenticateBearer verifies the bearer token of the Authorization header
+func (a *Authenticator) authenticateBearer(authorization string) (*Principal, error) {
+	const prefix = "bearer "
+	if a.tokens == nil || len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return nil, errInvalidCredentials
+	}
+	claims, err := a.tokens.Verify(strings.TrimSpace(authorization[len(prefix):]))
+	if err != nil {
+		return nil, err
+	}
+	var scopes []string
+	for _, scope := range claims.Scopes() {
+		scopes = append(scopes, a.tokenScopes[scope]...)
+	}
+	return &Principal{ID: claims.Subject, Name: claims.Subject, Method: MethodBearer, Scopes: scopes,
+		Roles: claims.Roles}, nil
+}
+
+// RequireScope returns the middleware that authenticates the caller and only lets
+// it through when it was granted the scope
+func (a *Authenticator) RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, err := a.authenticate(ctx.Request.Context(), ctx.Request.Header)
+		if isCredentialError(err) {
+			requestLogger(ctx, a.logger).Warn("rejected credentials from ", ctx.ClientIP(), ": ", err)
+			abortWithProblem(ctx, CodeUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			requestLogger(ctx, a.logger).Error("error while authenticating request", "error", err)
+			abortWithProblem(ctx, CodeDatabaseError, "credentials can not be checked, please try again later")
+			return
+		}
+		if principal == nil {
+			if a.tokens != nil {
+				ctx.Header("WWW-Authenticate", "Bearer")
+				abortWithProblem(ctx, CodeUnauthorized, "an "+config.APIKeyHeader+" header or a bearer token is required")
+				return
+			}
+			abortWithProblem(ctx, CodeUnauthorized, "an "+config.APIKeyHeader+" header is required")
+			return
+		}
+		ctx.Set(principalKey, principal)
+		if !principal.HasScope(scope) {
+			requestLogger(ctx, a.logger).Warn("denied ", ctx.Request.Method, " ", ctx.FullPath(), " to ", principal, ", missing scope ", scope)
+			abortWithProblem(ctx, CodeForbidden, "the "+scope+" scope is required")
+			return
+		}
+		requestLogger(ctx, a.logger).Debug("authenticated ", principal, " for ", ctx.Request.Method, " ", ctx.FullPath())
+		ctx.Next()
+	}
+}
+
+// AccessLogFormatter formats the access log like gin and adds the authenticated caller
+// and the request id
+func AccessLogFormatter(param gin.LogFormatterParams) string {
+	caller := "-"
+	if p, ok := param.Keys[principalKey].(*Principal); ok {
+		caller = p.String()
+	}
+	requestID, _ := param.Keys[requestIDKey].(string)
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-20s | %-7s %#v | %s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		caller,
+		param.Method,
+		param.Path,
+		requestID,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/token"
+	"testing"
+	"time"
+)
+
+func TestRequireScopeWithAPIKeys(t *testing.T) {
+	repo := newFakeAPIKeyRepository()
+	expired := time.Now().Add(-time.Hour)
+	revoked := time.Now()
+	reader := repo.add(data.APIKey{ID: "reader", Name: "reader", Scopes: []string{data.ScopeRead}}, "s1")
+	admin := repo.add(data.APIKey{ID: "admin", Scopes: []string{data.ScopeAdmin}}, "s2")
+	expiredKey := repo.add(data.APIKey{ID: "expired", Scopes: []string{data.ScopeRead}, ExpiresAt: &expired}, "s3")
+	revokedKey := repo.add(data.APIKey{ID: "revoked", Scopes: []string{data.ScopeRead}, RevokedAt: &revoked}, "s4")
+	router := newTestAPIKeyRouter(repo)
+
+	tests := []struct {
+		name    string
+		path    string
+		headers []string
+		status  int
+	}{
+		{"reader reads", "/read", []string{config.APIKeyHeader, reader}, http.StatusOK},
+		{"admin reads", "/read", []string{config.APIKeyHeader, admin}, http.StatusOK},
+		{"admin token reads", "/read", []string{config.AdminTokenHeader, testAdminToken}, http.StatusOK},
+		{"reader manages keys", config.APIKeysPath, []string{config.APIKeyHeader, reader}, http.StatusForbidden},
+		{"admin manages keys", config.APIKeysPath, []string{config.APIKeyHeader, admin}, http.StatusOK},
+		{"no credentials", "/read", nil, http.StatusUnauthorized},
+		{"wrong secret", "/read", []string{config.APIKeyHeader, FormatAPIKey("reader", "s2")}, http.StatusUnauthorized},
+		{"unknown key", "/read", []string{config.APIKeyHeader, FormatAPIKey("nobody", "s1")}, http.StatusUnauthorized},
+		{"malformed key", "/read", []string{config.APIKeyHeader, "reader"}, http.StatusUnauthorized},
+		{"expired key", "/read", []string{config.APIKeyHeader, expiredKey}, http.StatusUnauthorized},
+		{"revoked key", "/read", []string{config.APIKeyHeader, revokedKey}, http.StatusUnauthorized},
+		{"wrong admin token", "/read", []string{config.AdminTokenHeader, "guess"}, http.StatusUnauthorized},
+		{"bearer token without verifier", "/read", []string{"Authorization", "Bearer x.y.z"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		res := do(t, router, http.MethodGet, tt.path, "", tt.headers...)
+		if res.Code != tt.status {
+			t.Errorf("%s: status = %d %s, want %d", tt.name, res.Code, res.Body, tt.status)
+		}
+	}
+}
+
+func TestRequireScopeSetsThePrincipal(t *testing.T) {
+	repo := newFakeAPIKeyRepository()
+	key := repo.add(data.APIKey{ID: "k1", Name: "mobile", Scopes: []string{data.ScopeRead}}, "secret")
+	res := do(t, newTestAPIKeyRouter(repo), http.MethodGet, "/read", "", config.APIKeyHeader, key)
+
+	var principal Principal
+	if err := json.Unmarshal(res.Body.Bytes(), &principal); err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "k1" || principal.Name != "mobile" || principal.Method != MethodAPIKey || principal.String() != "api_key:k1" {
+		t.Fatalf("principal = %+v", principal)
+	}
+}
+
+func TestAuthenticatorCachesKeys(t *testing.T) {
+	repo := newFakeAPIKeyRepository()
+	key := repo.add(data.APIKey{ID: "k1", Scopes: []string{data.ScopeRead}}, "secret")
+	router := newTestAPIKeyRouter(repo)
+	for i := 0; i < 3; i++ {
+		_ = do(t, router, http.MethodGet, "/read", "", config.APIKeyHeader, key)
+	}
+	if repo.gets != 1 {
+		t.Fatalf("the key was read %d times, want it cached", repo.gets)
+	}
+}
+
+// an unavailable key store is not reported as invalid credentials
+func TestRequireScopeWhenTheKeysCanNotBeRead(t *testing.T) {
+	authenticator := NewAuthenticator(testLogger(), failingAPIKeyRepository{newFakeAPIKeyRepository()}, nil, nil, "", time.Minute)
+	router := newTestAPIKeyRouter(newFakeAPIKeyRepository())
+	router.GET("/down", route(authenticator.RequireScope, data.ScopeRead, nil)...)
+
+	res := do(t, router, http.MethodGet, "/down", "", config.APIKeyHeader, FormatAPIKey("k1", "secret"))
+	if res.Code != http.StatusInternalServerError || problemCode(t, res) != CodeDatabaseError {
+		t.Fatalf("status = %d %s, want 500", res.Code, res.Body)
+	}
+	// an empty admin token does not match the one that is not configured
+	if res = do(t, router, http.MethodGet, "/down", "", config.AdminTokenHeader, ""); res.Code != http.StatusUnauthorized {
+		t.Fatalf("status without credentials = %d, want 401", res.Code)
+	}
+}
+
+// failingAPIKeyRepository fails every read
+type failingAPIKeyRepository struct {
+	*fakeAPIKeyRepository
+}
+
+func (failingAPIKeyRepository) Get(ctx context.Context, id string) (*data.APIKey, string, error) {
+	return nil, "", errors.New("cassandra is down")
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	reader := &Principal{Scopes: []string{data.ScopeRead}}
+	if !reader.HasScope(data.ScopeRead) || reader.HasScope(data.ScopeWrite) || reader.HasScope(data.ScopeAdmin) {
+		t.Errorf("reader scopes are wrong")
+	}
+	admin := &Principal{Scopes: []string{data.ScopeAdmin}}
+	if !admin.HasScope(data.ScopeRead) || !admin.HasScope(data.ScopeWrite) {
+		t.Errorf("admin does not have every scope")
+	}
+	if (&Principal{}).HasScope(data.ScopeRead) {
+		t.Errorf("a principal without scopes has the read scope")
+	}
+}
+
+// newTestVerifier returns a Verifier of tokens signed with the returned Ed25519 key
+func newTestVerifier(t *testing.T) (*token.Verifier, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := `{"keys":[{"kty":"OKP","kid":"k1","crv":"Ed25519","x":"` + base64.RawURLEncoding.EncodeToString(public) + `"}]}`
+	if err = os.WriteFile(path, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.NewKeySet(path, time.Hour, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.NewVerifier(keys, "https://gateway", "user-server", 0), private
+}
+
+func signToken(t *testing.T, key ed25519.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + base64.RawURLEncoding.EncodeToString(b)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
+
+func TestRequireScopeWithBearerTokens(t *testing.T) {
+	verifier, key := newTestVerifier(t)
+	scopes := map[string][]string{"users:read": {data.ScopeRead}, "users:admin": {data.ScopeAdmin}}
+	authenticator := NewAuthenticator(testLogger(), newFakeAPIKeyRepository(), verifier, scopes, "", time.Minute)
+	router := gin.New()
+	for _, scope := range []string{data.ScopeRead, data.ScopeWrite} {
+		router.GET("/"+scope, route(authenticator.RequireScope, scope, nil, func(ctx *gin.Context) {
+			ctx.JSON(http.StatusOK, gin.H{"principal": PrincipalFrom(ctx), "subject": SubjectFrom(ctx)})
+		})...)
+	}
+	claims := func(scope string, exp time.Duration) map[string]interface{} {
+		return map[string]interface{}{"iss": "https://gateway", "aud": "user-server", "sub": "alice", "scope": scope,
+			"roles": []string{"user"}, "exp": time.Now().Add(exp).Unix()}
+	}
+	reader := "Bearer " + signToken(t, key, claims("users:read profile", time.Hour))
+
+	res := do(t, router, http.MethodGet, "/read", "", "Authorization", reader)
+	var body struct {
+		Principal Principal `json:"principal"`
+		Subject   string    `json:"subject"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("read = %d %s", res.Code, res.Body)
+	}
+	p := body.Principal
+	if body.Subject != "alice" || p.Method != MethodBearer || len(p.Scopes) != 1 || p.Scopes[0] != data.ScopeRead || len(p.Roles) != 1 {
+		t.Fatalf("principal = %+v, subject = %q", p, body.Subject)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		auth   string
+		status int
+	}{
+		{"missing scope", "/write", reader, http.StatusForbidden},
+		{"admin scope", "/write", "Bearer " + signToken(t, key, claims("users:admin", time.Hour)), http.StatusOK},
+		{"lower case scheme", "/read", "bearer " + signToken(t, key, claims("users:read", time.Hour)), http.StatusOK},
+		{"expired", "/read", "Bearer " + signToken(t, key, claims("users:read", -time.Hour)), http.StatusUnauthorized},
+		{"unmapped scope", "/read", "Bearer " + signToken(t, key, claims("profile", time.Hour)), http.StatusForbidden},
+		{"other scheme", "/read", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized},
+		{"malformed", "/read", "Bearer abc", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if res := do(t, router, http.MethodGet, tt.path, "", "Authorization", tt.auth); res.Code != tt.status {
+			t.Errorf("%s: status = %d %s, want %d", tt.name, res.Code, res.Body, tt.status)
+		}
+	}
+
+	res = do(t, router, http.MethodGet, "/read", "")
+	if res.Code != http.StatusUnauthorized || res.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("read without credentials = %d %v, want 401 with a bearer challenge", res.Code, res.Header())
+	}
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+)
+
+// CacheBreaker provides the state of the cache circuit breaker, the admin calls
+// to Redis go through it so they fail fast while Redis is down
+type CacheBreaker interface {
+	Status() cache.BreakerStatus
+	Call(ctx context.Context, call func() error) error
+}
+
+// CacheHandler wraps instances needed to report and administrate the cache layer
+type CacheHandler struct {
+	logger  logging.Logger
+	breaker CacheBreaker
+	admin   cache.RedisCache
+}
+
+// NewCacheHandler returns a new CacheHandler instance
+func NewCacheHandler(l logging.Logger, b CacheBreaker, a cache.RedisCache) *CacheHandler {
+	return &CacheHandler{
+		logger:  l,
+		breaker: b,
+		admin:   a,
+	}
+}
+
+// Routes registers the cache status and the cache admin routes, the guard lets only
+// callers with the admin scope through to the admin routes
+func (c *CacheHandler) Routes(engine *gin.Engine, guard RouteGuard) {
+	engine.GET(config.CacheStatusPath, c.Status)
+
+	admin := engine.Group(config.CacheAdminPath)
+	{
+		admin.GET(config.CacheAdminStatsPath, route(guard, data.ScopeAdmin, nil, c.Stats)...)
+		admin.GET(config.CacheAdminUserPath, route(guard, data.ScopeAdmin, nil, c.Inspect)...)
+		admin.DELETE(config.CacheAdminUserPath, route(guard, data.ScopeAdmin, nil, c.Evict)...)
+		admin.DELETE(config.CacheAdminUsersPath, route(guard, data.ScopeAdmin, nil, c.EvictPattern)...)
+		admin.DELETE("", route(guard, data.ScopeAdmin, nil, c.Flush)...)
+	}
+}
+
+// CacheStatsResponse combines the cache counters with the circuit breaker state
+type CacheStatsResponse struct {
+	cache.Stats
+	Breaker cache.BreakerStatus `json:"breaker"`
+}
+
+// EvictResponse is the number of keys removed from the cache
+type EvictResponse struct {
+	Evicted int `json:"evicted"`
+}
+
+// CacheStatusResponse is the public part of the circuit breaker status, the
+// counters and the last Redis error are left out since the route is not protected
+type CacheStatusResponse struct {
+	State cache.BreakerState `json:"state"`
+}
+
+// Status returns the state of the cache circuit breaker
+// @Summary cache status
+// @Tags cache
+// @Description circuit breaker state of the cache layer
+// @ID cache-status
+// @Produce json
+// @Success 200 {object} CacheStatusResponse
+// @Router /cache/status [get]
+func (c *CacheHandler) Status(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+	status := c.breaker.Status()
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{
+		Status:  status.State == cache.StateClosed,
+		Message: "cache is " + string(status.State),
+		Data:    &CacheStatusResponse{State: status.State},
+	}, ctx.Writer)
+}
+
+// Stats returns the cache counters
+// @Summary cache statistics
+// @Tags cache
+// @Description hits, misses, evictions and errors of the cache since startup
+// @ID cache-stats
+// @Produce json
+// @Security APIKey
+// @Security Bearer
+// @Success 200 {object} CacheStatsResponse
+// @Failure 401,403 {object} Problem
+// @Router /admin/cache/stats [get]
+func (c *CacheHandler) Stats(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{
+		Status:  true,
+		Message: "cache statistics",
+		Data:    &CacheStatsResponse{Stats: c.admin.Stats(), Breaker: c.breaker.Status()},
+	}, ctx.Writer)
+}
+
+// Inspect returns the cached entry of a user
+// @Summary inspect cached user
+// @Tags cache
+// @Description value, ttl and codec of a cached user
+// @ID cache-inspect
+// @Produce json
+// @Security APIKey
+// @Security Bearer
+// @Param username path string true "username"
+// @Success 200 {object} cache.EntryDetails
+// @Failure 401,403,404,500 {object} Problem
+// @Router /admin/cache/users/{username} [get]
+func (c *CacheHandler) Inspect(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+	username := ctx.Param("username")
+
+	var entry *cache.EntryDetails
+	err := c.breaker.Call(ctx.Request.Context(), func() (err error) {
+		entry, err = c.admin.Inspect(username)
+		return err
+	})
+	if err != nil {
+		requestLogger(ctx, c.logger).Error("error while inspecting cached user", "error", err)
+		abortWithProblem(ctx, CodeCacheError, err.Error())
+		return
+	}
+	if entry == nil {
+		abortWithProblem(ctx, CodeEntryNotCached, "user is not cached")
+		return
+	}
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{Status: true, Message: "cached user found", Data: entry}, ctx.Writer)
+}
+
+// Evict removes a user from the cache
+// @Summary evict cached user
+// @Tags cache
+// @Description invalidate the cached entry of a user
+// @ID cache-evict
+// @Produce json
+// @Security APIKey
+// @Security Bearer
+// @Param username path string true "username"
+// @Success 200 {object} EvictResponse
+// @Failure 401,403,500 {object} Problem
+// @Router /admin/cache/users/{username} [delete]
+func (c *CacheHandler) Evict(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+	username := ctx.Param("username")
+
+	err := c.breaker.Call(ctx.Request.Context(), func() error {
+		return c.admin.Invalidate(ctx.Request.Context(), username)
+	})
+	if err != nil {
+		requestLogger(ctx, c.logger).Error("error while evicting cached user", "error", err)
+		abortWithProblem(ctx, CodeCacheError, err.Error())
+		return
+	}
+	requestLogger(ctx, c.logger).Info("evicted cached user ", username)
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{Status: true, Message: "cached user evicted", Data: &EvictResponse{Evicted: 1}}, ctx.Writer)
+}
+
+// EvictPattern removes the users matching a pattern from the cache
+// @Summary evict cached users
+// @Tags cache
+// @Description invalidate the cached entries of all users matching a redis glob pattern
+// @ID cache-evict-pattern
+// @Produce json
+// @Security APIKey
+// @Security Bearer
+// @Param pattern query string true "username pattern, e.g. john*"
+// @Success 200 {object} EvictResponse
+// @Failure 400,401,403,500 {object} Problem
+// @Router /admin/cache/users [delete]
+func (c *CacheHandler) EvictPattern(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+	pattern := ctx.Query("pattern")
+	if pattern == "" {
+		abortWithProblem(ctx, CodeInvalidBody, "pattern query parameter is required")
+		return
+	}
+
+	var evicted int
+	err := c.breaker.Call(ctx.Request.Context(), func() (err error) {
+		evicted, err = c.admin.EvictPattern(pattern)
+		return err
+	})
+	if err != nil {
+		requestLogger(ctx, c.logger).Error("error while evicting cached users", "error", err)
+		abortWithProblem(ctx, CodeCacheError, err.Error())
+		return
+	}
+	requestLogger(ctx, c.logger).Info("evicted ", evicted, " cached users matching ", pattern)
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{Status: true, Message: "cached users evicted", Data: &EvictResponse{Evicted: evicted}}, ctx.Writer)
+}
+
+// Flush removes every cached user from redis
+// @Summary flush cache
+// @Tags cache
+// @Description remove every cached entry of the service namespace from redis
+// @ID cache-flush
+// @Produce json
+// @Security APIKey
+// @Security Bearer
+// @Success 200 {object} EvictResponse
+// @Failure 401,403,500 {object} Problem
+// @Router /admin/cache [delete]
+func (c *CacheHandler) Flush(ctx *gin.Context) {
+	ctx.Set("Content-Type", "application/json")
+
+	var evicted int
+	err := c.breaker.Call(ctx.Request.Context(), func() (err error) {
+		evicted, err = c.admin.Flush()
+		return err
+	})
+	if err != nil {
+		requestLogger(ctx, c.logger).Error("error while flushing the cache", "error", err)
+		abortWithProblem(ctx, CodeCacheError, err.Error())
+		return
+	}
+	requestLogger(ctx, c.logger).Warn("flushed ", evicted, " cache entries")
+	ctx.AbortWithStatus(http.StatusOK)
+	_ = data.ToJSON(&GenericResponse{Status: true, Message: "cache flushed", Data: &EvictResponse{Evicted: evicted}}, ctx.Writer)
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"net/http"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+// breakerStatus is a CacheBreaker with a fixed status, calls are rejected unless it is closed
+type breakerStatus cache.BreakerStatus
+
+func (b breakerStatus) Status() cache.BreakerStatus {
+	return cache.BreakerStatus(b)
+}
+
+func (b breakerStatus) Call(ctx context.Context, call func() error) error {
+	if b.State != cache.StateClosed {
+		return cache.ErrCircuitOpen
+	}
+	return call()
+}
+
+func TestCacheStatusReportsBreakerState(t *testing.T) {
+	tests := []struct {
+		state  cache.BreakerState
+		status bool
+	}{
+		{cache.StateClosed, true},
+		{cache.StateOpen, false},
+		{cache.StateHalfOpen, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			router := gin.New()
+			status := breakerStatus{State: tt.state, PendingInvalidations: 2, LastError: "dial tcp 10.0.0.7:6379: connection refused"}
+			NewCacheHandler(testLogger(), status, nil).Routes(router, testGuard)
+
+			res := do(t, router, http.MethodGet, config.CacheStatusPath, "")
+			if res.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", res.Code)
+			}
+			var body struct {
+				Status bool
+				Data   map[string]interface{}
+			}
+			if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			// the route is public, only the state is reported
+			if body.Status != tt.status || len(body.Data) != 1 || body.Data["state"] != string(tt.state) {
+				t.Fatalf("body = %+v, want only state %s", body, tt.state)
+			}
+		})
+	}
+}
+
+// newTestRedisCache returns a RedisCache backed by an in-memory redis server
+func newTestRedisCache(t *testing.T) cache.RedisCache {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	keys := cache.KeyScheme{Service: "user-server", Entity: "user", SchemaVersion: data.UserSchemaVersion}
+	codec, _ := cache.CodecByName("json")
+	return cache.NewRedisCache(client, keys, cache.NewSerializer(codec, 0), 60, testLogger())
+}
+
+// admin is the principalHeader of a caller with the admin scope
+const admin = data.ScopeAdmin
+
+// evicted returns the number of evicted keys of an EvictResponse
+func evicted(t *testing.T, body []byte) int {
+	t.Helper()
+	var res struct{ Data EvictResponse }
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Data.Evicted
+}
+
+func TestCacheAdminEndpoints(t *testing.T) {
+	ctx := context.Background()
+	redisCache := newTestRedisCache(t)
+	for _, name := range []string{"john", "johnny", "jane"} {
+		_ = redisCache.Set(ctx, name, &data.User{Username: name})
+	}
+	router := gin.New()
+	NewCacheHandler(testLogger(), breakerStatus{State: cache.StateClosed}, redisCache).Routes(router, testGuard)
+
+	res := do(t, router, http.MethodGet, "/admin/cache/users/jane", "", principalHeader, admin)
+	if res.Code != http.StatusOK {
+		t.Fatalf("inspect = %d %s", res.Code, res.Body)
+	}
+	res = do(t, router, http.MethodGet, "/admin/cache/users/nobody", "", principalHeader, admin)
+	if res.Code != http.StatusNotFound || problemCode(t, res) != CodeEntryNotCached {
+		t.Fatalf("inspect of a missing user = %d %s", res.Code, res.Body)
+	}
+
+	res = do(t, router, http.MethodDelete, "/admin/cache/users", "", principalHeader, admin)
+	if res.Code != http.StatusBadRequest || problemCode(t, res) != CodeInvalidBody {
+		t.Fatalf("evict without pattern = %d %s", res.Code, res.Body)
+	}
+	res = do(t, router, http.MethodDelete, "/admin/cache/users?pattern=john*", "", principalHeader, admin)
+	if res.Code != http.StatusOK || evicted(t, res.Body.Bytes()) != 2 {
+		t.Fatalf("evict pattern = %d %s, want 2 evicted", res.Code, res.Body)
+	}
+
+	res = do(t, router, http.MethodDelete, "/admin/cache", "", principalHeader, admin)
+	if res.Code != http.StatusOK || evicted(t, res.Body.Bytes()) != 1 {
+		t.Fatalf("flush = %d %s, want only jane flushed", res.Code, res.Body)
+	}
+	if user, _ := redisCache.Get(ctx, "jane"); user != nil {
+		t.Fatal("jane is still cached after the flush")
+	}
+	if version, _ := redisCache.Version(ctx, "john"); version != 1 {
+		t.Fatalf("version of john = %d after the flush, want it kept", version)
+	}
+
+	res = do(t, router, http.MethodGet, "/admin/cache/stats", "", principalHeader, admin)
+	var stats struct{ Data CacheStatsResponse }
+	if err := json.Unmarshal(res.Body.Bytes(), &stats); err != nil || stats.Data.Evictions != 3 || stats.Data.Breaker.State != cache.StateClosed {
+		t.Fatalf("stats = %s, want 3 evictions", res.Body)
+	}
+}
+
+func TestCacheAdminRoutesRequireTheAdminScope(t *testing.T) {
+	router := gin.New()
+	NewCacheHandler(testLogger(), breakerStatus{State: cache.StateClosed}, newTestRedisCache(t)).Routes(router, testGuard)
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/admin/cache/stats"},
+		{http.MethodGet, "/admin/cache/users/jane"},
+		{http.MethodDelete, "/admin/cache/users/jane"},
+		{http.MethodDelete, "/admin/cache/users?pattern=*"},
+		{http.MethodDelete, "/admin/cache"},
+	}
+	for _, r := range routes {
+		if res := do(t, router, r.method, r.path, ""); res.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without credentials = %d, want 401", r.method, r.path, res.Code)
+		}
+		if res := do(t, router, r.method, r.path, "", principalHeader, writer); res.Code != http.StatusForbidden {
+			t.Errorf("%s %s with the write scope = %d, want 403", r.method, r.path, res.Code)
+		}
+	}
+	// the status endpoint is public
+	if res := do(t, router, http.MethodGet, config.CacheStatusPath, ""); res.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", res.Code)
+	}
+}
+
+func TestCacheAdminCallsGoThroughTheBreaker(t *testing.T) {
+	ctx := context.Background()
+	redisCache := newTestRedisCache(t)
+	_ = redisCache.Set(ctx, "jane", &data.User{Username: "jane"})
+	router := gin.New()
+	NewCacheHandler(testLogger(), breakerStatus{State: cache.StateOpen}, redisCache).Routes(router, testGuard)
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/admin/cache/users/jane"},
+		{http.MethodDelete, "/admin/cache/users/jane"},
+		{http.MethodDelete, "/admin/cache/users?pattern=j*"},
+		{http.MethodDelete, "/admin/cache"},
+	} {
+		res := do(t, router, req.method, req.path, "", principalHeader, admin)
+		if res.Code != http.StatusInternalServerError || problemCode(t, res) != CodeCacheError {
+			t.Errorf("%s %s with an open breaker = %d, want 500", req.method, req.path, res.Code)
+		}
+	}
+	if user, _ := redisCache.Get(ctx, "jane"); user == nil {
+		t.Fatal("redis was called while the breaker is open")
+	}
+}
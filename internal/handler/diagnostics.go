@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"time"
+)
+
+// DiagnosticsHandler serves the profiles, the goroutines, the build and the configuration
+// of the process, and changes its log level. It is served on the admin listener only.
+type DiagnosticsHandler struct {
+	logger   logging.Logger
+	started  time.Time
+	settings func() map[string]interface{}
+}
+
+// NewDiagnosticsHandler returns a new DiagnosticsHandler instance, settings returns the
+// effective configuration with the secrets redacted
+func NewDiagnosticsHandler(l logging.Logger, settings func() map[string]interface{}) *DiagnosticsHandler {
+	return &DiagnosticsHandler{logger: l, started: time.Now(), settings: settings}
+}
+
+// Routes registers the diagnostics endpoints
+func (d *DiagnosticsHandler) Routes(engine *gin.Engine) {
+	debugGroup := engine.Group(config.DebugPath)
+	{
+		debugGroup.GET(config.DebugPprofPath, d.Profile)
+		debugGroup.POST(config.DebugPprofPath, d.Profile)
+		debugGroup.GET(config.DebugGoroutinesPath, d.Goroutines)
+		debugGroup.GET(config.DebugBuildInfoPath, d.BuildInfo)
+		debugGroup.GET(config.DebugConfigPath, d.Config)
+		debugGroup.GET(config.DebugLogLevelPath, d.LogLevel)
+		debugGroup.PUT(config.DebugLogLevelPath, d.SetLogLevel)
+	}
+}
+
+// Profile serves net/http/pprof, /debug/pprof/ lists the profiles
+func (d *DiagnosticsHandler) Profile(ctx *gin.Context) {
+	switch ctx.Param("profile") {
+	case "/cmdline":
+		pprof.Cmdline(ctx.Writer, ctx.Request)
+	case "/profile":
+		pprof.Profile(ctx.Writer, ctx.Request)
+	case "/symbol":
+		pprof.Symbol(ctx.Writer, ctx.Request)
+	case "/trace":
+		pprof.Trace(ctx.Writer, ctx.Request)
+	default:
+		// Index serves the named profiles, e.g. /debug/pprof/heap
+		pprof.Index(ctx.Writer, ctx.Request)
+	}
+}
+
+// Goroutines writes the stack traces of all goroutines as text
+func (d *DiagnosticsHandler) Goroutines(ctx *gin.Context) {
+	ctx.Header("Content-Type", "text/plain; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	if err := rpprof.Lookup("goroutine").WriteTo(ctx.Writer, 2); err != nil {
+		requestLogger(ctx, d.logger).Error("error while dumping the goroutines", "error", err)
+	}
+}
+
+// Module is a module the binary was built with
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// BuildInfoResponse describes the binary and the process running it
+type BuildInfoResponse struct {
+	GoVersion    string    `json:"go_version"`
+	Path         string    `json:"path,omitempty"`
+	Main         *Module   `json:"main,omitempty"`
+	Dependencies []Module  `json:"dependencies,omitempty"`
+	OS           string    `json:"os"`
+	Arch         string    `json:"arch"`
+	NumCPU       int       `json:"num_cpu"`
+	GOMAXPROCS   int       `json:"gomaxprocs"`
+	Goroutines   int       `json:"goroutines"`
+	PID          int       `json:"pid"`
+	Hostname     string    `json:"hostname,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	Uptime       string    `json:"uptime"`
+}
+
+// BuildInfo reports the version of Go and of the modules the binary was built with
+func (d *DiagnosticsHandler) BuildInfo(ctx *gin.Context) {
+	res := &BuildInfoResponse{
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		PID:        os.Getpid(),
+		StartedAt:  d.started,
+		Uptime:     time.Since(d.started).Truncate(time.Second).String(),
+	}
+	res.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		res.Path = info.Path
+		res.Main = &Module{Path: info.Main.Path, Version: info.Main.Version, Sum: info.Main.Sum}
+		for _, dep := range info.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			res.Dependencies = append(res.Dependencies, Module{Path: dep.Path, Version: dep.Version, Sum: dep.Sum})
+		}
+	}
+	writeJSON(ctx, http.StatusOK, res)
+}
+
+// Config returns the effective configuration, passwords and tokens are redacted
+func (d *DiagnosticsHandler) Config(ctx *gin.Context) {
+	writeJSON(ctx, http.StatusOK, d.settings())
+}
+
+// LogLevelDTO is the level of the logger, one of trace, debug, info, warning, error, fatal and panic
+type LogLevelDTO struct {
+	Level string `json:"level"`
+}
+
+// LogLevel returns the current log level
+func (d *DiagnosticsHandler) LogLevel(ctx *gin.Context) {
+	writeJSON(ctx, http.StatusOK, &LogLevelDTO{Level: d.logger.Logger.GetLevel().String()})
+}
+
+// SetLogLevel changes the log level until the process restarts
+func (d *DiagnosticsHandler) SetLogLevel(ctx *gin.Context) {
+	dto := &LogLevelDTO{}
+	if err := ctx.ShouldBindJSON(dto); err != nil {
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return
+	}
+	level, err := logrus.ParseLevel(dto.Level)
+	if err != nil {
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return
+	}
+	// logged before the change, so it is not filtered out when the level is raised
+	requestLogger(ctx, d.logger).Warn("changing the log level from ", d.logger.Logger.GetLevel(), " to ", level)
+	d.logger.Logger.SetLevel(level)
+	writeJSON(ctx, http.StatusOK, &LogLevelDTO{Level: level.String()})
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"runtime"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"strings"
+	"testing"
+)
+
+func newTestDiagnosticsRouter(l logging.Logger, settings map[string]interface{}) *gin.Engine {
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(NoRoute)
+	router.NoMethod(NoMethod)
+	NewDiagnosticsHandler(l, func() map[string]interface{} { return settings }).Routes(router)
+	return router
+}
+
+func TestDiagnosticsProfiles(t *testing.T) {
+	router := newTestDiagnosticsRouter(testLogger(), nil)
+	pprofPath := config.DebugPath + "/pprof/"
+
+	res := do(t, router, http.MethodGet, pprofPath, "")
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "goroutine") || !strings.Contains(res.Body.String(), "heap") {
+		t.Fatalf("profile index = %d %s", res.Code, res.Body)
+	}
+	// the named profiles are served by the index
+	if res = do(t, router, http.MethodGet, pprofPath+"heap?debug=1", ""); res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "heap profile") {
+		t.Fatalf("heap profile = %d %.200s", res.Code, res.Body)
+	}
+	if res = do(t, router, http.MethodGet, pprofPath+"cmdline", ""); res.Code != http.StatusOK || res.Body.Len() == 0 {
+		t.Fatalf("cmdline = %d %s", res.Code, res.Body)
+	}
+	if res = do(t, router, http.MethodGet, pprofPath+"nope", ""); res.Code != http.StatusNotFound {
+		t.Fatalf("unknown profile = %d, want 404", res.Code)
+	}
+}
+
+func TestDiagnosticsGoroutines(t *testing.T) {
+	router := newTestDiagnosticsRouter(testLogger(), nil)
+
+	res := do(t, router, http.MethodGet, config.DebugPath+config.DebugGoroutinesPath, "")
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("goroutines = %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	// the dump holds the full stack of every goroutine, this test included
+	if body := res.Body.String(); !strings.Contains(body, "goroutine ") || !strings.Contains(body, "TestDiagnosticsGoroutines") {
+		t.Fatalf("goroutines = %.500s", body)
+	}
+}
+
+func TestDiagnosticsBuildInfo(t *testing.T) {
+	router := newTestDiagnosticsRouter(testLogger(), nil)
+
+	res := do(t, router, http.MethodGet, config.DebugPath+config.DebugBuildInfoPath, "")
+	var info BuildInfoResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &info); err != nil || res.Code != http.StatusOK {
+		t.Fatalf("build info = %d %s", res.Code, res.Body)
+	}
+	if info.GoVersion != runtime.Version() || info.OS != runtime.GOOS || info.Arch != runtime.GOARCH || info.PID == 0 || info.StartedAt.IsZero() {
+		t.Fatalf("build info = %+v", info)
+	}
+	if info.Main == nil || info.Main.Path != "sceyt_task" {
+		t.Fatalf("main module = %+v, want sceyt_task", info.Main)
+	}
+	found := false
+	for _, dep := range info.Dependencies {
+		found = found || dep.Path == "github.com/gin-gonic/gin" && dep.Version != ""
+	}
+	if !found {
+		t.Errorf("dependencies = %+v, want gin with its version", info.Dependencies)
+	}
+}
+
+func TestDiagnosticsConfig(t *testing.T) {
+	settings := map[string]interface{}{"admin": map[string]string{"Token": "[REDACTED]", "Address": "127.0.0.1:6060"}}
+	router := newTestDiagnosticsRouter(testLogger(), settings)
+
+	res := do(t, router, http.MethodGet, config.DebugPath+config.DebugConfigPath, "")
+	if res.Code != http.StatusOK || strings.TrimSpace(res.Body.String()) != `{"admin":{"Address":"127.0.0.1:6060","Token":"[REDACTED]"}}` {
+		t.Fatalf("config = %d %s", res.Code, res.Body)
+	}
+}
+
+func TestDiagnosticsLogLevel(t *testing.T) {
+	l := testLogger()
+	l.Logger.SetLevel(logrus.InfoLevel)
+	router := newTestDiagnosticsRouter(l, nil)
+	path := config.DebugPath + config.DebugLogLevelPath
+
+	if res := do(t, router, http.MethodGet, path, ""); res.Code != http.StatusOK || strings.TrimSpace(res.Body.String()) != `{"level":"info"}` {
+		t.Fatalf("log level = %d %s", res.Code, res.Body)
+	}
+	res := do(t, router, http.MethodPut, path, `{"level":"debug"}`)
+	if res.Code != http.StatusOK || strings.TrimSpace(res.Body.String()) != `{"level":"debug"}` || l.Logger.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("set log level = %d %s, level %s", res.Code, res.Body, l.Logger.GetLevel())
+	}
+	if res = do(t, router, http.MethodGet, path, ""); strings.TrimSpace(res.Body.String()) != `{"level":"debug"}` {
+		t.Fatalf("log level after the change = %s", res.Body)
+	}
+
+	for name, body := range map[string]string{"unknown level": `{"level":"loud"}`, "not json": `level=debug`} {
+		res = do(t, router, http.MethodPut, path, body)
+		if res.Code != http.StatusBadRequest || problemCode(t, res) != CodeInvalidBody {
+			t.Errorf("%s: set log level = %d %s, want 400", name, res.Code, res.Body)
+		}
+	}
+	if l.Logger.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("a rejected change set the level to %s", l.Logger.GetLevel())
+	}
+	if res = do(t, router, http.MethodPost, path, `{"level":"info"}`); res.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST log level = %d, want 405", res.Code)
+	}
+}
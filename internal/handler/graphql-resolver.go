@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/graph-gophers/graphql-go"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/pkg/logging"
+)
+
+// graphQLResolver resolves the queries and the mutations of api/graphql/schema.graphql with
+// the user handler
+type graphQLResolver struct {
+	users *UserHandler
+}
+
+// graphQLArguments maps the json names of the validated fields to the arguments of the mutations
+var graphQLArguments = map[string]string{"firstname": "firstName", "lastname": "lastName"}
+
+// fieldError is the error of a field with the code of the REST API, the extensions are
+// copied to the error of the response
+type fieldError struct {
+	message    string
+	extensions map[string]interface{}
+}
+
+func newFieldError(code ErrorCode, message string) *fieldError {
+	return &fieldError{message: message, extensions: map[string]interface{}{"code": code}}
+}
+
+func (e *fieldError) Error() string {
+	return e.message
+}
+
+func (e *fieldError) Extensions() map[string]interface{} {
+	return e.extensions
+}
+
+// authorize checks the permission of the caller on the user
+func (r *graphQLResolver) authorize(ctx context.Context, action string, username string) error {
+	call := callFrom(ctx)
+	if r.users.policy.Allowed(call.principal, action, username) {
+		return nil
+	}
+	logging.FromContext(ctx, r.users.logger).Warn("denied ", action, " of user ", username, " to ", call.principal)
+	return newFieldError(CodeForbidden, "not allowed to "+action+" this user")
+}
+
+// validate validates the arguments like the body of the REST API
+func (r *graphQLResolver) validate(ctx context.Context, req interface{}) error {
+	errs := r.users.validator.Validate(req)
+	if len(errs) == 0 {
+		return nil
+	}
+	logging.FromContext(ctx, r.users.logger).Error("validation of arguments failed", "error", errs)
+	trans := r.users.validator.Translator(callFrom(ctx).acceptLanguage)
+	fields := make([]ValidationError, 0, len(errs))
+	for _, err := range errs {
+		field := err.Field()
+		if argument, ok := graphQLArguments[field]; ok {
+			field = argument
+		}
+		fields = append(fields, ValidationError{Field: field, Rule: err.Tag(), Message: err.Translate(trans)})
+	}
+	fieldErr := newFieldError(CodeValidationFailed, "one or more arguments are invalid")
+	fieldErr.extensions["errors"] = fields
+	return fieldErr
+}
+
+// storageError maps an error of the repository to the error of the field
+func (r *graphQLResolver) storageError(ctx context.Context, message string, err error) error {
+	switch err {
+	case repository.ErrUserNotFound:
+		return newFieldError(CodeUserNotFound, ErrUserNotFound)
+	case repository.ErrUserExists:
+		return newFieldError(CodeUserExists, ErrUserExists)
+	}
+	logging.FromContext(ctx, r.users.logger).Error(message, "error", err)
+	return newFieldError(CodeDatabaseError, message+", please try again later")
+}
+
+func (r *graphQLResolver) User(ctx context.Context, args struct{ Username string }) (*userResolver, error) {
+	req := &UsernameRequest{Username: args.Username}
+	if err := r.validate(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, ActionRead, req.Username); err != nil {
+		return nil, err
+	}
+
+	user, err := r.users.loadUser(ctx, req.Username)
+	if err != nil {
+		return nil, r.storageError(ctx, "unable to retrieve user from database", err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (r *graphQLResolver) Users(ctx context.Context, args struct {
+	First int32
+	After *string
+}) (*userPage, error) {
+	if args.First < 1 || args.First > config.GraphQLMaxPageSize {
+		return nil, newFieldError(CodeValidationFailed, fmt.Sprintf("first must be between 1 and %d", config.GraphQLMaxPageSize))
+	}
+	var pageState []byte
+	if args.After != nil {
+		var err error
+		if pageState, err = base64.RawURLEncoding.DecodeString(*args.After); err != nil {
+			return nil, newFieldError(CodeValidationFailed, "after is not a cursor of the users connection")
+		}
+	}
+	// listing is only allowed to callers that can read every user
+	if err := r.authorize(ctx, ActionRead, ""); err != nil {
+		return nil, err
+	}
+
+	users, next, err := r.users.repo.List(ctx, int(args.First), pageState)
+	if err != nil {
+		return nil, r.storageError(ctx, "error while listing users", err)
+	}
+	return &userPage{users: users, next: next}, nil
+}
+
+func (r *graphQLResolver) AddUser(ctx context.Context, args struct{ Username, FirstName, LastName string }) (*userResolver, error) {
+	req := &CreateUserRequest{Username: args.Username, FirstName: args.FirstName, LastName: args.LastName}
+	if err := r.validate(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, ActionCreate, req.Username); err != nil {
+		return nil, err
+	}
+
+	user := req.User()
+	if err := r.users.repo.Create(ctx, user); err != nil {
+		return nil, r.storageError(ctx, "error while adding user", err)
+	}
+	return &userResolver{user}, nil
+}
+
+// UpdateUser answers the stored user with the changes applied, it is not read again after
+// the write so that a committed update is never reported as failed
+func (r *graphQLResolver) UpdateUser(ctx context.Context, args struct {
+	Username  string
+	FirstName graphql.NullString
+	LastName  graphql.NullString
+}) (*userResolver, error) {
+	// arguments that are not given stay unchanged and null clears the field
+	req := &UpdateUserRequest{Username: args.Username}
+	if args.FirstName.Set {
+		req.FirstName = OptionalString{Set: true}
+		if args.FirstName.Value != nil {
+			req.FirstName.Value = *args.FirstName.Value
+		}
+	}
+	if args.LastName.Set {
+		req.LastName = OptionalString{Set: true}
+		if args.LastName.Value != nil {
+			req.LastName.Value = *args.LastName.Value
+		}
+	}
+	if err := r.validate(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, ActionUpdate, req.Username); err != nil {
+		return nil, err
+	}
+
+	current, err := r.users.loadUser(ctx, req.Username)
+	if err != nil {
+		return nil, r.storageError(ctx, "error while updating user", err)
+	}
+	changes := req.Changes()
+	user := *current
+	if changes.FirstName != nil {
+		user.FirstName = *changes.FirstName
+	}
+	if changes.LastName != nil {
+		user.LastName = *changes.LastName
+	}
+	if err = r.users.repo.Patch(ctx, req.Username, changes); err != nil {
+		return nil, r.storageError(ctx, "error while updating user", err)
+	}
+	r.users.invalidate(ctx, req.Username)
+	return &userResolver{&user}, nil
+}
+
+func (r *graphQLResolver) DeleteUser(ctx context.Context, args struct{ Username string }) (*bool, error) {
+	req := &UsernameRequest{Username: args.Username}
+	if err := r.validate(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := r.authorize(ctx, ActionDelete, req.Username); err != nil {
+		return nil, err
+	}
+
+	if err := r.users.repo.Delete(ctx, req.Username); err != nil {
+		return nil, r.storageError(ctx, "error when deleting user", err)
+	}
+	r.users.invalidate(ctx, req.Username)
+	deleted := true
+	return &deleted, nil
+}
+
+// userResolver resolves the User type
+type userResolver struct {
+	user *data.User
+}
+
+func (u *userResolver) ID() graphql.ID {
+	return graphql.ID(u.user.ID)
+}
+
+func (u *userResolver) Username() string {
+	return u.user.Username
+}
+
+func (u *userResolver) FirstName() string {
+	return u.user.FirstName
+}
+
+func (u *userResolver) LastName() string {
+	return u.user.LastName
+}
+
+// userPage is a page of the users connection, it resolves the UserConnection and the
+// PageInfo types. next is the page state of the next page.
+type userPage struct {
+	users []*data.User
+	next  []byte
+}
+
+func (p *userPage) Nodes() []*userResolver {
+	nodes := make([]*userResolver, len(p.users))
+	for i, user := range p.users {
+		nodes[i] = &userResolver{user}
+	}
+	return nodes
+}
+
+func (p *userPage) PageInfo() *userPage {
+	return p
+}
+
+func (p *userPage) HasNextPage() bool {
+	return p.next != nil
+}
+
+func (p *userPage) EndCursor() *string {
+	if p.next == nil {
+		return nil
+	}
+	cursor := base64.RawURLEncoding.EncodeToString(p.next)
+	return &cursor
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/introspection"
+	"github.com/graph-gophers/graphql-go/trace/tracer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"mime"
+	"net/http"
+	graphqlapi "sceyt_task/api/graphql"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sync"
+)
+
+// GraphQLHandler serves the users over GraphQL with the repository, the cache, the
+// validation and the permissions of the REST API. Errors carry the codes of the REST API
+// in their extensions.
+type GraphQLHandler struct {
+	users  *UserHandler
+	schema *graphql.Schema
+}
+
+// NewGraphQLHandler returns a new GraphQLHandler instance, it panics if the resolvers do not
+// match api/graphql/schema.graphql
+func NewGraphQLHandler(u *UserHandler) *GraphQLHandler {
+	return &GraphQLHandler{
+		users: u,
+		schema: graphql.MustParseSchema(graphqlapi.Schema, &graphQLResolver{users: u},
+			graphql.MaxDepth(config.GraphQLMaxDepth), graphql.Tracer(graphQLLimits{maxComplexity: config.GraphQLMaxComplexity}),
+			graphql.DisableIntrospection()),
+	}
+}
+
+// Routes registers the GraphQL endpoint, the guard checks the read scope and mutations
+// also need the write scope
+func (h *GraphQLHandler) Routes(engine *gin.Engine, guard RouteGuard, middlewares ...gin.HandlerFunc) {
+	engine.GET(config.GraphQLPath, route(guard, data.ScopeRead, middlewares, h.ServeGet)...)
+	engine.POST(config.GraphQLPath, route(guard, data.ScopeRead, middlewares, h.Serve)...)
+}
+
+// GraphQLRequest is an operation sent to the GraphQL endpoint
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLResponse is the result of an operation, or the errors of a request that could not be executed
+type GraphQLResponse struct {
+	Data   json.RawMessage         `json:"data,omitempty" swaggertype:"object"`
+	Errors []*gqlerrors.QueryError `json:"errors,omitempty" swaggertype:"array,object"`
+}
+
+// graphQLCall is the caller of an operation and the state of its execution
+type graphQLCall struct {
+	principal      *Principal
+	acceptLanguage string
+	method         string
+
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	operation  string
+	mutation   bool
+	complexity int
+	status     int
+	rejection  *gqlerrors.QueryError
+}
+
+type graphQLCallKey struct{}
+
+func callFrom(ctx context.Context) *graphQLCall {
+	call, _ := ctx.Value(graphQLCallKey{}).(*graphQLCall)
+	return call
+}
+
+// field counts a field of the operation. The first mutation field is checked before it is
+// resolved, mutations have to be sent with POST by a caller with the write scope. Once a
+// mutation ran the operation is completed, so that committed writes are always answered.
+func (c *graphQLCall) field(mutation bool, maxComplexity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.complexity++
+	switch {
+	case mutation && c.method != http.MethodPost:
+		c.reject(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "mutations have to be sent with POST")
+	case mutation && !c.principal.HasScope(data.ScopeWrite):
+		c.reject(http.StatusForbidden, CodeForbidden, "the "+data.ScopeWrite+" scope is required")
+	case mutation:
+		c.mutation = true
+	case c.complexity > maxComplexity && !c.mutation:
+		c.reject(http.StatusBadRequest, CodeQueryTooComplex, fmt.Sprintf("the operation resolves more than %d fields", maxComplexity))
+	}
+}
+
+// reject stops the execution, the operation is answered with the error only
+func (c *graphQLCall) reject(status int, code ErrorCode, message string) {
+	if c.rejection == nil {
+		c.status, c.rejection = status, graphQLError(code, message)
+		c.cancel()
+	}
+}
+
+// graphQLLimits is the tracer of the schema, it limits the complexity of the operations and
+// rejects the mutations the caller may not run
+type graphQLLimits struct {
+	maxComplexity int
+}
+
+func (l graphQLLimits) TraceQuery(ctx context.Context, _ string, operationName string, _ map[string]interface{},
+	_ map[string]*introspection.Type) (context.Context, tracer.QueryFinishFunc) {
+	call := callFrom(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	call.operation, call.cancel = operationName, cancel
+	return ctx, func([]*gqlerrors.QueryError) { cancel() }
+}
+
+func (l graphQLLimits) TraceField(ctx context.Context, _ string, typeName string, _ string, _ bool,
+	_ map[string]interface{}) (context.Context, tracer.FieldFinishFunc) {
+	callFrom(ctx).field(typeName == "Mutation", l.maxComplexity)
+	return ctx, func(*gqlerrors.QueryError) {}
+}
+
+// Serve executes a GraphQL operation
+// @Summary GraphQL
+// @Tags graphql
+// @Description execute a GraphQL query or mutation, the schema is in api/graphql/schema.graphql
+// @ID graphql-post
+// @Security APIKey
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param input body GraphQLRequest true "operation"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
+// @Success 200 {object} GraphQLResponse
+// @Failure 400,403,415 {object} GraphQLResponse
+// @Failure 401 {object} Problem
+// @Router /graphql [post]
+func (h *GraphQLHandler) Serve(ctx *gin.Context) {
+	req, ok := h.decodeRequest(ctx)
+	if !ok {
+		return
+	}
+	call := &graphQLCall{principal: PrincipalFrom(ctx), acceptLanguage: ctx.GetHeader("Accept-Language"), method: ctx.Request.Method}
+	res := h.schema.Exec(context.WithValue(ctx.Request.Context(), graphQLCallKey{}, call), req.Query, req.OperationName, req.Variables)
+
+	kind := "query"
+	if call.mutation {
+		kind = "mutation"
+	}
+	trace.SpanFromContext(ctx.Request.Context()).SetAttributes(attribute.String("graphql.operation.type", kind),
+		attribute.String("graphql.operation.name", call.operation), attribute.Int("graphql.operation.complexity", call.complexity))
+
+	logger := requestLogger(ctx, h.users.logger)
+	switch {
+	case call.rejection != nil:
+		logger.Warn("rejected GraphQL operation: ", call.rejection.Message)
+		if call.status == http.StatusMethodNotAllowed {
+			ctx.Header("Allow", http.MethodPost)
+		}
+		h.writeErrors(ctx, call.status, call.rejection)
+	case res.Data == nil:
+		// the query could not be parsed, does not match the schema or is nested too deep
+		logger.Warn("rejected GraphQL operation: ", res.Errors[0].Message)
+		for _, err := range res.Errors {
+			code := CodeInvalidQuery
+			if err.Rule == "MaxDepthExceeded" {
+				code = CodeQueryTooComplex
+			}
+			err.Extensions = map[string]interface{}{"code": code}
+		}
+		h.writeErrors(ctx, http.StatusBadRequest, res.Errors...)
+	default:
+		h.addRequestID(ctx, res.Errors)
+		writeJSON(ctx, http.StatusOK, &GraphQLResponse{Data: res.Data, Errors: res.Errors})
+	}
+}
+
+// ServeGet executes a GraphQL query sent in the query string
+// @Summary GraphQL query
+// @Tags graphql
+// @Description execute a GraphQL query, mutations have to be sent with POST
+// @ID graphql-get
+// @Security APIKey
+// @Security Bearer
+// @Produce json
+// @Param query query string true "query"
+// @Param operationName query string false "operation to execute"
+// @Param variables query string false "variables as a json object"
+// @Success 200 {object} GraphQLResponse
+// @Failure 400,403,405 {object} GraphQLResponse
+// @Failure 401 {object} Problem
+// @Router /graphql [get]
+func (h *GraphQLHandler) ServeGet(ctx *gin.Context) {
+	h.Serve(ctx)
+}
+
+// decodeRequest reads the operation from the query string of GET requests and from the
+// json body of POST requests
+func (h *GraphQLHandler) decodeRequest(ctx *gin.Context) (*GraphQLRequest, bool) {
+	req := &GraphQLRequest{}
+	if ctx.Request.Method == http.MethodGet {
+		req.Query = ctx.Query("query")
+		req.OperationName = ctx.Query("operationName")
+		if variables := ctx.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				h.writeErrors(ctx, http.StatusBadRequest, graphQLError(CodeInvalidBody, "variables must be a json object: "+err.Error()))
+				return nil, false
+			}
+		}
+		return req, true
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type")); mediaType != "application/json" {
+		h.writeErrors(ctx, http.StatusUnsupportedMediaType, graphQLError(CodeUnsupportedMediaType, "the body must be application/json"))
+		return nil, false
+	}
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, config.GraphQLMaxRequestSize)
+	if err := json.NewDecoder(body).Decode(req); err != nil {
+		h.writeErrors(ctx, http.StatusBadRequest, graphQLError(CodeInvalidBody, err.Error()))
+		return nil, false
+	}
+	return req, true
+}
+
+// writeErrors answers a request that could not be executed
+func (h *GraphQLHandler) writeErrors(ctx *gin.Context, status int, errs ...*gqlerrors.QueryError) {
+	h.addRequestID(ctx, errs)
+	writeJSON(ctx, status, &GraphQLResponse{Errors: errs})
+}
+
+// addRequestID adds the request id to the errors, errors of resolvers without a code are
+// internal errors
+func (h *GraphQLHandler) addRequestID(ctx *gin.Context, errs []*gqlerrors.QueryError) {
+	id := RequestIDFrom(ctx)
+	for _, err := range errs {
+		if err.Extensions == nil {
+			err.Extensions = map[string]interface{}{"code": CodeInternalError}
+		}
+		err.Extensions["request_id"] = id
+	}
+}
+
+// graphQLError returns the error of a request with the code of the REST API
+func graphQLError(code ErrorCode, message string) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{Message: message, Extensions: map[string]interface{}{"code": code}}
+}
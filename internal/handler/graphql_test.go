@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+func newTestGraphQLRouter(repo *fakeUserRepository) *gin.Engine {
+	router := gin.New()
+	router.Use(MiddlewareRequestID(testLogger()))
+	NewGraphQLHandler(newTestUserHandler(repo, newFakeUserCache())).Routes(router, testGuard)
+	return router
+}
+
+// graphQLResponse is the response to an operation, or to a request that could not be executed
+type graphQLResponse struct {
+	Data   json.RawMessage         `json:"data"`
+	Errors []*gqlerrors.QueryError `json:"errors"`
+}
+
+// graphQLCode returns the code in the extensions of the error
+func graphQLCode(err *gqlerrors.QueryError) ErrorCode {
+	code, _ := err.Extensions["code"].(string)
+	return ErrorCode(code)
+}
+
+// postGraphQL sends the operation with POST and decodes the response
+func postGraphQL(t *testing.T, router http.Handler, principal string, query string, variables map[string]interface{}) (*httptest.ResponseRecorder, *graphQLResponse) {
+	t.Helper()
+	body, err := json.Marshal(&GraphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := do(t, router, http.MethodPost, config.GraphQLPath, string(body), principalHeader, principal)
+	return res, decodeGraphQL(t, res)
+}
+
+func decodeGraphQL(t *testing.T, res *httptest.ResponseRecorder) *graphQLResponse {
+	t.Helper()
+	var decoded graphQLResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("response %q is not a GraphQL response: %v", res.Body.String(), err)
+	}
+	return &decoded
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	repo := newFakeUserRepository(
+		&data.User{ID: "id-alice", Username: "alice", FirstName: "Alice", LastName: "Smith"},
+		&data.User{ID: "id-bob", Username: "bob", FirstName: "Bob", LastName: "Jones"},
+		&data.User{ID: "id-carol", Username: "carol", FirstName: "Carol", LastName: "White"},
+	)
+	router := newTestGraphQLRouter(repo)
+
+	res, body := postGraphQL(t, router, reader, `{ user(username: "alice") { id username firstName lastName } }`, nil)
+	if res.Code != http.StatusOK || body.Errors != nil || string(body.Data) != `{"user":{"id":"id-alice","username":"alice","firstName":"Alice","lastName":"Smith"}}` {
+		t.Fatalf("user = %d %s", res.Code, res.Body)
+	}
+
+	// the users connection pages with the cursor of the previous page
+	const users = `query ($after: String) { users(first: 2, after: $after) { nodes { username } pageInfo { hasNextPage endCursor } } }`
+	res, body = postGraphQL(t, router, reader, users, nil)
+	if res.Code != http.StatusOK || string(body.Data) != `{"users":{"nodes":[{"username":"alice"},{"username":"bob"}],"pageInfo":{"hasNextPage":true,"endCursor":"Ym9i"}}}` {
+		t.Fatalf("first page = %d %s", res.Code, res.Body)
+	}
+	res, body = postGraphQL(t, router, reader, users, map[string]interface{}{"after": "Ym9i"})
+	if res.Code != http.StatusOK || string(body.Data) != `{"users":{"nodes":[{"username":"carol"}],"pageInfo":{"hasNextPage":false,"endCursor":null}}}` {
+		t.Fatalf("second page = %d %s", res.Code, res.Body)
+	}
+	res, body = postGraphQL(t, router, reader, `{ users { nodes { username } } }`, nil)
+	if res.Code != http.StatusOK || string(body.Data) != `{"users":{"nodes":[{"username":"alice"},{"username":"bob"},{"username":"carol"}]}}` {
+		t.Fatalf("default page = %d %s", res.Code, res.Body)
+	}
+
+	// queries may be sent in the query string
+	query := url.Values{
+		"query":         {`query Q($name: String!) { user(username: $name) { firstName } } query R { __typename }`},
+		"operationName": {"Q"},
+		"variables":     {`{"name":"bob"}`},
+	}
+	res = do(t, router, http.MethodGet, config.GraphQLPath+"?"+query.Encode(), "", principalHeader, reader)
+	if body = decodeGraphQL(t, res); res.Code != http.StatusOK || string(body.Data) != `{"user":{"firstName":"Bob"}}` {
+		t.Fatalf("GET = %d %s", res.Code, res.Body)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	repo := newFakeUserRepository()
+	router := newTestGraphQLRouter(repo)
+
+	res, body := postGraphQL(t, router, writer, `mutation { addUser(username: "dave", firstName: "Dave", lastName: "Jones") { id username } }`, nil)
+	if res.Code != http.StatusOK || body.Errors != nil || string(body.Data) != `{"addUser":{"id":"id-dave","username":"dave"}}` {
+		t.Fatalf("addUser = %d %s", res.Code, res.Body)
+	}
+	// arguments that are not given stay unchanged
+	res, body = postGraphQL(t, router, writer, `mutation { updateUser(username: "dave", firstName: "David") { firstName lastName } }`, nil)
+	if res.Code != http.StatusOK || string(body.Data) != `{"updateUser":{"firstName":"David","lastName":"Jones"}}` {
+		t.Fatalf("updateUser = %d %s", res.Code, res.Body)
+	}
+	if user := repo.user("dave"); user.FirstName != "David" || user.LastName != "Jones" {
+		t.Fatalf("stored user = %+v", user)
+	}
+	res, body = postGraphQL(t, router, writer, `mutation { deleteUser(username: "dave") }`, nil)
+	if res.Code != http.StatusOK || string(body.Data) != `{"deleteUser":true}` || repo.user("dave") != nil {
+		t.Fatalf("deleteUser = %d %s", res.Code, res.Body)
+	}
+}
+
+func TestGraphQLFieldErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		down      bool
+		query     string
+		data      string
+		code      ErrorCode
+		message   string
+	}{
+		{"unknown user", reader, false, `{ user(username: "nobody") { id } }`, `{"user":null}`, CodeUserNotFound, ErrUserNotFound},
+		{"taken username", writer, false, `mutation { addUser(username: "alice", firstName: "A", lastName: "S") { id } }`, `{"addUser":null}`, CodeUserExists, ErrUserExists},
+		{"update of an unknown user", writer, false, `mutation { updateUser(username: "nobody", firstName: "N") { id } }`, `{"updateUser":null}`, CodeUserNotFound, ErrUserNotFound},
+		{"delete of an unknown user", writer, false, `mutation { deleteUser(username: "nobody") }`, `{"deleteUser":null}`, CodeUserNotFound, ErrUserNotFound},
+		{"storage down", reader, true, `{ user(username: "alice") { id } }`, `{"user":null}`, CodeDatabaseError, "unable to retrieve user from database, please try again later"},
+		{"storage down while listing", reader, true, `{ users { nodes { id } } }`, `null`, CodeDatabaseError, "error while listing users, please try again later"},
+		{"read without a role", data.ScopeRead, false, `{ user(username: "alice") { id } }`, `{"user":null}`, CodeForbidden, "not allowed to read this user"},
+		{"update by a reader", data.ScopeRead + "," + data.ScopeWrite + ";reader", false, `mutation { updateUser(username: "alice", firstName: "A") { id } }`, `{"updateUser":null}`, CodeForbidden, "not allowed to update this user"},
+		{"page too large", reader, false, `{ users(first: 101) { nodes { id } } }`, `null`, CodeValidationFailed, "first must be between 1 and 100"},
+		{"empty page", reader, false, `{ users(first: 0) { nodes { id } } }`, `null`, CodeValidationFailed, "first must be between 1 and 100"},
+		{"invalid cursor", reader, false, `{ users(after: "!!") { nodes { id } } }`, `null`, CodeValidationFailed, "after is not a cursor of the users connection"},
+	}
+	for _, tt := range tests {
+		repo := newFakeUserRepository(&data.User{ID: "id-alice", Username: "alice", FirstName: "Alice", LastName: "Smith"})
+		repo.down = tt.down
+		res, body := postGraphQL(t, newTestGraphQLRouter(repo), tt.principal, tt.query, nil)
+		if res.Code != http.StatusOK || string(body.Data) != tt.data || len(body.Errors) != 1 {
+			t.Errorf("%s: %d %s, want 200 with %s and one error", tt.name, res.Code, res.Body, tt.data)
+			continue
+		}
+		err := body.Errors[0]
+		if graphQLCode(err) != tt.code || err.Message != tt.message || len(err.Path) != 1 {
+			t.Errorf("%s: error = %+v, want %s %q", tt.name, err, tt.code, tt.message)
+		}
+		if id, _ := err.Extensions["request_id"].(string); id == "" || id != res.Header().Get(config.RequestIDHeader) {
+			t.Errorf("%s: request_id = %v", tt.name, err.Extensions["request_id"])
+		}
+	}
+}
+
+func TestGraphQLValidationErrors(t *testing.T) {
+	router := newTestGraphQLRouter(newFakeUserRepository())
+	tests := map[string][]ValidationError{
+		`mutation { addUser(username: "dave", firstName: "", lastName: "") { id } }`: {
+			{Field: "firstName", Rule: "required"}, {Field: "lastName", Rule: "required"},
+		},
+		`mutation { deleteUser(username: "") }`: {{Field: "username", Rule: "required"}},
+	}
+	for query, want := range tests {
+		res, body := postGraphQL(t, router, writer, query, nil)
+		if res.Code != http.StatusOK || len(body.Errors) != 1 || graphQLCode(body.Errors[0]) != CodeValidationFailed {
+			t.Errorf("%q = %d %s, want validation_failed", query, res.Code, res.Body)
+			continue
+		}
+		// the fields are named after the arguments
+		encoded, _ := json.Marshal(body.Errors[0].Extensions["errors"])
+		var got []ValidationError
+		if err := json.Unmarshal(encoded, &got); err != nil || len(got) != len(want) {
+			t.Errorf("%q: errors = %s", query, encoded)
+			continue
+		}
+		for i := range want {
+			if got[i].Field != want[i].Field || got[i].Rule != want[i].Rule || got[i].Message == "" {
+				t.Errorf("%q: error %d = %+v, want %+v", query, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestGraphQLRequestErrors(t *testing.T) {
+	users := make([]*data.User, 0, 100)
+	for i := 0; i < 100; i++ {
+		users = append(users, &data.User{Username: fmt.Sprintf("user%03d", i), FirstName: "First", LastName: "Last"})
+	}
+	repo := newFakeUserRepository(users...)
+	router := newTestGraphQLRouter(repo)
+	mutation := `{"query":"mutation { deleteUser(username: \"user000\") }"}`
+	// three pages of 100 users resolve more fields than the limit
+	page := `users(first: 100) { nodes { id username firstName lastName } }`
+	tooComplex := `{"query":"{ a: ` + page + ` b: ` + page + ` c: ` + page + ` }"}`
+	tooDeep := `{"query":"{ __schema { types { fields { type { ofType { ofType { ofType { ofType { name } } } } } } } } }"}`
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers []string
+		status  int
+		code    ErrorCode
+	}{
+		{"syntax error", http.MethodPost, config.GraphQLPath, `{"query":"{ user("}`, nil, http.StatusBadRequest, CodeInvalidQuery},
+		{"unknown field", http.MethodPost, config.GraphQLPath, `{"query":"{ nope }"}`, nil, http.StatusBadRequest, CodeInvalidQuery},
+		{"too complex", http.MethodPost, config.GraphQLPath, tooComplex, nil, http.StatusBadRequest, CodeQueryTooComplex},
+		{"too deep", http.MethodPost, config.GraphQLPath, tooDeep, nil, http.StatusBadRequest, CodeQueryTooComplex},
+		{"mutation without the write scope", http.MethodPost, config.GraphQLPath, mutation, []string{principalHeader, reader}, http.StatusForbidden, CodeForbidden},
+		{"mutation over GET", http.MethodGet, config.GraphQLPath + "?" + url.Values{"query": {"mutation { deleteUser(username: \"alice\") }"}}.Encode(), "", []string{principalHeader, writer}, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"not json", http.MethodPost, config.GraphQLPath, `query={ __typename }`, []string{"Content-Type", "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"invalid body", http.MethodPost, config.GraphQLPath, `{"query":1}`, nil, http.StatusBadRequest, CodeInvalidBody},
+		{"invalid variables", http.MethodGet, config.GraphQLPath + "?query=%7B__typename%7D&variables=%5B%5D", "", nil, http.StatusBadRequest, CodeInvalidBody},
+	}
+	for i, tt := range tests {
+		requestID := fmt.Sprintf("req-%d", i)
+		headers := append([]string{principalHeader, writer, config.RequestIDHeader, requestID}, tt.headers...)
+		res := do(t, router, tt.method, tt.path, tt.body, headers...)
+		body := decodeGraphQL(t, res)
+		if res.Code != tt.status || body.Data != nil || len(body.Errors) != 1 {
+			t.Errorf("%s: %d %s, want %d with one error", tt.name, res.Code, res.Body, tt.status)
+			continue
+		}
+		err := body.Errors[0]
+		if graphQLCode(err) != tt.code || err.Extensions["request_id"] != requestID {
+			t.Errorf("%s: error = %+v, want %s", tt.name, err, tt.code)
+		}
+		if tt.code == CodeMethodNotAllowed && res.Header().Get("Allow") != http.MethodPost {
+			t.Errorf("%s: Allow = %q, want POST", tt.name, res.Header().Get("Allow"))
+		}
+	}
+
+	// rejected mutations are not executed
+	if repo.user("user000") == nil {
+		t.Fatalf("a rejected mutation deleted the user")
+	}
+
+	// errors of the query have the location of the faulty token
+	res := do(t, router, http.MethodPost, config.GraphQLPath, `{"query":"{\n  user("}`, principalHeader, reader)
+	if body := decodeGraphQL(t, res); !reflect.DeepEqual(body.Errors[0].Locations, []gqlerrors.Location{{Line: 2, Column: 8}}) {
+		t.Fatalf("locations = %+v", body.Errors[0].Locations)
+	}
+	// the guard answers callers without credentials with a problem
+	res = do(t, router, http.MethodPost, config.GraphQLPath, `{"query":"{ __typename }"}`)
+	if res.Code != http.StatusUnauthorized || problemCode(t, res) != CodeUnauthorized {
+		t.Fatalf("without credentials = %d %s", res.Code, res.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	uuid "github.com/satori/go.uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"time"
+)
+
+// Interceptor runs around every unary and streaming call, it calls next to continue with the call
+type Interceptor func(ctx context.Context, method string, next func(ctx context.Context) error) error
+
+// ServerOptions chains the interceptors, the first one runs first
+func ServerOptions(interceptors ...Interceptor) []grpc.ServerOption {
+	unary := make([]grpc.UnaryServerInterceptor, 0, len(interceptors))
+	streams := make([]grpc.StreamServerInterceptor, 0, len(interceptors))
+	for _, i := range interceptors {
+		unary = append(unary, i.unary)
+		streams = append(streams, i.stream)
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(streams...)}
+}
+
+func (i Interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	var res interface{}
+	err := i(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		res, err = handler(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+func (i Interceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	return i(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	})
+}
+
+// serverStream is a stream with the context of the interceptors
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// incomingHeader returns the metadata sent by the client as headers, gRPC calls send the
+// headers of the REST API as metadata
+func incomingHeader(ctx context.Context) http.Header {
+	header := http.Header{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			header.Add(key, v)
+		}
+	}
+	return header
+}
+
+// InterceptRequestID is MiddlewareRequestID for gRPC calls, the id is read from and echoed
+// in the x-request-id metadata
+func InterceptRequestID(l logging.Logger) Interceptor {
+	return func(ctx context.Context, method string, next func(ctx context.Context) error) error {
+		id := incomingHeader(ctx).Get(config.RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewV4().String()
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(config.RequestIDHeader, id))
+		return next(logging.NewContext(ctx, l.GetLoggerWithField(requestIDKey, id)))
+	}
+}
+
+// ServerTracing records a server span per call with otelgrpc, continuing the trace of the
+// traceparent metadata when the client sent one
+func ServerTracing(tp trace.TracerProvider) grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithTracerProvider(tp),
+		otelgrpc.WithPropagators(propagation.TraceContext{})))
+}
+
+// InterceptTracing adds the request id to the span of the call and the trace id to its logger,
+// it must run after InterceptRequestID on a server with ServerTracing
+func InterceptTracing(l logging.Logger) Interceptor {
+	return func(ctx context.Context, method string, next func(ctx context.Context) error) error {
+		span := trace.SpanFromContext(ctx)
+		if !span.SpanContext().IsValid() {
+			return next(ctx)
+		}
+		span.SetAttributes(attribute.String("request_id", incomingHeader(ctx).Get(config.RequestIDHeader)))
+		logger := logging.FromContext(ctx, l)
+		return next(logging.NewContext(ctx, logger.GetLoggerWithField("trace_id", span.SpanContext().TraceID().String())))
+	}
+}
+
+// InterceptAccessLog logs every call with its status and latency, and the errors of failed calls
+func InterceptAccessLog(l logging.Logger) Interceptor {
+	return func(ctx context.Context, method string, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+
+		logger := logging.FromContext(ctx, l)
+		code := statusOf(err).Code()
+		if code == codes.Unknown {
+			logger.Error("error while serving ", method, "error", err)
+		}
+		logger.Info("[GRPC] ", method, " | ", code, " | ", time.Since(start))
+		return err
+	}
+}
+
+// statusOf returns the status of the error of a call, errors of the context are reported
+// as the status of the canceled or timed out call
+func statusOf(err error) *status.Status {
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+	if s := status.FromContextError(err); s.Code() != codes.Unknown {
+		return s
+	}
+	return status.New(codes.Unknown, "unknown error")
+}
+
+// ReportReadiness sets the status of the services on the health server to the readiness of
+// the health handler every interval, until ctx is done
+func ReportReadiness(ctx context.Context, h *HealthHandler, s *health.Server, interval time.Duration, services ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		serving := healthpb.HealthCheckResponse_SERVING
+		if h.Readiness(ctx).Status != StateReady {
+			serving = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		for _, service := range services {
+			s.SetServingStatus(service, serving)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/internal/validation"
+	"sceyt_task/pkg/logging"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func testLogger() logging.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return logging.Logger{Entry: logrus.NewEntry(l)}
+}
+
+var errStorage = errors.New("storage is down")
+
+// fakeUserRepository keeps users in memory, every call fails with errStorage while down is set
+type fakeUserRepository struct {
+	mu    sync.Mutex
+	users map[string]*data.User
+	down  bool
+}
+
+func newFakeUserRepository(users ...*data.User) *fakeUserRepository {
+	r := &fakeUserRepository{users: map[string]*data.User{}}
+	for _, u := range users {
+		r.users[u.Username] = u
+	}
+	return r
+}
+
+func (r *fakeUserRepository) user(username string) *data.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.users[username]
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *data.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errStorage
+	}
+	if _, ok := r.users[user.Username]; ok {
+		return repository.ErrUserExists
+	}
+	user.ID = "id-" + user.Username
+	copied := *user
+	r.users[user.Username] = &copied
+	return nil
+}
+
+func (r *fakeUserRepository) Update(ctx context.Context, user *data.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errStorage
+	}
+	current, ok := r.users[user.Username]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	current.FirstName, current.LastName = user.FirstName, user.LastName
+	return nil
+}
+
+func (r *fakeUserRepository) Patch(ctx context.Context, userName string, changes data.UserChanges) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errStorage
+	}
+	current, ok := r.users[userName]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	if changes.FirstName != nil {
+		current.FirstName = *changes.FirstName
+	}
+	if changes.LastName != nil {
+		current.LastName = *changes.LastName
+	}
+	return nil
+}
+
+func (r *fakeUserRepository) Delete(ctx context.Context, userName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errStorage
+	}
+	if _, ok := r.users[userName]; !ok {
+		return repository.ErrUserNotFound
+	}
+	delete(r.users, userName)
+	return nil
+}
+
+func (r *fakeUserRepository) GetUserByUserName(ctx context.Context, userName string) (*data.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return nil, errStorage
+	}
+	user, ok := r.users[userName]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// List pages through the users sorted by username, the page state is the last username of the page
+func (r *fakeUserRepository) List(ctx context.Context, limit int, pageState []byte) ([]*data.User, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return nil, nil, errStorage
+	}
+	var names []string
+	for name := range r.users {
+		if name > string(pageState) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var next []byte
+	if len(names) > limit {
+		names = names[:limit]
+		next = []byte(names[limit-1])
+	}
+	users := make([]*data.User, len(names))
+	for i, name := range names {
+		copied := *r.users[name]
+		users[i] = &copied
+	}
+	return users, next, nil
+}
+
+var _ repository.UserRepository = (*fakeUserRepository)(nil)
+
+// fakeUserCache keeps users in memory, Invalidate fails with failInvalidate
+type fakeUserCache struct {
+	mu             sync.Mutex
+	users          map[string]*data.User
+	invalidated    []string
+	failInvalidate error
+}
+
+func newFakeUserCache() *fakeUserCache {
+	return &fakeUserCache{users: map[string]*data.User{}}
+}
+
+func (c *fakeUserCache) Set(ctx context.Context, key string, value *data.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[key] = value
+	return nil
+}
+
+func (c *fakeUserCache) Get(ctx context.Context, key string) (*data.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.users[key], nil
+}
+
+func (c *fakeUserCache) Del(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.users, key)
+	return nil
+}
+
+func (c *fakeUserCache) Version(ctx context.Context, key string) (int64, error) {
+	return 0, nil
+}
+
+func (c *fakeUserCache) SetIfVersion(ctx context.Context, key string, value *data.User, version int64) error {
+	return c.Set(ctx, key, value)
+}
+
+func (c *fakeUserCache) Invalidate(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failInvalidate != nil {
+		return c.failInvalidate
+	}
+	delete(c.users, key)
+	c.invalidated = append(c.invalidated, key)
+	return nil
+}
+
+func (c *fakeUserCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (c *fakeUserCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+var _ cache.UserCache = (*fakeUserCache)(nil)
+
+// testRBAC lets readers read every user and writers change every user
+var testRBAC = &config.RBACConfiguration{
+	Enabled:     true,
+	APIKeyRoles: []string{"reader"},
+	Roles: map[string][]string{
+		"reader": {"read:any"},
+		"writer": {"*:any"},
+	},
+}
+
+func newTestUserHandler(repo repository.UserRepository, c cache.UserCache) *UserHandler {
+	return NewUserHandler(testLogger(), validation.NewValidation(), repo, c, NewPolicy(testLogger(), testRBAC))
+}
+
+// principalHeader carries the scopes and roles of the caller to testGuard, as
+// "<scope>,<scope>;<role>,<role>"
+const principalHeader = "X-Test-Principal"
+
+// testGuard authenticates the caller from principalHeader and checks the scope of the route
+func testGuard(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader(principalHeader)
+		if header == "" {
+			abortWithProblem(ctx, CodeUnauthorized, "missing credentials")
+			return
+		}
+		parts := strings.SplitN(header+";", ";", 3)
+		principal := &Principal{ID: "tester", Method: MethodBearer, Scopes: strings.Split(parts[0], ",")}
+		if parts[1] != "" {
+			principal.Roles = strings.Split(parts[1], ",")
+		}
+		if !principal.HasScope(scope) {
+			abortWithProblem(ctx, CodeForbidden, "missing scope "+scope)
+			return
+		}
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// writer is the principalHeader of a caller allowed to change every user
+const writer = data.ScopeRead + "," + data.ScopeWrite + ";writer"
+
+// reader is the principalHeader of a caller allowed to read every user
+const reader = data.ScopeRead + ";reader"
+
+// do serves the request and returns the recorded response
+func do(t *testing.T, router http.Handler, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+// problemCode returns the code of a problem+json response
+func problemCode(t *testing.T, res *httptest.ResponseRecorder) ErrorCode {
+	t.Helper()
+	var problem Problem
+	if err := json.Unmarshal(res.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("response %q is not a problem: %v", res.Body.String(), err)
+	}
+	return problem.Code
+}
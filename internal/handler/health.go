@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// states of the instance reported by the readiness endpoint
+const (
+	StateStarting     = "starting"
+	StateReady        = "ready"
+	StateNotReady     = "not_ready"
+	StateShuttingDown = "shutting_down"
+)
+
+// status of a dependency
+const (
+	DependencyUp   = "up"
+	DependencyDown = "down"
+)
+
+// Dependency is checked by the readiness endpoint. The instance is not ready while a
+// critical dependency is down, the others are only reported.
+type Dependency struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// DependencyStatus is the result of the check of a dependency
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthResponse is the body of the liveness and readiness endpoints
+type HealthResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// HealthHandler answers the liveness and readiness probes of the orchestrator
+type HealthHandler struct {
+	logger       logging.Logger
+	timeout      time.Duration
+	dependencies []Dependency
+	state        atomic.Value
+}
+
+// NewHealthHandler returns a new HealthHandler instance, it reports the instance as
+// starting until MarkReady is called. Every dependency is checked within timeout.
+func NewHealthHandler(l logging.Logger, timeout time.Duration, dependencies ...Dependency) *HealthHandler {
+	h := &HealthHandler{logger: l, timeout: timeout, dependencies: dependencies}
+	h.state.Store(StateStarting)
+	return h
+}
+
+// MarkReady reports the instance as ready once its dependencies are up
+func (h *HealthHandler) MarkReady() {
+	h.state.Store(StateReady)
+	h.logger.Info("instance is ready")
+}
+
+// MarkShuttingDown reports the instance as not ready until it stops
+func (h *HealthHandler) MarkShuttingDown() {
+	h.state.Store(StateShuttingDown)
+}
+
+func (h *HealthHandler) Routes(engine *gin.Engine) {
+	engine.GET(config.LivenessPath, h.Live)
+	engine.GET(config.ReadinessPath, h.Ready)
+}
+
+// Live reports that the process is alive
+// @Summary liveness
+// @Tags health
+// @Description answers as long as the process serves requests
+// @ID health-live
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Router /healthz [get]
+func (h *HealthHandler) Live(ctx *gin.Context) {
+	writeJSON(ctx, http.StatusOK, &HealthResponse{Status: "ok"})
+}
+
+// Ready reports whether the instance can serve requests
+// @Summary readiness
+// @Tags health
+// @Description checks cassandra and redis, the instance is not ready while starting, shutting down
+// @Description or while a critical dependency is down
+// @ID health-ready
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
+// @Router /readyz [get]
+func (h *HealthHandler) Ready(ctx *gin.Context) {
+	response := h.Readiness(ctx.Request.Context())
+	status := http.StatusOK
+	if response.Status != StateReady {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(ctx, status, response)
+}
+
+// Readiness checks the dependencies, the status is ready unless the instance is starting,
+// shutting down or a critical dependency is down
+func (h *HealthHandler) Readiness(ctx context.Context) *HealthResponse {
+	response := &HealthResponse{Status: h.state.Load().(string), Dependencies: h.check(ctx)}
+	for name, dependency := range response.Dependencies {
+		if dependency.Critical && dependency.Status == DependencyDown && response.Status == StateReady {
+			logging.FromContext(ctx, h.logger).Warn("not ready, ", name, " is down: ", dependency.Error)
+			response.Status = StateNotReady
+		}
+	}
+	return response
+}
+
+// check runs the checks of every dependency concurrently
+func (h *HealthHandler) check(ctx context.Context) map[string]DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[string]DependencyStatus, len(h.dependencies))
+	for _, dependency := range h.dependencies {
+		wg.Add(1)
+		go func(d Dependency) {
+			defer wg.Done()
+			status := checkDependency(ctx, d)
+			mu.Lock()
+			statuses[d.Name] = status
+			mu.Unlock()
+		}(dependency)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// checkDependency runs the check of the dependency, a check that does not return
+// before the deadline of ctx is reported as down
+func checkDependency(ctx context.Context, d Dependency) DependencyStatus {
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- d.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	status := DependencyStatus{
+		Status:    DependencyUp,
+		Critical:  d.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status, status.Error = DependencyDown, err.Error()
+	}
+	return status
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readiness(t *testing.T, h *HealthHandler) (int, HealthResponse) {
+	t.Helper()
+	router := gin.New()
+	h.Routes(router)
+	res := do(t, router, http.MethodGet, config.ReadinessPath, "")
+	var body HealthResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("readiness = %s: %v", res.Body, err)
+	}
+	return res.Code, body
+}
+
+func TestReadinessFollowsTheLifecycle(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	h := NewHealthHandler(testLogger(), time.Second, Dependency{Name: "cassandra", Critical: true, Check: up})
+
+	if code, body := readiness(t, h); code != http.StatusServiceUnavailable || body.Status != StateStarting {
+		t.Fatalf("readiness while starting = %d %+v", code, body)
+	}
+	h.MarkReady()
+	code, body := readiness(t, h)
+	if code != http.StatusOK || body.Status != StateReady || body.Dependencies["cassandra"].Status != DependencyUp {
+		t.Fatalf("readiness when ready = %d %+v", code, body)
+	}
+	h.MarkShuttingDown()
+	if code, body = readiness(t, h); code != http.StatusServiceUnavailable || body.Status != StateShuttingDown {
+		t.Fatalf("readiness while shutting down = %d %+v", code, body)
+	}
+
+	// liveness does not depend on the state or the dependencies
+	router := gin.New()
+	h.Routes(router)
+	if res := do(t, router, http.MethodGet, config.LivenessPath, ""); res.Code != http.StatusOK || strings.TrimSpace(res.Body.String()) != `{"status":"ok"}` {
+		t.Fatalf("liveness = %d %s", res.Code, res.Body)
+	}
+}
+
+func TestReadinessReportsTheDependencies(t *testing.T) {
+	down := errors.New("connection refused")
+	stuck := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name         string
+		dependencies []Dependency
+		status       string
+		code         int
+	}{
+		{"critical down", []Dependency{
+			{Name: "cassandra", Critical: true, Check: func(ctx context.Context) error { return down }},
+			{Name: "redis", Check: func(ctx context.Context) error { return nil }},
+		}, StateNotReady, http.StatusServiceUnavailable},
+		{"optional down", []Dependency{
+			{Name: "cassandra", Critical: true, Check: func(ctx context.Context) error { return nil }},
+			{Name: "redis", Check: func(ctx context.Context) error { return down }},
+		}, StateReady, http.StatusOK},
+		{"critical timed out", []Dependency{
+			{Name: "cassandra", Critical: true, Check: stuck},
+			{Name: "redis", Check: stuck},
+		}, StateNotReady, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		h := NewHealthHandler(testLogger(), 20*time.Millisecond, tt.dependencies...)
+		h.MarkReady()
+		start := time.Now()
+		code, body := readiness(t, h)
+		if code != tt.code || body.Status != tt.status || len(body.Dependencies) != 2 {
+			t.Errorf("%s: readiness = %d %+v, want %d %s", tt.name, code, body, tt.code, tt.status)
+		}
+		// the checks run concurrently within the timeout
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: readiness took %s", tt.name, elapsed)
+		}
+		for _, d := range tt.dependencies {
+			status := body.Dependencies[d.Name]
+			if status.Critical != d.Critical || status.LatencyMS < 0 || (status.Status == DependencyDown) != (status.Error != "") {
+				t.Errorf("%s: %s = %+v", tt.name, d.Name, status)
+			}
+		}
+	}
+}
+
+func TestCheckDependencyReturnsAtTheDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// a check that ignores ctx does not hold up the readiness probe
+	blocked := make(chan struct{})
+	defer close(blocked)
+	status := checkDependency(ctx, Dependency{Name: "redis", Check: func(context.Context) error {
+		<-blocked
+		return nil
+	}})
+	if status.Status != DependencyDown || status.Error != context.DeadlineExceeded.Error() || status.LatencyMS < 10 {
+		t.Fatalf("status = %+v, want down at the deadline", status)
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+)
+
+// maxIdempotencyKeyLength bounds the size of the keys stored in redis
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with the response and sent again on replay
+var replayedHeaders = []string{"Content-Type", "Content-Language", "Location"}
+
+// IdempotencyHandler replays the stored response for retries of mutating requests
+// sent with an Idempotency-Key header
+type IdempotencyHandler struct {
+	logger logging.Logger
+	store  *cache.IdempotencyStore
+}
+
+// NewIdempotencyHandler returns a new IdempotencyHandler instance
+func NewIdempotencyHandler(l logging.Logger, s *cache.IdempotencyStore) *IdempotencyHandler {
+	return &IdempotencyHandler{logger: l, store: s}
+}
+
+// responseRecorder keeps a copy of the response body written by the handler
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// MiddlewareIdempotency makes POST, PUT, PATCH and DELETE requests with an
+// Idempotency-Key header safe to retry. The request is fingerprinted by method,
+// path and body. Responses other than server errors are stored and replayed for
+// retries, a key sent again with a different request is rejected. Requests are
+// processed normally when redis is not available or the cache circuit breaker is open.
+func (h *IdempotencyHandler) MiddlewareIdempotency(ctx *gin.Context) {
+	key := ctx.GetHeader(config.IdempotencyKeyHeader)
+	switch ctx.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		key = ""
+	}
+	if key == "" {
+		ctx.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithProblem(ctx, CodeInvalidIdempotencyKey, "idempotency key must not be longer than 255 characters")
+		return
+	}
+
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return
+	}
+	ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(append([]byte(ctx.Request.Method+" "+ctx.Request.URL.Path+"\n"), body...))
+	fingerprint := hex.EncodeToString(sum[:])
+
+	// keys are scoped to the caller, so callers can not replay each other's responses
+	if principal := PrincipalFrom(ctx); principal != nil {
+		key = principal.String() + ":" + key
+	}
+	stored, err := h.store.Begin(ctx.Request.Context(), key, fingerprint)
+	switch {
+	case err == cache.ErrIdempotencyKeyReused:
+		abortWithProblem(ctx, CodeIdempotencyKeyReused, err.Error())
+		return
+	case err == cache.ErrRequestInProgress:
+		abortWithProblem(ctx, CodeRequestInProgress, err.Error())
+		return
+	case err == cache.ErrCircuitOpen:
+		ctx.Next()
+		return
+	case err != nil:
+		requestLogger(ctx, h.logger).Error("error while reserving idempotency key, processing the request without it", "error", err)
+		ctx.Next()
+		return
+	case stored != nil:
+		h.replay(ctx, stored)
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+	ctx.Writer = recorder
+	ctx.Next()
+
+	status := recorder.Status()
+	if status >= http.StatusInternalServerError {
+		// server errors may be transient, the retry is processed again
+		if err = h.store.Release(ctx.Request.Context(), key); err != nil {
+			requestLogger(ctx, h.logger).Error("error while releasing idempotency key", "error", err)
+		}
+		return
+	}
+	response := &cache.StoredResponse{Status: status, Header: http.Header{}, Body: recorder.body.Bytes()}
+	for _, name := range replayedHeaders {
+		if value := recorder.Header().Get(name); value != "" {
+			response.Header.Set(name, value)
+		}
+	}
+	if err = h.store.Complete(ctx.Request.Context(), key, fingerprint, response); err != nil {
+		requestLogger(ctx, h.logger).Error("error while storing idempotent response", "error", err)
+	}
+}
+
+// replay answers the request with the stored response
+func (h *IdempotencyHandler) replay(ctx *gin.Context, stored *cache.StoredResponse) {
+	for name, values := range stored.Header {
+		for _, value := range values {
+			ctx.Writer.Header().Add(name, value)
+		}
+	}
+	ctx.Header(config.IdempotentReplayedHeader, "true")
+	ctx.AbortWithStatus(stored.Status)
+	_, _ = ctx.Writer.Write(stored.Body)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"net/http"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestIdempotencyRouter serves the v2 routes behind MiddlewareIdempotency, the
+// store calls an in-memory redis through breaker
+func newTestIdempotencyRouter(t *testing.T, repo *fakeUserRepository, breaker *cache.CircuitBreaker) *gin.Engine {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	keys := cache.KeyScheme{Service: "user-server", Entity: config.CacheIdempotencyEntity, SchemaVersion: 1}
+	store := cache.NewIdempotencyStore(client, breaker, keys, time.Hour, time.Minute, testLogger())
+
+	router := gin.New()
+	newTestUserHandler(repo, newFakeUserCache()).RoutesV2(router, testGuard, NewIdempotencyHandler(testLogger(), store).MiddlewareIdempotency)
+	return router
+}
+
+func newTestBreaker(next cache.UserCache) *cache.CircuitBreaker {
+	return cache.NewCircuitBreaker(next, testLogger(), 3, time.Hour, 60, 10)
+}
+
+const createBob = `{"username":"bob","firstname":"Bob","lastname":"Jones"}`
+
+func TestIdempotentRetryIsReplayed(t *testing.T) {
+	repo := newFakeUserRepository()
+	router := newTestIdempotencyRouter(t, repo, newTestBreaker(newFakeUserCache()))
+
+	first := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", first.Code, first.Body)
+	}
+	retry := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if retry.Code != http.StatusCreated || retry.Header().Get(config.IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retry = %d %s, want the replayed 201", retry.Code, retry.Body)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != "/v2/users/bob" {
+		t.Errorf("retry = %s %q, want %s", retry.Body, retry.Header().Get("Location"), first.Body)
+	}
+
+	res := do(t, router, http.MethodPost, "/v2/users", `{"username":"carol","firstname":"C","lastname":"D"}`,
+		principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if res.Code != http.StatusUnprocessableEntity || problemCode(t, res) != CodeIdempotencyKeyReused {
+		t.Fatalf("other request with the key = %d %s, want 422", res.Code, res.Body)
+	}
+}
+
+func TestIdempotencyDoesNotStoreServerErrors(t *testing.T) {
+	repo := newFakeUserRepository()
+	router := newTestIdempotencyRouter(t, repo, newTestBreaker(newFakeUserCache()))
+
+	repo.down = true
+	if res := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1"); res.Code != http.StatusInternalServerError {
+		t.Fatalf("create = %d %s, want 500", res.Code, res.Body)
+	}
+	repo.down = false
+	res := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if res.Code != http.StatusCreated || res.Header().Get(config.IdempotentReplayedHeader) != "" {
+		t.Fatalf("retry = %d %s, want it processed again", res.Code, res.Body)
+	}
+}
+
+func TestIdempotencyIsSkippedWhileTheBreakerIsOpen(t *testing.T) {
+	userCache := newFakeUserCache()
+	userCache.failInvalidate = errors.New("redis is down")
+	breaker := newTestBreaker(userCache)
+	// a failed invalidation opens the breaker
+	_ = breaker.Invalidate(context.Background(), "alice")
+	if state := breaker.Status().State; state != cache.StateOpen {
+		t.Fatalf("breaker is %s, want open", state)
+	}
+
+	repo := newFakeUserRepository()
+	router := newTestIdempotencyRouter(t, repo, breaker)
+	res := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want it served without idempotency", res.Code, res.Body)
+	}
+	res = do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, "k1")
+	if res.Code != http.StatusConflict || res.Header().Get(config.IdempotentReplayedHeader) != "" {
+		t.Fatalf("retry = %d %s, want it processed again", res.Code, res.Body)
+	}
+	if repo.user("bob") == nil {
+		t.Fatal("bob was not created")
+	}
+}
+
+func TestIdempotencyKeyIsValidated(t *testing.T) {
+	router := newTestIdempotencyRouter(t, newFakeUserRepository(), newTestBreaker(newFakeUserCache()))
+	res := do(t, router, http.MethodPost, "/v2/users", createBob, principalHeader, writer, config.IdempotencyKeyHeader, strings.Repeat("k", 256))
+	if res.Code != http.StatusBadRequest || problemCode(t, res) != CodeInvalidIdempotencyKey {
+		t.Fatalf("create = %d %s, want 400", res.Code, res.Body)
+	}
+}
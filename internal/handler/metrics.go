@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute is the route label of requests that match no route, the path is not
+// used so clients can not create a series per path
+const unmatchedRoute = "unmatched"
+
+// RequestMetrics counts the requests and measures their latency per route and status
+type RequestMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewRequestMetrics returns a new RequestMetrics instance registered in r
+func NewRequestMetrics(r prometheus.Registerer) *RequestMetrics {
+	m := &RequestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	r.MustRegister(m.requests, m.duration)
+	return m
+}
+
+// MiddlewareMetrics records the request once it was answered
+func (m *RequestMetrics) MiddlewareMetrics(ctx *gin.Context) {
+	start := time.Now()
+	ctx.Next()
+
+	route := ctx.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+	status := strconv.Itoa(ctx.Writer.Status())
+	m.requests.WithLabelValues(ctx.Request.Method, route, status).Inc()
+	m.duration.WithLabelValues(ctx.Request.Method, route, status).Observe(time.Since(start).Seconds())
+}
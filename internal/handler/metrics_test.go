@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scrape serves the metrics of the registry like the metrics listener and parses the text format
+func scrape(t *testing.T, registry *prometheus.Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(res, req)
+	if contentType := res.Header().Get("Content-Type"); expfmt.ResponseFormat(res.Header()) != expfmt.FmtText {
+		t.Fatalf("Content-Type = %q, want the text format", contentType)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(res.Body)
+	if err != nil {
+		t.Fatalf("the metrics can not be parsed: %v", err)
+	}
+	return families
+}
+
+// series returns the metric of the family with the labels
+func series(family *dto.MetricFamily, labels map[string]string) *dto.Metric {
+	if family == nil {
+		return nil
+	}
+	for _, m := range family.Metric {
+		matched := len(m.Label) == len(labels)
+		for _, pair := range m.Label {
+			matched = matched && labels[pair.GetName()] == pair.GetValue()
+		}
+		if matched {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestRequestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	router := gin.New()
+	router.Use(NewRequestMetrics(registry).MiddlewareMetrics)
+	router.GET("/v2/users/:username", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	for _, path := range []string{"/v2/users/alice", "/v2/users/bob", "/nope", "/etc/passwd"} {
+		_ = do(t, router, http.MethodGet, path, "")
+	}
+	families := scrape(t, registry)
+
+	requests := families["http_requests_total"]
+	if requests.GetType() != dto.MetricType_COUNTER || requests.GetHelp() != "Number of HTTP requests." {
+		t.Fatalf("http_requests_total = %v", requests)
+	}
+	// the route and not the path is the label, paths matching no route share one series
+	matched := series(requests, map[string]string{"method": "GET", "route": "/v2/users/:username", "status": "200"})
+	unmatched := series(requests, map[string]string{"method": "GET", "route": unmatchedRoute, "status": "404"})
+	if matched.GetCounter().GetValue() != 2 || unmatched.GetCounter().GetValue() != 2 || len(requests.Metric) != 2 {
+		t.Fatalf("http_requests_total = %v", requests.Metric)
+	}
+
+	duration := series(families["http_request_duration_seconds"], map[string]string{"method": "GET", "route": "/v2/users/:username", "status": "200"})
+	histogram := duration.GetHistogram()
+	if histogram.GetSampleCount() != 2 || histogram.GetSampleSum() <= 0 || len(histogram.Bucket) != len(prometheus.DefBuckets)+1 {
+		t.Fatalf("http_request_duration_seconds = %v", duration)
+	}
+	// buckets are cumulative, the last one is +Inf and counts every observation
+	previous := uint64(0)
+	for i, bucket := range histogram.Bucket[:len(prometheus.DefBuckets)] {
+		if bucket.GetUpperBound() != prometheus.DefBuckets[i] || bucket.GetCumulativeCount() < previous {
+			t.Fatalf("bucket %d = %v after %d", i, bucket, previous)
+		}
+		previous = bucket.GetCumulativeCount()
+	}
+	if inf := histogram.Bucket[len(prometheus.DefBuckets)]; !math.IsInf(inf.GetUpperBound(), 1) || inf.GetCumulativeCount() != 2 {
+		t.Errorf("last bucket = %v, want +Inf with every request", inf)
+	}
+}
+
+func TestRequestMetricsBucketsAreCumulative(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewRequestMetrics(registry)
+	for _, v := range []float64{0.001, 0.02, 0.02, 0.7, 30} {
+		m.duration.WithLabelValues("GET", "/", "200").Observe(v)
+	}
+	histogram := series(scrape(t, registry)["http_request_duration_seconds"],
+		map[string]string{"method": "GET", "route": "/", "status": "200"}).GetHistogram()
+
+	want := map[float64]uint64{.005: 1, .01: 1, .025: 3, .05: 3, .1: 3, .25: 3, .5: 3, 1: 4, 2.5: 4, 5: 4, 10: 4, math.Inf(1): 5}
+	for _, bucket := range histogram.Bucket {
+		if bucket.GetCumulativeCount() != want[bucket.GetUpperBound()] {
+			t.Errorf("bucket le=%g = %d, want %d", bucket.GetUpperBound(), bucket.GetCumulativeCount(), want[bucket.GetUpperBound()])
+		}
+	}
+	if histogram.GetSampleCount() != 5 || math.Abs(histogram.GetSampleSum()-30.741) > 1e-9 {
+		t.Errorf("count = %d, sum = %g", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestRequestMetricsEscapeLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewRequestMetrics(registry)
+	route := "/a\"b\\c\nd"
+	m.requests.WithLabelValues("GET", route, "200").Inc()
+
+	if got := series(scrape(t, registry)["http_requests_total"], map[string]string{"method": "GET", "route": route, "status": "200"}); got == nil {
+		t.Fatal("the label value did not survive the text format")
+	}
+}
@@ -3,39 +3,38 @@ package handler
 import (
 	"context"
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
-	"net/http"
-	"sceyt_task/internal/data"
-	"strings"
 )
 
-// MiddlewareValidateUser validates the user in the request
-func (u *UserHandler) MiddlewareValidateUser(ctx *gin.Context) {
-	ctx.Set("Content-Type", "application/json")
+// MiddlewareValidateRequest decodes and validates the request body into the
+// request returned by newRequest and stores it in the context under RequestKey
+func (u *UserHandler) MiddlewareValidateRequest(newRequest func() interface{}) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestLogger(ctx, u.logger).Debug("request json received")
 
-	u.logger.Debug("user json received")
+		req := newRequest()
+		if !u.bindRequest(ctx, req) {
+			return
+		}
 
-	user := &data.User{}
-	err := ctx.ShouldBindBodyWith(user, binding.JSON)
-	if err != nil {
-		u.logger.Error("deserialization of user json failed", "error", err)
-		ctx.AbortWithStatus(http.StatusBadRequest)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: err.Error()}, ctx.Writer)
-		return
-	}
-	// validate the user
-	errs := u.validator.Validate(user)
-	if len(errs) != 0 {
-		u.logger.Error("validation of user json failed", "error", errs)
-		ctx.AbortWithStatus(http.StatusBadRequest)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: strings.Join(errs.Errors(), ",")}, ctx.Writer)
-		return
-	}
+		// add the request to the context
+		ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), RequestKey{}, req))
 
-	// add the user to the context
-	ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), UserKey{}, *user))
-
-	// call the next handler
-	ctx.Next()
+		// call the next handler
+		ctx.Next()
+	}
+}
 
+// bindRequest strictly decodes and validates the request body
+func (u *UserHandler) bindRequest(ctx *gin.Context, req interface{}) bool {
+	if err := decodeStrict(ctx.Request.Body, req); err != nil {
+		requestLogger(ctx, u.logger).Error("deserialization of request json failed", "error", err)
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return false
+	}
+	if errs := u.validator.Validate(req); len(errs) != 0 {
+		requestLogger(ctx, u.logger).Error("validation of request json failed", "error", errs)
+		abortWithValidationErrors(ctx, u.validator, errs)
+		return false
+	}
+	return true
 }
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/validation"
+	"strings"
+)
+
+// ErrorCode is the stable machine-readable identifier of a problem, clients
+// should rely on it rather than on the title or the detail
+type ErrorCode string
+
+const (
+	CodeInvalidBody           ErrorCode = "invalid_body"
+	CodeValidationFailed      ErrorCode = "validation_failed"
+	CodeInvalidPatch          ErrorCode = "invalid_patch"
+	CodeUnsupportedMediaType  ErrorCode = "unsupported_media_type"
+	CodeInvalidIdempotencyKey ErrorCode = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused  ErrorCode = "idempotency_key_reused"
+	CodeRequestInProgress     ErrorCode = "request_in_progress"
+	CodeUserNotFound          ErrorCode = "user_not_found"
+	CodeUserExists            ErrorCode = "user_exists"
+	CodeEntryNotCached        ErrorCode = "entry_not_cached"
+	CodeAPIKeyNotFound        ErrorCode = "api_key_not_found"
+	CodeAPIKeyRevoked         ErrorCode = "api_key_revoked"
+	CodeUnauthorized          ErrorCode = "unauthorized"
+	CodeForbidden             ErrorCode = "forbidden"
+	CodeRateLimited           ErrorCode = "rate_limited"
+	CodeRouteNotFound         ErrorCode = "route_not_found"
+	CodeMethodNotAllowed      ErrorCode = "method_not_allowed"
+	CodeDatabaseError         ErrorCode = "database_error"
+	CodeCacheError            ErrorCode = "cache_error"
+	CodeInternalError         ErrorCode = "internal_error"
+	CodeInvalidQuery          ErrorCode = "invalid_query"
+	CodeQueryTooComplex       ErrorCode = "query_too_complex"
+)
+
+type problemType struct {
+	status int
+	title  string
+}
+
+var problemTypes = map[ErrorCode]problemType{
+	CodeInvalidBody:           {http.StatusBadRequest, "Request body is invalid"},
+	CodeValidationFailed:      {http.StatusBadRequest, "Validation failed"},
+	CodeInvalidPatch:          {http.StatusBadRequest, "Patch can not be applied"},
+	CodeUnsupportedMediaType:  {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	CodeInvalidIdempotencyKey: {http.StatusBadRequest, "Idempotency key is invalid"},
+	CodeIdempotencyKeyReused:  {http.StatusUnprocessableEntity, "Idempotency key was already used"},
+	CodeRequestInProgress:     {http.StatusConflict, "Request is still being processed"},
+	CodeUserNotFound:          {http.StatusNotFound, "User not found"},
+	CodeUserExists:            {http.StatusConflict, "User already exists"},
+	CodeEntryNotCached:        {http.StatusNotFound, "Entry is not cached"},
+	CodeAPIKeyNotFound:        {http.StatusNotFound, "API key not found"},
+	CodeAPIKeyRevoked:         {http.StatusConflict, "API key is revoked"},
+	CodeUnauthorized:          {http.StatusUnauthorized, "Authentication required"},
+	CodeForbidden:             {http.StatusForbidden, "Forbidden"},
+	CodeRateLimited:           {http.StatusTooManyRequests, "Too many requests"},
+	CodeRouteNotFound:         {http.StatusNotFound, "Route not found"},
+	CodeMethodNotAllowed:      {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeDatabaseError:         {http.StatusInternalServerError, "Database error"},
+	CodeCacheError:            {http.StatusInternalServerError, "Cache error"},
+	CodeInternalError:         {http.StatusInternalServerError, "Internal server error"},
+	CodeInvalidQuery:          {http.StatusBadRequest, "GraphQL query is invalid"},
+	CodeQueryTooComplex:       {http.StatusBadRequest, "GraphQL query is too complex"},
+}
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      ErrorCode         `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    []ValidationError `json:"errors,omitempty"`
+}
+
+// ValidationError describes why one field failed validation, the message is
+// in the language requested with Accept-Language
+type ValidationError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// NewProblem returns the problem of the given code
+func NewProblem(code ErrorCode, detail string) *Problem {
+	t, ok := problemTypes[code]
+	if !ok {
+		code, t = CodeInternalError, problemTypes[CodeInternalError]
+	}
+	return &Problem{
+		Type:   config.ProblemTypePath + string(code),
+		Title:  t.title,
+		Status: t.status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// abortWithProblem answers the request with the problem of the given code
+func abortWithProblem(ctx *gin.Context, code ErrorCode, detail string) {
+	writeProblem(ctx, NewProblem(code, detail))
+}
+
+// abortWithValidationErrors answers the request with the field-level validation errors,
+// translated to the most preferred language of the Accept-Language header
+func abortWithValidationErrors(ctx *gin.Context, v *validation.Validation, errs validation.ValidationErrors) {
+	trans := v.Translator(ctx.GetHeader("Accept-Language"))
+	problem := NewProblem(CodeValidationFailed, "one or more fields are invalid")
+	for _, err := range errs {
+		problem.Errors = append(problem.Errors, ValidationError{Field: err.Field(), Rule: err.Tag(), Message: err.Translate(trans)})
+	}
+	ctx.Header("Content-Language", strings.ReplaceAll(trans.Locale(), "_", "-"))
+	writeProblem(ctx, problem)
+}
+
+func writeProblem(ctx *gin.Context, problem *Problem) {
+	problem.Instance = ctx.Request.URL.Path
+	problem.RequestID = RequestIDFrom(ctx)
+	ctx.Header("Content-Type", config.ProblemContentType)
+	ctx.AbortWithStatus(problem.Status)
+	_ = data.ToJSON(problem, ctx.Writer)
+}
+
+// NoRoute answers requests to unknown routes
+func NoRoute(ctx *gin.Context) {
+	abortWithProblem(ctx, CodeRouteNotFound, "no route matches "+ctx.Request.Method+" "+ctx.Request.URL.Path)
+}
+
+// NoMethod answers requests with a method the route does not support
+func NoMethod(ctx *gin.Context) {
+	abortWithProblem(ctx, CodeMethodNotAllowed, ctx.Request.Method+" is not allowed on "+ctx.Request.URL.Path)
+}
+
+// Recovery answers requests whose handler panicked
+func Recovery(ctx *gin.Context, err interface{}) {
+	abortWithProblem(ctx, CodeInternalError, "")
+}
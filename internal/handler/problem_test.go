@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sceyt_task/internal/config"
+	"testing"
+)
+
+func newTestProblemRouter() *gin.Engine {
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(NoRoute)
+	router.NoMethod(NoMethod)
+	router.Use(MiddlewareRequestID(testLogger()))
+	router.Use(gin.CustomRecovery(Recovery))
+	router.GET("/users", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+	router.GET("/panic", func(ctx *gin.Context) { panic("boom") })
+	return router
+}
+
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   ErrorCode
+		detail string
+	}{
+		{"unknown route", http.MethodGet, "/nothing", http.StatusNotFound, CodeRouteNotFound, "no route matches GET /nothing"},
+		{"unsupported method", http.MethodPost, "/users", http.StatusMethodNotAllowed, CodeMethodNotAllowed, "POST is not allowed on /users"},
+		{"panic", http.MethodGet, "/panic", http.StatusInternalServerError, CodeInternalError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := do(t, newTestProblemRouter(), tt.method, tt.path, "", config.RequestIDHeader, "req-1")
+			if res.Code != tt.status {
+				t.Fatalf("status = %d, want %d", res.Code, tt.status)
+			}
+			if contentType := res.Header().Get("Content-Type"); contentType != config.ProblemContentType {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+			var problem Problem
+			if err := json.Unmarshal(res.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			want := Problem{
+				Type:      config.ProblemTypePath + string(tt.code),
+				Title:     problemTypes[tt.code].title,
+				Status:    tt.status,
+				Detail:    tt.detail,
+				Instance:  tt.path,
+				Code:      tt.code,
+				RequestID: "req-1",
+			}
+			if !reflect.DeepEqual(problem, want) {
+				t.Errorf("problem = %+v, want %+v", problem, want)
+			}
+		})
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	for code, pt := range problemTypes {
+		problem := NewProblem(code, "detail")
+		if problem.Status != pt.status || problem.Title != pt.title || problem.Code != code ||
+			problem.Type != config.ProblemTypePath+string(code) {
+			t.Errorf("NewProblem(%s) = %+v", code, problem)
+		}
+	}
+	// an unknown code is reported as an internal error, never with a zero status
+	if problem := NewProblem("no_such_code", "x"); problem.Code != CodeInternalError || problem.Status != http.StatusInternalServerError {
+		t.Errorf("NewProblem() of an unknown code = %+v", problem)
+	}
+}
+
+func TestValidationErrorsFollowAcceptLanguage(t *testing.T) {
+	router := newTestRouter(newTestUserHandler(newFakeUserRepository(), newFakeUserCache()))
+	tests := []struct{ acceptLanguage, contentLanguage string }{
+		{"", "en"},
+		{"fr-CA, en;q=0.5", "fr"},
+		{"pt-BR", "pt-BR"},
+		{"de", "en"},
+	}
+	english := ""
+	for _, tt := range tests {
+		res := do(t, router, http.MethodPost, "/v2/users", `{"username":"carol"}`, principalHeader, writer, "Accept-Language", tt.acceptLanguage)
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d %s", res.Code, res.Body)
+		}
+		if got := res.Header().Get("Content-Language"); got != tt.contentLanguage {
+			t.Errorf("%q: Content-Language = %q, want %q", tt.acceptLanguage, got, tt.contentLanguage)
+		}
+		var problem Problem
+		if err := json.Unmarshal(res.Body.Bytes(), &problem); err != nil || len(problem.Errors) != 2 {
+			t.Fatalf("problem = %s", res.Body)
+		}
+		if field := problem.Errors[0]; field.Field != "firstname" || field.Rule != "required" || field.Message == "" {
+			t.Errorf("%q: error = %+v", tt.acceptLanguage, field)
+		}
+		if tt.contentLanguage == "en" {
+			english = problem.Errors[0].Message
+		} else if problem.Errors[0].Message == english {
+			t.Errorf("%q: message %q is not translated", tt.acceptLanguage, english)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"math"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"strconv"
+	"time"
+)
+
+// RateLimitHandler limits the request rate of every client IP and every authenticated caller
+type RateLimitHandler struct {
+	logger  logging.Logger
+	limiter cache.RateLimiter
+	config  *config.RateLimitConfiguration
+}
+
+// NewRateLimitHandler returns a new RateLimitHandler instance
+func NewRateLimitHandler(l logging.Logger, limiter cache.RateLimiter, c *config.RateLimitConfiguration) *RateLimitHandler {
+	return &RateLimitHandler{logger: l, limiter: limiter, config: c}
+}
+
+// rateLimitDecisionKey is the gin context key of the decision reported in the RateLimit-* headers
+const rateLimitDecisionKey = "rateLimitDecision"
+
+// bucket is a token bucket of a route, the buckets of a request are checked in order
+type bucket struct {
+	key   string
+	limit config.RateLimit
+}
+
+// MiddlewareRateLimit takes a token from the bucket of the client IP. Requests over
+// the limit are answered with 429 and a Retry-After header. If the limiter fails the
+// request is let through.
+func (h *RateLimitHandler) MiddlewareRateLimit(ctx *gin.Context) {
+	if !h.config.Enabled {
+		ctx.Next()
+		return
+	}
+	limits := h.config.For(ctx.FullPath())
+	if !h.take(ctx, []bucket{{key: "ip:" + ctx.ClientIP(), limit: limits.PerIP}}) {
+		return
+	}
+	ctx.Next()
+}
+
+// MiddlewarePrincipalRateLimit takes a token from the bucket of the authenticated caller,
+// it runs after the route guard so the bucket is keyed by the Principal and never by
+// credentials that were not checked yet
+func (h *RateLimitHandler) MiddlewarePrincipalRateLimit(ctx *gin.Context) {
+	principal := PrincipalFrom(ctx)
+	if !h.config.Enabled || principal == nil {
+		ctx.Next()
+		return
+	}
+	limits := h.config.For(ctx.FullPath())
+	if !h.take(ctx, []bucket{{key: "principal:" + principal.ID, limit: limits.PerPrincipal}}) {
+		return
+	}
+	ctx.Next()
+}
+
+// take takes a token from the buckets in the given order and stops at the first one
+// that denies the request, which is then aborted. The RateLimit-* headers describe
+// the most restrictive bucket checked for the request so far.
+func (h *RateLimitHandler) take(ctx *gin.Context, buckets []bucket) bool {
+	route := ctx.FullPath()
+	var decision *cache.Decision
+	if previous, ok := ctx.Value(rateLimitDecisionKey).(cache.Decision); ok {
+		decision = &previous
+	}
+	for _, b := range buckets {
+		if b.limit.Rate <= 0 || b.limit.Burst <= 0 {
+			continue
+		}
+		d, err := h.limiter.Allow(route+":"+b.key, cache.Limit{Rate: b.limit.Rate, Burst: b.limit.Burst})
+		if err != nil {
+			requestLogger(ctx, h.logger).Error("error while checking the rate limit", "error", err)
+			continue
+		}
+		if decision == nil || moreRestrictive(d, *decision) {
+			decision = &d
+		}
+		if !d.Allowed {
+			break
+		}
+	}
+	if decision == nil {
+		return true
+	}
+
+	ctx.Set(rateLimitDecisionKey, *decision)
+	ctx.Header(config.RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+	ctx.Header(config.RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+	ctx.Header(config.RateLimitResetHeader, strconv.Itoa(seconds(decision.Reset)))
+	if !decision.Allowed {
+		ctx.Header(config.RetryAfterHeader, strconv.Itoa(seconds(decision.RetryAfter)))
+		requestLogger(ctx, h.logger).Warn("rate limit exceeded on ", route, " by ", ctx.ClientIP())
+		abortWithProblem(ctx, CodeRateLimited, "too many requests, retry later")
+		return false
+	}
+	return true
+}
+
+// moreRestrictive reports whether a denies the request or leaves fewer requests than b
+func moreRestrictive(a cache.Decision, b cache.Decision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	return a.Remaining < b.Remaining
+}
+
+// seconds rounds the duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
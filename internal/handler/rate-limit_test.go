@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sceyt_task/internal/cache"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+// failingLimiter is a RateLimiter whose calls fail
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(key string, limit cache.Limit) (cache.Decision, error) {
+	return cache.Decision{}, errors.New("redis is down")
+}
+
+// recordingLimiter records the keys of the buckets it is asked for
+type recordingLimiter struct {
+	next cache.RateLimiter
+	keys []string
+}
+
+func (r *recordingLimiter) Allow(key string, limit cache.Limit) (cache.Decision, error) {
+	r.keys = append(r.keys, key)
+	return r.next.Allow(key, limit)
+}
+
+func newTestRateLimitRouter(limiter cache.RateLimiter, c *config.RateLimitConfiguration) *gin.Engine {
+	h := NewRateLimitHandler(testLogger(), limiter, c)
+	router := gin.New()
+	router.Use(h.MiddlewareRateLimit)
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.POST("/search", ok)
+	router.GET("/v2/users/:username", ok)
+	router.POST("/guarded", route(testGuard, data.ScopeRead, []gin.HandlerFunc{h.MiddlewarePrincipalRateLimit}, ok)...)
+	return router
+}
+
+var testRateLimits = &config.RateLimitConfiguration{
+	Enabled: true,
+	Default: config.RouteRateLimits{PerIP: config.RateLimit{Rate: 0.001, Burst: 10}},
+	Routes: map[string]config.RouteRateLimits{
+		"/search": {
+			PerIP:        config.RateLimit{Rate: 0.001, Burst: 3},
+			PerPrincipal: config.RateLimit{Rate: 0.001, Burst: 2},
+		},
+	},
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	router := newTestRateLimitRouter(cache.NewLocalRateLimiter(100), testRateLimits)
+
+	for _, remaining := range []string{"2", "1", "0"} {
+		res := do(t, router, http.MethodPost, "/search", "")
+		if res.Code != http.StatusOK || res.Header().Get(config.RateLimitRemainingHeader) != remaining ||
+			res.Header().Get(config.RateLimitLimitHeader) != "3" {
+			t.Fatalf("search = %d %v, want %s remaining", res.Code, res.Header(), remaining)
+		}
+	}
+	res := do(t, router, http.MethodPost, "/search", "")
+	if res.Code != http.StatusTooManyRequests || problemCode(t, res) != CodeRateLimited {
+		t.Fatalf("search over the limit = %d %s, want 429", res.Code, res.Body)
+	}
+	if retryAfter := res.Header().Get(config.RetryAfterHeader); retryAfter != "1000" {
+		t.Errorf("Retry-After = %q, want 1000", retryAfter)
+	}
+	// every route has its own buckets
+	if res = do(t, router, http.MethodGet, "/v2/users/alice", ""); res.Code != http.StatusOK || res.Header().Get(config.RateLimitLimitHeader) != "10" {
+		t.Fatalf("get = %d %v, want the default limit", res.Code, res.Header())
+	}
+}
+
+func TestRateLimitPerPrincipal(t *testing.T) {
+	limits := *testRateLimits
+	limits.Routes = map[string]config.RouteRateLimits{
+		"/guarded": {
+			PerIP:        config.RateLimit{Rate: 0.001, Burst: 5},
+			PerPrincipal: config.RateLimit{Rate: 0.001, Burst: 2},
+		},
+	}
+	router := newTestRateLimitRouter(cache.NewLocalRateLimiter(100), &limits)
+
+	// requests without valid credentials are rejected by the guard before the principal bucket
+	if res := do(t, router, http.MethodPost, "/guarded", ""); res.Code != http.StatusUnauthorized || res.Header().Get(config.RateLimitLimitHeader) != "5" {
+		t.Fatalf("unauthenticated request = %d %v, want 401 limited by the IP bucket", res.Code, res.Header())
+	}
+	// the principal bucket is the more restrictive one and is reported
+	res := do(t, router, http.MethodPost, "/guarded", "", principalHeader, data.ScopeRead)
+	if res.Code != http.StatusOK || res.Header().Get(config.RateLimitLimitHeader) != "2" || res.Header().Get(config.RateLimitRemainingHeader) != "1" {
+		t.Fatalf("request = %d %v", res.Code, res.Header())
+	}
+	_ = do(t, router, http.MethodPost, "/guarded", "", principalHeader, data.ScopeRead)
+	res = do(t, router, http.MethodPost, "/guarded", "", principalHeader, data.ScopeRead)
+	if res.Code != http.StatusTooManyRequests || problemCode(t, res) != CodeRateLimited || res.Header().Get(config.RateLimitLimitHeader) != "2" {
+		t.Fatalf("third request = %d %v, want 429 of the principal bucket", res.Code, res.Header())
+	}
+}
+
+func TestRateLimitBucketsAreCheckedInOrder(t *testing.T) {
+	limiter := &recordingLimiter{next: cache.NewLocalRateLimiter(100)}
+	h := NewRateLimitHandler(testLogger(), limiter, testRateLimits)
+	router := gin.New()
+	router.GET("/", func(ctx *gin.Context) {
+		if h.take(ctx, []bucket{
+			{key: "first", limit: config.RateLimit{Rate: 0.001, Burst: 1}},
+			{key: "disabled", limit: config.RateLimit{}},
+			{key: "second", limit: config.RateLimit{Rate: 0.001, Burst: 5}},
+		}) {
+			ctx.Status(http.StatusOK)
+		}
+	})
+
+	// the first bucket is the more restrictive one and is reported
+	res := do(t, router, http.MethodGet, "/", "")
+	if res.Code != http.StatusOK || res.Header().Get(config.RateLimitLimitHeader) != "1" || res.Header().Get(config.RateLimitRemainingHeader) != "0" {
+		t.Fatalf("first request = %d %v", res.Code, res.Header())
+	}
+	// the denial of the first bucket stops the check, the second bucket keeps its tokens
+	res = do(t, router, http.MethodGet, "/", "")
+	if res.Code != http.StatusTooManyRequests || problemCode(t, res) != CodeRateLimited {
+		t.Fatalf("second request = %d %s, want 429", res.Code, res.Body)
+	}
+	if want := []string{"/:first", "/:second", "/:first"}; !reflect.DeepEqual(limiter.keys, want) {
+		t.Errorf("checked buckets = %q, want %q", limiter.keys, want)
+	}
+}
+
+func TestRateLimitLetsRequestsThrough(t *testing.T) {
+	disabled := *testRateLimits
+	disabled.Enabled = false
+	tests := map[string]*gin.Engine{
+		"disabled":        newTestRateLimitRouter(failingLimiter{}, &disabled),
+		"limiter failure": newTestRateLimitRouter(failingLimiter{}, testRateLimits),
+	}
+	for name, router := range tests {
+		for i := 0; i < 5; i++ {
+			res := do(t, router, http.MethodPost, "/search", "")
+			if res.Code != http.StatusOK || res.Header().Get(config.RateLimitLimitHeader) != "" {
+				t.Fatalf("%s: search = %d %v, want 200 without rate limit headers", name, res.Code, res.Header())
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"strings"
+)
+
+// actions on users checked by the Policy
+const (
+	ActionCreate = "create"
+	ActionRead   = "read"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// RoleAdmin is granted every permission, callers with the admin scope have it
+const RoleAdmin = "admin"
+
+// ownership of the users a permission applies to
+const (
+	ownUser = "own"
+	anyUser = "any"
+)
+
+// Policy decides which actions a caller may perform on which users from the permissions
+// of its roles. Bearer tokens bring their roles, API keys have the configured roles and
+// callers with the admin scope have the admin role.
+type Policy struct {
+	logger      logging.Logger
+	enabled     bool
+	permissions map[string]map[string]bool
+	apiKeyRoles []string
+}
+
+// NewPolicy returns a new Policy instance, every action is allowed when it is disabled
+func NewPolicy(l logging.Logger, c *config.RBACConfiguration) *Policy {
+	p := &Policy{logger: l, enabled: c.Enabled, permissions: map[string]map[string]bool{}, apiKeyRoles: c.APIKeyRoles}
+	for role, permissions := range c.Roles {
+		p.permissions[role] = map[string]bool{}
+		for _, permission := range permissions {
+			if i := strings.Index(permission, ":"); i <= 0 || (permission[i+1:] != ownUser && permission[i+1:] != anyUser) {
+				l.Warn("ignoring permission ", permission, " of role ", role, ", it is not <action>:own or <action>:any")
+				continue
+			}
+			p.permissions[role][permission] = true
+		}
+	}
+	return p
+}
+
+// roles returns the roles of the caller
+func (p *Policy) roles(principal *Principal) []string {
+	if principal == nil {
+		return nil
+	}
+	var roles []string
+	if principal.HasScope(data.ScopeAdmin) {
+		roles = append(roles, RoleAdmin)
+	}
+	switch principal.Method {
+	case MethodAPIKey:
+		roles = append(roles, p.apiKeyRoles...)
+	case MethodBearer:
+		roles = append(roles, principal.Roles...)
+	}
+	return roles
+}
+
+// Allowed reports whether the caller may perform the action on the user. A user is the
+// caller's own when it is the subject of the caller's bearer token.
+func (p *Policy) Allowed(principal *Principal, action string, username string) bool {
+	if !p.enabled {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	own := principal.Method == MethodBearer && principal.ID == username
+	for _, role := range p.roles(principal) {
+		if role == RoleAdmin {
+			return true
+		}
+		permissions := p.permissions[role]
+		for _, a := range []string{action, "*"} {
+			if permissions[a+":"+anyUser] || (own && permissions[a+":"+ownUser]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorize answers the request with 403 when the caller may not perform the action
+// on the user, it reports whether the caller is allowed
+func (p *Policy) authorize(ctx *gin.Context, action string, username string) bool {
+	principal := PrincipalFrom(ctx)
+	if p.Allowed(principal, action, username) {
+		return true
+	}
+	requestLogger(ctx, p.logger).Warn("denied ", action, " of user ", username, " to ", principal, " with roles ", p.roles(principal))
+	abortWithProblem(ctx, CodeForbidden, "not allowed to "+action+" this user")
+	return false
+}
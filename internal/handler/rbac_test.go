@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/validation"
+	"testing"
+)
+
+// rbacConfig are the roles of properties/rbacConfig.yml
+var rbacConfig = &config.RBACConfiguration{
+	Enabled:     true,
+	APIKeyRoles: []string{"service"},
+	Roles: map[string][]string{
+		"user":    {"read:own", "update:own", "delete:own"},
+		"support": {"read:any"},
+		"service": {"*:any"},
+	},
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := NewPolicy(testLogger(), rbacConfig)
+	alice := &Principal{ID: "alice", Method: MethodBearer, Roles: []string{"user"}}
+	support := &Principal{ID: "sam", Method: MethodBearer, Roles: []string{"support"}}
+	both := &Principal{ID: "sam", Method: MethodBearer, Roles: []string{"user", "support"}}
+	service := &Principal{ID: "k1", Method: MethodAPIKey, Scopes: []string{data.ScopeWrite}}
+	admin := &Principal{ID: "k2", Method: MethodAPIKey, Scopes: []string{data.ScopeAdmin}}
+	adminToken := &Principal{ID: "admin", Method: MethodAdminToken, Scopes: []string{data.ScopeAdmin}}
+	// an API key with the id of a user does not own the user
+	keyNamedAlice := &Principal{ID: "alice", Method: MethodAPIKey}
+	// roles are only taken from bearer tokens
+	keyWithRoles := &Principal{ID: "k3", Method: MethodAdminToken, Roles: []string{"support"}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		action    string
+		username  string
+		want      bool
+	}{
+		{"user reads own", alice, ActionRead, "alice", true},
+		{"user updates own", alice, ActionUpdate, "alice", true},
+		{"user deletes own", alice, ActionDelete, "alice", true},
+		{"user creates own", alice, ActionCreate, "alice", false},
+		{"user reads other", alice, ActionRead, "bob", false},
+		{"user updates other", alice, ActionUpdate, "bob", false},
+		{"user searches", alice, ActionRead, "", false},
+		{"support reads other", support, ActionRead, "bob", true},
+		{"support searches", support, ActionRead, "", true},
+		{"support updates other", support, ActionUpdate, "bob", false},
+		{"support updates own", support, ActionUpdate, "sam", false},
+		{"roles add up", both, ActionUpdate, "sam", true},
+		{"roles add up for others", both, ActionUpdate, "bob", false},
+		{"api key deletes", service, ActionDelete, "bob", true},
+		{"admin scope", admin, ActionCreate, "bob", true},
+		{"admin token", adminToken, ActionDelete, "bob", true},
+		{"api key is no owner", keyNamedAlice, ActionRead, "alice", true},
+		{"roles of other credentials", keyWithRoles, ActionRead, "bob", false},
+		{"no principal", nil, ActionRead, "bob", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.principal, tt.action, tt.username); got != tt.want {
+			t.Errorf("%s: Allowed(%s, %q) = %t, want %t", tt.name, tt.action, tt.username, got, tt.want)
+		}
+	}
+
+	// the API key roles are configured, without them the key is not allowed anything
+	policy = NewPolicy(testLogger(), &config.RBACConfiguration{Enabled: true, Roles: rbacConfig.Roles})
+	if policy.Allowed(keyNamedAlice, ActionRead, "alice") || !policy.Allowed(admin, ActionRead, "alice") {
+		t.Error("API keys without roles are allowed to read or admin keys are not")
+	}
+}
+
+func TestPolicyDisabled(t *testing.T) {
+	policy := NewPolicy(testLogger(), &config.RBACConfiguration{})
+	if !policy.Allowed(nil, ActionDelete, "bob") || !policy.Allowed(&Principal{ID: "alice", Method: MethodBearer}, ActionUpdate, "bob") {
+		t.Fatal("a disabled policy denied an action")
+	}
+}
+
+func TestPolicyIgnoresInvalidPermissions(t *testing.T) {
+	policy := NewPolicy(testLogger(), &config.RBACConfiguration{
+		Enabled: true,
+		Roles:   map[string][]string{"broken": {"read", "read:mine", ":any", "delete:any"}},
+	})
+	caller := &Principal{ID: "alice", Method: MethodBearer, Roles: []string{"broken"}}
+	if policy.Allowed(caller, ActionRead, "alice") || policy.Allowed(caller, ActionRead, "bob") {
+		t.Error("an invalid permission was granted")
+	}
+	if !policy.Allowed(caller, ActionDelete, "bob") {
+		t.Error("the valid permission of the role was dropped")
+	}
+}
+
+func TestRBACOnUserRoutes(t *testing.T) {
+	// testGuard authenticates every caller as the bearer token subject "tester"
+	repo := newFakeUserRepository(
+		&data.User{Username: "tester", FirstName: "Test", LastName: "Er"},
+		&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"},
+	)
+	u := NewUserHandler(testLogger(), validation.NewValidation(), repo, newFakeUserCache(), NewPolicy(testLogger(), rbacConfig))
+	router := newTestRouter(u)
+	const (
+		user    = data.ScopeRead + "," + data.ScopeWrite + ";user"
+		support = data.ScopeRead + "," + data.ScopeWrite + ";support"
+	)
+
+	tests := []struct {
+		name      string
+		principal string
+		method    string
+		path      string
+		body      string
+		status    int
+	}{
+		{"user reads own", user, http.MethodGet, "/v2/users/tester", "", http.StatusOK},
+		{"user reads other", user, http.MethodGet, "/v2/users/alice", "", http.StatusForbidden},
+		{"user searches other", user, http.MethodPost, "/search", `{"username":"alice"}`, http.StatusForbidden},
+		{"user creates", user, http.MethodPost, "/v2/users", `{"username":"tester2","firstname":"T","lastname":"E"}`, http.StatusForbidden},
+		{"user patches other", user, http.MethodPatch, "/v2/users/alice", `{"firstname":"Al"}`, http.StatusForbidden},
+		{"user updates other", user, http.MethodPost, "/update/", `{"username":"alice","firstname":"Al"}`, http.StatusForbidden},
+		{"user deletes other", user, http.MethodDelete, "/delete/", `{"username":"alice"}`, http.StatusForbidden},
+		{"user patches own", user, http.MethodPatch, "/v2/users/tester", `{"firstname":"Tess"}`, http.StatusOK},
+		{"support reads other", support, http.MethodGet, "/v2/users/alice", "", http.StatusOK},
+		{"support searches other", support, http.MethodPost, "/search", `{"username":"alice"}`, http.StatusOK},
+		{"support deletes other", support, http.MethodDelete, "/v2/users/alice", "", http.StatusForbidden},
+		{"no role", data.ScopeRead, http.MethodGet, "/v2/users/tester", "", http.StatusForbidden},
+		{"user deletes own", user, http.MethodDelete, "/v2/users/tester", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		res := do(t, router, tt.method, tt.path, tt.body, principalHeader, tt.principal)
+		if res.Code != tt.status {
+			t.Fatalf("%s: status = %d %s, want %d", tt.name, res.Code, res.Body, tt.status)
+		}
+		if tt.status == http.StatusForbidden && problemCode(t, res) != CodeForbidden {
+			t.Errorf("%s: code = %s, want %s", tt.name, problemCode(t, res), CodeForbidden)
+		}
+	}
+	if user := repo.user("alice"); user == nil || user.FirstName != "Alice" {
+		t.Fatalf("alice = %+v, want her unchanged", user)
+	}
+	if repo.user("tester") != nil || repo.user("tester2") != nil {
+		t.Fatal("tester was not deleted or tester2 was created")
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+)
+
+// requestIDKey is the gin context key of the request id
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds the size of request ids accepted from clients
+const maxRequestIDLength = 128
+
+// validRequestID reports whether the id sent by the client can be used as is, it is
+// written to the logs and the response headers, so only printable ascii is accepted
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// MiddlewareRequestID identifies every request by the X-Request-ID header of the client,
+// or by a new id when it sent none. The id is echoed in the response and the logger of
+// the request, which adds the id to every entry, is stored in the request context.
+func MiddlewareRequestID(l logging.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(config.RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewV4().String()
+		}
+		ctx.Set(requestIDKey, id)
+		ctx.Header(config.RequestIDHeader, id)
+		logger := l.GetLoggerWithField(requestIDKey, id)
+		ctx.Request = ctx.Request.WithContext(logging.NewContext(ctx.Request.Context(), logger))
+		ctx.Next()
+	}
+}
+
+// RequestIDFrom returns the id of the request
+func RequestIDFrom(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
+
+// requestLogger returns the logger of the request, fallback when the request has none
+func requestLogger(ctx *gin.Context, fallback logging.Logger) logging.Logger {
+	return logging.FromContext(ctx.Request.Context(), fallback)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDIsEchoed(t *testing.T) {
+	router := gin.New()
+	router.Use(MiddlewareRequestID(testLogger()))
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, RequestIDFrom(ctx))
+	})
+
+	res := do(t, router, http.MethodGet, "/", "", config.RequestIDHeader, "gateway-42")
+	if id := res.Header().Get(config.RequestIDHeader); id != "gateway-42" || res.Body.String() != id {
+		t.Fatalf("request id = %q, handler saw %q, want the id of the client", id, res.Body)
+	}
+
+	// ids that can not be logged or sent back as is are replaced
+	for name, sent := range map[string]string{
+		"none":        "",
+		"too long":    strings.Repeat("a", maxRequestIDLength+1),
+		"space":       "a b",
+		"new line":    "a\nX-Injected: 1",
+		"non ascii":   "réquest",
+		"control":     "a\x7f",
+		"only spaces": "   ",
+	} {
+		res = do(t, router, http.MethodGet, "/", "", config.RequestIDHeader, sent)
+		id := res.Header().Get(config.RequestIDHeader)
+		if _, err := uuid.FromString(id); err != nil || res.Body.String() != id {
+			t.Errorf("%s: request id = %q, handler saw %q, want a new uuid", name, id, res.Body)
+		}
+	}
+	if a, b := do(t, router, http.MethodGet, "/", ""), do(t, router, http.MethodGet, "/", ""); a.Body.String() == b.Body.String() {
+		t.Errorf("two requests got the same id %s", a.Body)
+	}
+	if res = do(t, router, http.MethodGet, "/", "", config.RequestIDHeader, strings.Repeat("a", maxRequestIDLength)); res.Body.Len() != maxRequestIDLength {
+		t.Errorf("an id of the maximum length was replaced by %s", res.Body)
+	}
+}
+
+// the handlers log through the logger of the request, whatever logger they were created with
+func TestRequestLoggerCarriesTheID(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	repo := newFakeUserRepository()
+	repo.down = true
+	router := gin.New()
+	router.Use(MiddlewareRequestID(logging.Logger{Entry: logrus.NewEntry(l)}))
+	newTestUserHandler(repo, newFakeUserCache()).RoutesV2(router, testGuard)
+
+	res := do(t, router, http.MethodGet, "/v2/users/alice", "", principalHeader, writer, config.RequestIDHeader, "req-7")
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("get = %d %s, want 500", res.Code, res.Body)
+	}
+	if !strings.Contains(res.Body.String(), `"request_id":"req-7"`) {
+		t.Errorf("problem = %s, want the request id", res.Body)
+	}
+	entries := hook.AllEntries()
+	if len(entries) == 0 {
+		t.Fatal("the failure was not logged through the request logger")
+	}
+	for _, entry := range entries {
+		if entry.Data[requestIDKey] != "req-7" {
+			t.Errorf("entry %q has the fields %v, want the request id", entry.Message, entry.Data)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sceyt_task/internal/data"
+)
+
+// Request DTOs are decoded strictly, unknown fields are rejected, and every
+// operation accepts only the fields it may change. They are mapped explicitly
+// into domain objects, so server managed fields like id or created_at can not
+// be set by clients.
+type (
+	// CreateUserRequest is the body of /add/ and POST /v2/users
+	CreateUserRequest struct {
+		Username  string `json:"username" validate:"required"`
+		FirstName string `json:"firstname" validate:"required"`
+		LastName  string `json:"lastname" validate:"required"`
+	}
+
+	// UpdateUserRequest is the body of /update/, fields that are not sent stay
+	// unchanged and a null value clears the field
+	UpdateUserRequest struct {
+		Username  string         `json:"username" validate:"required"`
+		FirstName OptionalString `json:"firstname" swaggertype:"string"`
+		LastName  OptionalString `json:"lastname" swaggertype:"string"`
+	}
+
+	// ReplaceUserRequest is the body of PUT /v2/users/{username}, the username
+	// is optional and must match the path when it is sent
+	ReplaceUserRequest struct {
+		Username  string `json:"username"`
+		FirstName string `json:"firstname" validate:"required"`
+		LastName  string `json:"lastname" validate:"required"`
+	}
+
+	// UsernameRequest is the body of /search/ and /delete/
+	UsernameRequest struct {
+		Username string `json:"username" validate:"required"`
+	}
+)
+
+// OptionalString is a string field that tells whether it was present in the document
+type OptionalString struct {
+	Set   bool
+	Value string
+}
+
+// UnmarshalJSON is only called for fields present in the document, null is read as an empty string
+func (o *OptionalString) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if string(b) == "null" {
+		o.Value = ""
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
+
+// User returns the user to be created
+func (r *CreateUserRequest) User() *data.User {
+	return &data.User{Username: r.Username, FirstName: r.FirstName, LastName: r.LastName}
+}
+
+// Changes returns the fields to be written
+func (r *UpdateUserRequest) Changes() data.UserChanges {
+	changes := data.UserChanges{}
+	if r.FirstName.Set {
+		changes.FirstName = &r.FirstName.Value
+	}
+	if r.LastName.Set {
+		changes.LastName = &r.LastName.Value
+	}
+	return changes
+}
+
+// User returns the user with the given username and the replaced fields
+func (r *ReplaceUserRequest) User(username string) *data.User {
+	return &data.User{Username: username, FirstName: r.FirstName, LastName: r.LastName}
+}
+
+// decodeStrict decodes a single json document, unknown fields and trailing data are rejected
+func decodeStrict(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if err == io.EOF {
+			return errors.New("request body is empty")
+		}
+		return err
+	}
+	// More() stops at a closing delimiter, decoding once more catches `} }` and `}]` too
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("request body must contain a single json document")
+	}
+	return nil
+}
+
+// decodeStrictBytes is decodeStrict for a body that was already read
+func decodeStrictBytes(b []byte, v interface{}) error {
+	return decodeStrict(bytes.NewReader(b), v)
+}
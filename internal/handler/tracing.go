@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"sceyt_task/pkg/logging"
+)
+
+// MiddlewareTracing records a server span per request with otelgin, continuing the trace
+// of the traceparent header when the client sent one. The span is stored in the request
+// context so the cache and repository spans become its children, and the trace id is added
+// to the logger of the request. It must run after MiddlewareRequestID.
+func MiddlewareTracing(l logging.Logger, service string, tp trace.TracerProvider) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		otelgin.Middleware(service, otelgin.WithTracerProvider(tp), otelgin.WithPropagators(propagation.TraceContext{})),
+		func(ctx *gin.Context) {
+			span := trace.SpanFromContext(ctx.Request.Context())
+			if !span.SpanContext().IsValid() {
+				ctx.Next()
+				return
+			}
+			span.SetAttributes(attribute.String("request_id", RequestIDFrom(ctx)))
+			c := ctx.Request.Context()
+			logger := logging.FromContext(c, l)
+			c = logging.NewContext(c, logger.GetLoggerWithField("trace_id", span.SpanContext().TraceID().String()))
+			ctx.Request = ctx.Request.WithContext(c)
+			ctx.Next()
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"sceyt_task/internal/config"
+	"sceyt_task/pkg/logging"
+	"testing"
+)
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, a := range span.Attributes() {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddlewareTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	l, hook := test.NewNullLogger()
+	router := gin.New()
+	router.Use(MiddlewareRequestID(logging.Logger{Entry: logrus.NewEntry(l)}))
+	router.Use(MiddlewareTracing(testLogger(), "user-server", provider)...)
+	router.GET("/v2/users/:username", func(ctx *gin.Context) {
+		_, child := provider.Tracer("test").Start(ctx.Request.Context(), "redis GET", trace.WithSpanKind(trace.SpanKindClient))
+		child.End()
+		requestLogger(ctx, testLogger()).Info("handled")
+		ctx.Status(http.StatusInternalServerError)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_ = do(t, router, http.MethodGet, "/v2/users/alice?x=1", "", "traceparent", parent, config.RequestIDHeader, "req-1")
+	// a malformed traceparent starts a new trace
+	_ = do(t, router, http.MethodGet, "/nope", "", "traceparent", "00-zz-00f067aa0ba902b7-01")
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans ended, want 3", len(spans))
+	}
+	child, server, unmatched := spans[0], spans[1], spans[2]
+	if server.Name() != "/v2/users/:username" || server.SpanKind() != trace.SpanKindServer ||
+		server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span = %+v, want it to continue the trace of the caller", server)
+	}
+	if spanAttribute(server, "http.route").AsString() != "/v2/users/:username" ||
+		spanAttribute(server, "http.status_code").AsInt64() != http.StatusInternalServerError ||
+		spanAttribute(server, "request_id").AsString() != "req-1" || server.Status().Code != codes.Error {
+		t.Errorf("server span attributes = %v, status = %v", server.Attributes(), server.Status())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() || child.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Errorf("the span of the handler is not a child of the server span")
+	}
+	if unmatched.Parent().IsValid() || unmatched.SpanContext().TraceID() == server.SpanContext().TraceID() {
+		t.Errorf("unmatched span = %+v, want the root of a new trace", unmatched)
+	}
+
+	// the logger of the request carries the trace id
+	var traced bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "handled" {
+			traced = entry.Data["trace_id"] == server.SpanContext().TraceID().String() && entry.Data[requestIDKey] == "req-1"
+		}
+	}
+	if !traced {
+		t.Errorf("the log entry of the handler has no trace id: %v", hook.AllEntries())
+	}
+}
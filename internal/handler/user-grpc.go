@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	userv1 "sceyt_task/api/user/v1"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"sceyt_task/pkg/logging"
+	"strings"
+)
+
+// UserRPCService serves the users over gRPC with the repository, the cache, the validation
+// and the permissions of the REST API
+type UserRPCService struct {
+	userv1.UnimplementedUserServiceServer
+	users *UserHandler
+	auth  *Authenticator
+}
+
+// NewUserRPCService returns a new UserRPCService instance
+func NewUserRPCService(u *UserHandler, a *Authenticator) *UserRPCService {
+	return &UserRPCService{users: u, auth: a}
+}
+
+// rpcCall is a unary method called by an authenticated caller
+type rpcCall struct {
+	principal      *Principal
+	acceptLanguage string
+}
+
+// authenticate authenticates the caller and only returns the call when it was granted the scope
+func (s *UserRPCService) authenticate(ctx context.Context, method string, scope string) (*rpcCall, error) {
+	logger := logging.FromContext(ctx, s.users.logger)
+	header := incomingHeader(ctx)
+	principal, err := s.auth.authenticate(ctx, header)
+	if isCredentialError(err) {
+		logger.Warn("rejected credentials of ", method, ": ", err)
+		return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	if err != nil {
+		logger.Error("error while authenticating call", "error", err)
+		return nil, status.Errorf(codes.Unavailable, "credentials can not be checked, please try again later")
+	}
+	if principal == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "x-api-key, authorization or x-admin-token metadata is required")
+	}
+	if !principal.HasScope(scope) {
+		logger.Warn("denied ", method, " to ", principal, ", missing scope ", scope)
+		return nil, status.Errorf(codes.PermissionDenied, "the %s scope is required", scope)
+	}
+	logger.Debug("authenticated ", principal, " for ", method)
+	return &rpcCall{principal: principal, acceptLanguage: header.Get("Accept-Language")}, nil
+}
+
+// authorize checks the permission of the caller on the user
+func (s *UserRPCService) authorize(ctx context.Context, call *rpcCall, action string, username string) error {
+	if s.users.policy.Allowed(call.principal, action, username) {
+		return nil
+	}
+	logging.FromContext(ctx, s.users.logger).Warn("denied ", action, " of ", username, " to ", call.principal)
+	return status.Errorf(codes.PermissionDenied, "not allowed to %s this user", action)
+}
+
+// validate validates the request like the body of the REST API
+func (s *UserRPCService) validate(ctx context.Context, call *rpcCall, req interface{}) error {
+	errs := s.users.validator.Validate(req)
+	if len(errs) == 0 {
+		return nil
+	}
+	logging.FromContext(ctx, s.users.logger).Error("validation of request message failed", "error", errs)
+	trans := s.users.validator.Translator(call.acceptLanguage)
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Translate(trans))
+	}
+	return status.Errorf(codes.InvalidArgument, "%s", strings.Join(messages, "; "))
+}
+
+// storageError maps an error of the repository to the status of the call
+func (s *UserRPCService) storageError(ctx context.Context, message string, err error) error {
+	switch err {
+	case repository.ErrUserNotFound:
+		return status.Errorf(codes.NotFound, "%s", ErrUserNotFound)
+	case repository.ErrUserExists:
+		return status.Errorf(codes.AlreadyExists, "%s", ErrUserExists)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return status.FromContextError(err).Err()
+	}
+	logging.FromContext(ctx, s.users.logger).Error(message, "error", err)
+	return status.Errorf(codes.Unavailable, "%s, please try again later", message)
+}
+
+// AddUser creates a user, ALREADY_EXISTS if the username is taken
+func (s *UserRPCService) AddUser(ctx context.Context, in *userv1.AddUserRequest) (*userv1.User, error) {
+	call, err := s.authenticate(ctx, userv1.UserService_AddUser_FullMethodName, data.ScopeWrite)
+	if err != nil {
+		return nil, err
+	}
+	req := &CreateUserRequest{Username: in.GetUsername(), FirstName: in.GetFirstName(), LastName: in.GetLastName()}
+	if err = s.validate(ctx, call, req); err != nil {
+		return nil, err
+	}
+	if err = s.authorize(ctx, call, ActionCreate, req.Username); err != nil {
+		return nil, err
+	}
+
+	user := req.User()
+	if err = s.users.repo.Create(ctx, user); err != nil {
+		return nil, s.storageError(ctx, "error while adding user", err)
+	}
+	return userMessage(user), nil
+}
+
+// UpdateUser changes the fields that are set, NOT_FOUND if there is no such user
+func (s *UserRPCService) UpdateUser(ctx context.Context, in *userv1.UpdateUserRequest) (*userv1.User, error) {
+	call, err := s.authenticate(ctx, userv1.UserService_UpdateUser_FullMethodName, data.ScopeWrite)
+	if err != nil {
+		return nil, err
+	}
+	req := &UpdateUserRequest{Username: in.GetUsername()}
+	if in.FirstName != nil {
+		req.FirstName = OptionalString{Set: true, Value: in.GetFirstName()}
+	}
+	if in.LastName != nil {
+		req.LastName = OptionalString{Set: true, Value: in.GetLastName()}
+	}
+	if err = s.validate(ctx, call, req); err != nil {
+		return nil, err
+	}
+	if err = s.authorize(ctx, call, ActionUpdate, req.Username); err != nil {
+		return nil, err
+	}
+
+	if err = s.users.repo.Patch(ctx, req.Username, req.Changes()); err != nil {
+		return nil, s.storageError(ctx, "error while updating user", err)
+	}
+	s.users.invalidate(ctx, req.Username)
+	user, err := s.users.loadUser(ctx, req.Username)
+	if err != nil {
+		return nil, s.storageError(ctx, "error while reading the updated user", err)
+	}
+	return userMessage(user), nil
+}
+
+// DeleteUser deletes a user, NOT_FOUND if there is no such user
+func (s *UserRPCService) DeleteUser(ctx context.Context, in *userv1.DeleteUserRequest) (*userv1.DeleteUserResponse, error) {
+	call, err := s.authenticate(ctx, userv1.UserService_DeleteUser_FullMethodName, data.ScopeWrite)
+	if err != nil {
+		return nil, err
+	}
+	req := &UsernameRequest{Username: in.GetUsername()}
+	if err = s.validate(ctx, call, req); err != nil {
+		return nil, err
+	}
+	if err = s.authorize(ctx, call, ActionDelete, req.Username); err != nil {
+		return nil, err
+	}
+
+	if err = s.users.repo.Delete(ctx, req.Username); err != nil {
+		return nil, s.storageError(ctx, "error when deleting user", err)
+	}
+	s.users.invalidate(ctx, req.Username)
+	return &userv1.DeleteUserResponse{}, nil
+}
+
+// GetUser returns a user, NOT_FOUND if there is no such user
+func (s *UserRPCService) GetUser(ctx context.Context, in *userv1.GetUserRequest) (*userv1.User, error) {
+	call, err := s.authenticate(ctx, userv1.UserService_GetUser_FullMethodName, data.ScopeRead)
+	if err != nil {
+		return nil, err
+	}
+	req := &UsernameRequest{Username: in.GetUsername()}
+	if err = s.validate(ctx, call, req); err != nil {
+		return nil, err
+	}
+	if err = s.authorize(ctx, call, ActionRead, req.Username); err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.loadUser(ctx, req.Username)
+	if err != nil {
+		return nil, s.storageError(ctx, "unable to retrieve user from database", err)
+	}
+	return userMessage(user), nil
+}
+
+// ListUsers returns the users page by page
+func (s *UserRPCService) ListUsers(ctx context.Context, in *userv1.ListUsersRequest) (*userv1.ListUsersResponse, error) {
+	call, err := s.authenticate(ctx, userv1.UserService_ListUsers_FullMethodName, data.ScopeRead)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(in.GetPageSize())
+	if pageSize < 0 || pageSize > config.GRPCMaxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 0 and %d", config.GRPCMaxPageSize)
+	}
+	if pageSize == 0 {
+		pageSize = config.GRPCDefaultPageSize
+	}
+	pageState, err := base64.RawURLEncoding.DecodeString(in.GetPageToken())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "page_token is invalid")
+	}
+	// listing is only allowed to callers that can read every user
+	if err = s.authorize(ctx, call, ActionRead, ""); err != nil {
+		return nil, err
+	}
+
+	users, next, err := s.users.repo.List(ctx, pageSize, pageState)
+	if err != nil {
+		return nil, s.storageError(ctx, "error while listing users", err)
+	}
+	res := &userv1.ListUsersResponse{Users: make([]*userv1.User, 0, len(users))}
+	for _, user := range users {
+		res.Users = append(res.Users, userMessage(user))
+	}
+	if next != nil {
+		res.NextPageToken = base64.RawURLEncoding.EncodeToString(next)
+	}
+	return res, nil
+}
+
+// userMessage returns the User message of the user
+func userMessage(user *data.User) *userv1.User {
+	return &userv1.User{Id: user.ID, Username: user.Username, FirstName: user.FirstName, LastName: user.LastName}
+}
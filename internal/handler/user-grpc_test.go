@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"context"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	userv1 "sceyt_task/api/user/v1"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"testing"
+	"time"
+)
+
+// newTestGRPCConn serves the user service like the gRPC listener, with the health and the
+// reflection services, and returns a grpc-go client connection to it. The options run after
+// InterceptRequestID.
+func newTestGRPCConn(t *testing.T, service userv1.UserServiceServer, healthServer *health.Server, options ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(append(ServerOptions(InterceptRequestID(testLogger())), options...)...)
+	userv1.RegisterUserServiceServer(server, service)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// newTestUserClient returns a client of a user service with the repository and the API keys
+func newTestUserClient(t *testing.T, repo repository.UserRepository, keys repository.APIKeyRepository) userv1.UserServiceClient {
+	t.Helper()
+	authenticator := NewAuthenticator(testLogger(), keys, nil, nil, testAdminToken, time.Minute)
+	service := NewUserRPCService(newTestUserHandler(repo, newFakeUserCache()), authenticator)
+	return userv1.NewUserServiceClient(newTestGRPCConn(t, service, health.NewServer(), ServerOptions(InterceptAccessLog(testLogger()))...))
+}
+
+// withCredentials returns the context of a call sending the metadata
+func withCredentials(kv ...string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), kv...)
+}
+
+func TestUserServiceOverGRPC(t *testing.T) {
+	repo := newFakeUserRepository()
+	client := newTestUserClient(t, repo, newFakeAPIKeyRepository())
+	ctx := withCredentials(config.AdminTokenHeader, testAdminToken)
+
+	var header metadata.MD
+	added, err := client.AddUser(ctx, &userv1.AddUserRequest{Username: "alice", FirstName: "Alice", LastName: "Smith"}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.GetId() == "" || added.GetUsername() != "alice" || repo.user("alice") == nil {
+		t.Fatalf("added = %v", added)
+	}
+	if ids := header.Get(config.RequestIDHeader); len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("x-request-id = %v, want a generated id", ids)
+	}
+
+	// the fields left out are not changed
+	last := "Jones"
+	updated, err := client.UpdateUser(ctx, &userv1.UpdateUserRequest{Username: "alice", LastName: &last})
+	if err != nil || updated.GetFirstName() != "Alice" || updated.GetLastName() != "Jones" {
+		t.Fatalf("updated = %v, %v", updated, err)
+	}
+	got, err := client.GetUser(withCredentials(config.AdminTokenHeader, testAdminToken, config.RequestIDHeader, "req-1"),
+		&userv1.GetUserRequest{Username: "alice"}, grpc.Header(&header))
+	if err != nil || !proto.Equal(got, updated) {
+		t.Fatalf("got = %v, %v, want %v", got, err, updated)
+	}
+	if ids := header.Get(config.RequestIDHeader); len(ids) != 1 || ids[0] != "req-1" {
+		t.Fatalf("x-request-id = %v, want the id of the call", ids)
+	}
+
+	for _, name := range []string{"bob", "carol"} {
+		if _, err = client.AddUser(ctx, &userv1.AddUserRequest{Username: name, FirstName: "F", LastName: "L"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var listed []string
+	req := &userv1.ListUsersRequest{PageSize: 2}
+	for pages := 0; ; pages++ {
+		page, err := client.ListUsers(ctx, req)
+		if err != nil || pages > 2 {
+			t.Fatalf("page %d = %v, %v", pages, page, err)
+		}
+		for _, u := range page.GetUsers() {
+			listed = append(listed, u.GetUsername())
+		}
+		if page.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = page.GetNextPageToken()
+	}
+	if len(listed) != 3 || listed[0] != "alice" || listed[2] != "carol" {
+		t.Fatalf("listed = %v", listed)
+	}
+
+	if _, err = client.DeleteUser(ctx, &userv1.DeleteUserRequest{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.GetUser(ctx, &userv1.GetUserRequest{Username: "alice"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("get of the deleted user = %v, want NotFound", err)
+	}
+}
+
+func TestUserServiceStatusCodes(t *testing.T) {
+	repo := newFakeUserRepository(&data.User{ID: "1", Username: "alice", FirstName: "Alice", LastName: "Smith"})
+	keys := newFakeAPIKeyRepository()
+	readKey := keys.add(data.APIKey{ID: "reader", Scopes: []string{data.ScopeRead}}, "secret")
+	writeKey := keys.add(data.APIKey{ID: "writer", Scopes: []string{data.ScopeRead, data.ScopeWrite}}, "secret")
+	client := newTestUserClient(t, repo, keys)
+	admin := withCredentials(config.AdminTokenHeader, testAdminToken)
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"no credentials", func() error {
+			_, err := client.GetUser(context.Background(), &userv1.GetUserRequest{Username: "alice"})
+			return err
+		}, codes.Unauthenticated},
+		{"invalid api key", func() error {
+			_, err := client.GetUser(withCredentials(config.APIKeyHeader, "nope"), &userv1.GetUserRequest{Username: "alice"})
+			return err
+		}, codes.Unauthenticated},
+		{"wrong admin token", func() error {
+			_, err := client.GetUser(withCredentials(config.AdminTokenHeader, "nope"), &userv1.GetUserRequest{Username: "alice"})
+			return err
+		}, codes.Unauthenticated},
+		{"read key", func() error {
+			_, err := client.GetUser(withCredentials(config.APIKeyHeader, readKey), &userv1.GetUserRequest{Username: "alice"})
+			return err
+		}, codes.OK},
+		{"missing scope", func() error {
+			_, err := client.DeleteUser(withCredentials(config.APIKeyHeader, readKey), &userv1.DeleteUserRequest{Username: "alice"})
+			return err
+		}, codes.PermissionDenied},
+		// API keys have the reader role, they may not change users whatever their scopes
+		{"missing permission", func() error {
+			_, err := client.DeleteUser(withCredentials(config.APIKeyHeader, writeKey), &userv1.DeleteUserRequest{Username: "alice"})
+			return err
+		}, codes.PermissionDenied},
+		{"missing last name", func() error {
+			_, err := client.AddUser(admin, &userv1.AddUserRequest{Username: "bob", FirstName: "Bob"})
+			return err
+		}, codes.InvalidArgument},
+		{"missing username", func() error {
+			_, err := client.GetUser(admin, &userv1.GetUserRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"existing user", func() error {
+			_, err := client.AddUser(admin, &userv1.AddUserRequest{Username: "alice", FirstName: "A", LastName: "B"})
+			return err
+		}, codes.AlreadyExists},
+		{"unknown user", func() error {
+			_, err := client.UpdateUser(admin, &userv1.UpdateUserRequest{Username: "nobody"})
+			return err
+		}, codes.NotFound},
+		{"page size too large", func() error {
+			_, err := client.ListUsers(admin, &userv1.ListUsersRequest{PageSize: config.GRPCMaxPageSize + 1})
+			return err
+		}, codes.InvalidArgument},
+		{"negative page size", func() error {
+			_, err := client.ListUsers(admin, &userv1.ListUsersRequest{PageSize: -1})
+			return err
+		}, codes.InvalidArgument},
+		{"invalid page token", func() error {
+			_, err := client.ListUsers(admin, &userv1.ListUsersRequest{PageToken: "%%%"})
+			return err
+		}, codes.InvalidArgument},
+		// listing needs the permission to read every user, readers have it
+		{"list with a read key", func() error {
+			_, err := client.ListUsers(withCredentials(config.APIKeyHeader, readKey), &userv1.ListUsersRequest{})
+			return err
+		}, codes.OK},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); status.Code(err) != tt.code {
+			t.Errorf("%s: %v, want %s", tt.name, err, tt.code)
+		}
+	}
+
+	repo.down = true
+	_, err := client.GetUser(admin, &userv1.GetUserRequest{Username: "bob"})
+	if s := status.Convert(err); s.Code() != codes.Unavailable || s.Message() != "unable to retrieve user from database, please try again later" {
+		t.Fatalf("get while the database is down = %v, want Unavailable", err)
+	}
+}
+
+func TestUserServiceWithBearerTokens(t *testing.T) {
+	verifier, key := newTestVerifier(t)
+	scopes := map[string][]string{"users:read": {data.ScopeRead}, "users:write": {data.ScopeRead, data.ScopeWrite}}
+	authenticator := NewAuthenticator(testLogger(), newFakeAPIKeyRepository(), verifier, scopes, "", time.Minute)
+	repo := newFakeUserRepository(&data.User{ID: "1", Username: "alice"}, &data.User{ID: "2", Username: "bob"})
+	service := NewUserRPCService(newTestUserHandler(repo, newFakeUserCache()), authenticator)
+	client := userv1.NewUserServiceClient(newTestGRPCConn(t, service, health.NewServer()))
+	bearer := func(scope string, roles ...string) context.Context {
+		token := signToken(t, key, map[string]interface{}{"iss": "https://gateway", "aud": "user-server", "sub": "alice",
+			"scope": scope, "roles": roles, "exp": time.Now().Add(time.Hour).Unix()})
+		return withCredentials("authorization", "Bearer "+token)
+	}
+
+	if _, err := client.DeleteUser(bearer("users:write", "reader"), &userv1.DeleteUserRequest{Username: "bob"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("delete by a reader = %v, want PermissionDenied", err)
+	}
+	if _, err := client.DeleteUser(bearer("users:write", "writer"), &userv1.DeleteUserRequest{Username: "bob"}); err != nil {
+		t.Fatalf("delete by a writer = %v", err)
+	}
+	if _, err := client.GetUser(bearer("users:read"), &userv1.GetUserRequest{Username: "alice"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("get without a role = %v, want PermissionDenied", err)
+	}
+	if _, err := client.GetUser(withCredentials("authorization", "Bearer nope"), &userv1.GetUserRequest{Username: "alice"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("get with a malformed token = %v, want Unauthenticated", err)
+	}
+}
+
+func TestUserServiceWhenTheKeyStoreIsDown(t *testing.T) {
+	keys := failingAPIKeyRepository{newFakeAPIKeyRepository()}
+	client := newTestUserClient(t, newFakeUserRepository(), keys)
+
+	_, err := client.GetUser(withCredentials(config.APIKeyHeader, FormatAPIKey("k1", "secret")), &userv1.GetUserRequest{Username: "alice"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("get = %v, want Unavailable", err)
+	}
+}
+
+func TestGRPCHealthFollowsTheReadiness(t *testing.T) {
+	h := NewHealthHandler(testLogger(), time.Second)
+	healthServer := health.NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ReportReadiness(ctx, h, healthServer, 5*time.Millisecond, "", userv1.UserService_ServiceDesc.ServiceName)
+	service := NewUserRPCService(newTestUserHandler(newFakeUserRepository(), newFakeUserCache()), nil)
+	client := healthpb.NewHealthClient(newTestGRPCConn(t, service, healthServer))
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("check of %q = %v", service, err)
+		}
+		return res.GetStatus()
+	}
+	waitFor := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for check(userv1.UserService_ServiceDesc.ServiceName) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("the user service is not %s", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	waitFor(healthpb.HealthCheckResponse_NOT_SERVING)
+	h.MarkReady()
+	waitFor(healthpb.HealthCheckResponse_SERVING)
+	if s := check(""); s != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("server = %s, want SERVING", s)
+	}
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "nope"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("check of an unknown service = %v, want NotFound", err)
+	}
+
+	// watchers are told about the shutdown, the stream interceptors run for them too
+	watch, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := watch.Recv(); err != nil || res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("watch = %v, %v", res, err)
+	}
+	if header, err := watch.Header(); err != nil || len(header.Get(config.RequestIDHeader)) != 1 {
+		t.Fatalf("watch header = %v, want the request id", header)
+	}
+	h.MarkShuttingDown()
+	if res, err := watch.Recv(); err != nil || res.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("watch during the shutdown = %v, %v", res, err)
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	service := NewUserRPCService(newTestUserHandler(newFakeUserRepository(), newFakeUserCache()), nil)
+	client := reflectionpb.NewServerReflectionClient(newTestGRPCConn(t, service, health.NewServer()))
+	stream, err := client.ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.CloseSend()
+	ask := func(req *reflectionpb.ServerReflectionRequest) *reflectionpb.ServerReflectionResponse {
+		t.Helper()
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	listed := map[string]bool{}
+	res := ask(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+	for _, s := range res.GetListServicesResponse().GetService() {
+		listed[s.GetName()] = true
+	}
+	if !listed["user.v1.UserService"] || !listed["grpc.health.v1.Health"] {
+		t.Fatalf("services = %v", listed)
+	}
+	res = ask(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{
+		FileContainingSymbol: "user.v1.UserService"}})
+	if files := res.GetFileDescriptorResponse().GetFileDescriptorProto(); len(files) == 0 {
+		t.Fatalf("file of user.v1.UserService = %v", res)
+	}
+}
+
+func TestInterceptTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	service := NewUserRPCService(newTestUserHandler(newFakeUserRepository(), newFakeUserCache()),
+		NewAuthenticator(testLogger(), newFakeAPIKeyRepository(), nil, nil, testAdminToken, time.Minute))
+	options := append(ServerOptions(InterceptTracing(testLogger())), ServerTracing(provider))
+	client := userv1.NewUserServiceClient(newTestGRPCConn(t, service, health.NewServer(), options...))
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	_, err := client.GetUser(withCredentials(config.AdminTokenHeader, testAdminToken, "traceparent", parent,
+		config.RequestIDHeader, "req-1"), &userv1.GetUserRequest{Username: "nobody"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("get = %v, want NotFound", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans ended, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "user.v1.UserService/GetUser" || span.SpanKind() != trace.SpanKindServer ||
+		span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("span = %+v, want it to continue the trace of the caller", span)
+	}
+	if spanAttribute(span, "rpc.service").AsString() != "user.v1.UserService" || spanAttribute(span, "rpc.method").AsString() != "GetUser" ||
+		spanAttribute(span, "rpc.grpc.status_code").AsInt64() != int64(codes.NotFound) || spanAttribute(span, "request_id").AsString() != "req-1" {
+		t.Fatalf("attributes = %v", span.Attributes())
+	}
+	// a missing user is a failure of the request, not of the server
+	if span.Status().Code == otelcodes.Error {
+		t.Fatalf("status = %v, want no error", span.Status())
+	}
+}
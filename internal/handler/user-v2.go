@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sceyt_task/internal/config"
+	"sceyt_task/internal/data"
+	"sceyt_task/internal/repository"
+	"strings"
+)
+
+// UserResource is the representation of a user in the v2 API
+type UserResource struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+}
+
+func newUserResource(user *data.User) *UserResource {
+	return &UserResource{ID: user.ID, Username: user.Username, FirstName: user.FirstName, LastName: user.LastName}
+}
+
+// RoutesV2 registers the RESTful user resource, the guard checks the scope of every
+// route before the middlewares run
+func (u *UserHandler) RoutesV2(engine *gin.Engine, guard RouteGuard, middlewares ...gin.HandlerFunc) {
+	users := engine.Group(config.V2UsersPath)
+	{
+		users.POST("", route(guard, data.ScopeWrite, middlewares, u.CreateUser)...)
+		users.GET(config.V2UserPath, route(guard, data.ScopeRead, middlewares, u.GetUser)...)
+		users.PUT(config.V2UserPath, route(guard, data.ScopeWrite, middlewares, u.ReplaceUser)...)
+		users.PATCH(config.V2UserPath, route(guard, data.ScopeWrite, middlewares, u.PatchUser)...)
+		users.DELETE(config.V2UserPath, route(guard, data.ScopeWrite, middlewares, u.DeleteUser)...)
+	}
+}
+
+// userLocation returns the URL of the user resource
+func userLocation(username string) string {
+	return strings.TrimSuffix(config.V2UsersPath, "/") + "/" + url.PathEscape(username)
+}
+
+// writeJSON writes the status code and the body
+func writeJSON(ctx *gin.Context, status int, body interface{}) {
+	ctx.Header("Content-Type", "application/json")
+	ctx.AbortWithStatus(status)
+	_ = data.ToJSON(body, ctx.Writer)
+}
+
+// pathUser checks that the username in the body, if any, matches the path
+func pathUser(ctx *gin.Context, username string) bool {
+	if username != "" && username != ctx.Param("username") {
+		abortWithProblem(ctx, CodeInvalidBody, "username in body does not match the path")
+		return false
+	}
+	return true
+}
+
+// CreateUser creates a user
+// @Summary create user
+// @Tags users v2
+// @Description create a user, the response points to the new resource
+// @ID v2-user-create
+// @Security APIKey
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param input body CreateUserRequest true "user"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
+// @Success 201 {object} UserResource
+// @Header 201 {string} Location "URL of the created user"
+// @Failure 400,401,403,409,422,500 {object} Problem
+// @Router /v2/users [post]
+func (u *UserHandler) CreateUser(ctx *gin.Context) {
+	req := &CreateUserRequest{}
+	if !u.bindRequest(ctx, req) {
+		return
+	}
+	user := req.User()
+	if !u.policy.authorize(ctx, ActionCreate, user.Username) {
+		return
+	}
+
+	err := u.repo.Create(ctx.Request.Context(), user)
+	if err == repository.ErrUserExists {
+		abortWithProblem(ctx, CodeUserExists, ErrUserExists)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error while adding user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while adding user")
+		return
+	}
+	ctx.Header("Location", userLocation(user.Username))
+	writeJSON(ctx, http.StatusCreated, newUserResource(user))
+}
+
+// GetUser returns a user
+// @Summary get user
+// @Tags users v2
+// @Description get a user by username
+// @ID v2-user-get
+// @Security APIKey
+// @Security Bearer
+// @Produce json
+// @Param username path string true "username"
+// @Success 200 {object} UserResource
+// @Failure 401,403,404,500 {object} Problem
+// @Router /v2/users/{username} [get]
+func (u *UserHandler) GetUser(ctx *gin.Context) {
+	if !u.policy.authorize(ctx, ActionRead, ctx.Param("username")) {
+		return
+	}
+	user, err := u.loadUser(ctx.Request.Context(), ctx.Param("username"))
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error fetching the user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "unable to retrieve user from database, please try again later")
+		return
+	}
+	writeJSON(ctx, http.StatusOK, newUserResource(user))
+}
+
+// ReplaceUser replaces all fields of a user
+// @Summary replace user
+// @Tags users v2
+// @Description replace the first and last name of a user
+// @ID v2-user-replace
+// @Security APIKey
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param username path string true "username"
+// @Param input body ReplaceUserRequest true "user"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
+// @Success 200 {object} UserResource
+// @Failure 400,401,403,404,409,422,500 {object} Problem
+// @Router /v2/users/{username} [put]
+func (u *UserHandler) ReplaceUser(ctx *gin.Context) {
+	if !u.policy.authorize(ctx, ActionUpdate, ctx.Param("username")) {
+		return
+	}
+	req := &ReplaceUserRequest{}
+	if !u.bindRequest(ctx, req) || !pathUser(ctx, req.Username) {
+		return
+	}
+	u.update(ctx, req.User(ctx.Param("username")))
+}
+
+// PatchUser changes the given fields of a user
+// @Summary patch user
+// @Tags users v2
+// @Description change a user with a JSON Merge Patch (RFC 7396, application/merge-patch+json)
+// @Description or a JSON Patch (RFC 6902, application/json-patch+json), only the changed fields are written
+// @ID v2-user-patch
+// @Security APIKey
+// @Security Bearer
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param username path string true "username"
+// @Param input body object true "patch document"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
+// @Success 200 {object} UserResource
+// @Failure 400,401,403,404,409,415,422,500 {object} Problem
+// @Router /v2/users/{username} [patch]
+func (u *UserHandler) PatchUser(ctx *gin.Context) {
+	if !u.policy.authorize(ctx, ActionUpdate, ctx.Param("username")) {
+		return
+	}
+	var apply func(doc []byte, patch []byte) ([]byte, error)
+	switch ctx.ContentType() {
+	case config.MergePatchContentType, gin.MIMEJSON:
+		apply = data.MergePatch
+	case config.JSONPatchContentType:
+		apply = data.JSONPatch
+	default:
+		abortWithProblem(ctx, CodeUnsupportedMediaType, "content type must be "+config.MergePatchContentType+" or "+config.JSONPatchContentType)
+		return
+	}
+	patch, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		abortWithProblem(ctx, CodeInvalidBody, err.Error())
+		return
+	}
+
+	// the current state is read from the database, the cache may lag behind
+	current, err := u.repo.GetUserByUserName(ctx.Request.Context(), ctx.Param("username"))
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error fetching the user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "unable to retrieve user from database, please try again later")
+		return
+	}
+
+	doc, _ := json.Marshal(newUserResource(current))
+	doc, err = apply(doc, patch)
+	if err != nil {
+		abortWithProblem(ctx, CodeInvalidPatch, err.Error())
+		return
+	}
+	patched := &UserResource{}
+	if err = decodeStrictBytes(doc, patched); err != nil {
+		abortWithProblem(ctx, CodeInvalidPatch, err.Error())
+		return
+	}
+	if patched.ID != current.ID || patched.Username != current.Username {
+		abortWithProblem(ctx, CodeInvalidPatch, "id and username can not be changed")
+		return
+	}
+
+	// validation is applied to the merged user, not to the patch document
+	user := *current
+	user.FirstName, user.LastName = patched.FirstName, patched.LastName
+	if errs := u.validator.Validate(&user); len(errs) != 0 {
+		abortWithValidationErrors(ctx, u.validator, errs)
+		return
+	}
+
+	changes := data.UserChanges{}
+	if user.FirstName != current.FirstName {
+		changes.FirstName = &user.FirstName
+	}
+	if user.LastName != current.LastName {
+		changes.LastName = &user.LastName
+	}
+	if changes.Empty() {
+		writeJSON(ctx, http.StatusOK, newUserResource(&user))
+		return
+	}
+	u.patch(ctx, &user, changes)
+}
+
+// patch writes the changed fields of the user and answers with the new representation
+func (u *UserHandler) patch(ctx *gin.Context, user *data.User, changes data.UserChanges) {
+	err := u.repo.Patch(ctx.Request.Context(), user.Username, changes)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error while updating user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while updating user")
+		return
+	}
+	u.invalidate(ctx.Request.Context(), user.Username)
+	writeJSON(ctx, http.StatusOK, newUserResource(user))
+}
+
+// update writes the user and answers with the new representation
+func (u *UserHandler) update(ctx *gin.Context, user *data.User) {
+	err := u.repo.Update(ctx.Request.Context(), user)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error while updating user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while updating user")
+		return
+	}
+	u.invalidate(ctx.Request.Context(), user.Username)
+	writeJSON(ctx, http.StatusOK, newUserResource(user))
+}
+
+// DeleteUser deletes a user
+// @Summary delete user
+// @Tags users v2
+// @Description delete a user by username
+// @ID v2-user-delete
+// @Security APIKey
+// @Security Bearer
+// @Param username path string true "username"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
+// @Success 204
+// @Failure 401,403,404,409,422,500 {object} Problem
+// @Router /v2/users/{username} [delete]
+func (u *UserHandler) DeleteUser(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if !u.policy.authorize(ctx, ActionDelete, username) {
+		return
+	}
+
+	err := u.repo.Delete(ctx.Request.Context(), username)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error when deleting user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error when deleting user")
+		return
+	}
+	u.invalidate(ctx.Request.Context(), username)
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
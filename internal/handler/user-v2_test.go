@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+func TestV2UserResource(t *testing.T) {
+	repo := newFakeUserRepository(&data.User{ID: "id-alice", Username: "alice", FirstName: "Alice", LastName: "Smith"})
+	router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+	res := do(t, router, http.MethodPost, "/v2/users", `{"username":"bob","firstname":"Bob","lastname":"Jones"}`, principalHeader, writer)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s, want 201", res.Code, res.Body)
+	}
+	if location := res.Header().Get("Location"); location != "/v2/users/bob" {
+		t.Errorf("Location = %q, want /v2/users/bob", location)
+	}
+	var created UserResource
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created != (UserResource{ID: "id-bob", Username: "bob", FirstName: "Bob", LastName: "Jones"}) {
+		t.Errorf("created = %+v", created)
+	}
+
+	res = do(t, router, http.MethodGet, "/v2/users/alice", "", principalHeader, writer)
+	var got UserResource
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil || res.Code != http.StatusOK || got.FirstName != "Alice" {
+		t.Fatalf("get = %d %s", res.Code, res.Body)
+	}
+
+	res = do(t, router, http.MethodPut, "/v2/users/alice", `{"firstname":"Al","lastname":"Jones"}`, principalHeader, writer)
+	if res.Code != http.StatusOK {
+		t.Fatalf("replace = %d %s", res.Code, res.Body)
+	}
+	// Location is only sent for the created resource
+	if location := res.Header().Get("Location"); location != "" {
+		t.Errorf("Location = %q on the replace", location)
+	}
+	if user := repo.user("alice"); user.FirstName != "Al" || user.LastName != "Jones" {
+		t.Errorf("stored user = %+v after the replace", user)
+	}
+
+	res = do(t, router, http.MethodDelete, "/v2/users/alice", "", principalHeader, writer)
+	if res.Code != http.StatusNoContent || res.Body.Len() != 0 {
+		t.Fatalf("delete = %d %s, want 204 without body", res.Code, res.Body)
+	}
+	if repo.user("alice") != nil {
+		t.Error("alice was not deleted")
+	}
+}
+
+func TestV2UserResourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   ErrorCode
+	}{
+		{"get missing user", http.MethodGet, "/v2/users/nobody", "", http.StatusNotFound, CodeUserNotFound},
+		{"create existing user", http.MethodPost, "/v2/users", `{"username":"alice","firstname":"A","lastname":"B"}`, http.StatusConflict, CodeUserExists},
+		{"create without names", http.MethodPost, "/v2/users", `{"username":"carol"}`, http.StatusBadRequest, CodeValidationFailed},
+		{"replace missing user", http.MethodPut, "/v2/users/nobody", `{"firstname":"A","lastname":"B"}`, http.StatusNotFound, CodeUserNotFound},
+		{"replace with other username", http.MethodPut, "/v2/users/alice", `{"username":"bob","firstname":"A","lastname":"B"}`, http.StatusBadRequest, CodeInvalidBody},
+		{"replace with unknown field", http.MethodPut, "/v2/users/alice", `{"firstname":"A","lastname":"B","age":3}`, http.StatusBadRequest, CodeInvalidBody},
+		{"delete missing user", http.MethodDelete, "/v2/users/nobody", "", http.StatusNotFound, CodeUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"})
+			router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+			res := do(t, router, tt.method, tt.path, tt.body, principalHeader, writer)
+			if res.Code != tt.status || problemCode(t, res) != tt.code {
+				t.Fatalf("response = %d %s, want %d %s", res.Code, res.Body, tt.status, tt.code)
+			}
+			if contentType := res.Header().Get("Content-Type"); contentType != "application/problem+json" {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+		})
+	}
+}
+
+func TestV2WritesNeedTheWriteScope(t *testing.T) {
+	repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"})
+	router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+	if res := do(t, router, http.MethodGet, "/v2/users/alice", "", principalHeader, reader); res.Code != http.StatusOK {
+		t.Fatalf("get = %d %s", res.Code, res.Body)
+	}
+	res := do(t, router, http.MethodDelete, "/v2/users/alice", "", principalHeader, reader)
+	if res.Code != http.StatusForbidden || problemCode(t, res) != CodeForbidden {
+		t.Fatalf("delete = %d %s, want 403", res.Code, res.Body)
+	}
+	if res := do(t, router, http.MethodGet, "/v2/users/alice", ""); res.Code != http.StatusUnauthorized {
+		t.Fatalf("get without credentials = %d, want 401", res.Code)
+	}
+}
+
+func TestV2PatchUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        ErrorCode
+		first, last string
+	}{
+		{"merge patch", "application/merge-patch+json", `{"lastname":"Jones"}`, http.StatusOK, "", "Alice", "Jones"},
+		{"merge patch as json", "application/json", `{"firstname":"Al"}`, http.StatusOK, "", "Al", "Smith"},
+		{"json patch", "application/json-patch+json", `[{"op":"test","path":"/lastname","value":"Smith"},{"op":"replace","path":"/firstname","value":"Al"}]`, http.StatusOK, "", "Al", "Smith"},
+		{"json patch of the root", "application/json-patch+json", `[{"op":"replace","path":"","value":{"id":"id-alice","username":"alice","firstname":"A","lastname":"B"}}]`, http.StatusOK, "", "A", "B"},
+		{"no change", "application/merge-patch+json", `{}`, http.StatusOK, "", "Alice", "Smith"},
+		{"unsupported content type", "text/plain", `lastname=Jones`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Alice", "Smith"},
+		{"failed json patch test", "application/json-patch+json", `[{"op":"test","path":"/lastname","value":"Jones"}]`, http.StatusBadRequest, CodeInvalidPatch, "Alice", "Smith"},
+		{"username change", "application/merge-patch+json", `{"username":"bob"}`, http.StatusBadRequest, CodeInvalidPatch, "Alice", "Smith"},
+		{"unknown field", "application/merge-patch+json", `{"age":3}`, http.StatusBadRequest, CodeInvalidPatch, "Alice", "Smith"},
+		{"null clears a field", "application/merge-patch+json", `{"firstname":null}`, http.StatusOK, "", "", "Smith"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(&data.User{ID: "id-alice", Username: "alice", FirstName: "Alice", LastName: "Smith"})
+			router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+			res := do(t, router, http.MethodPatch, "/v2/users/alice", tt.body, principalHeader, writer, "Content-Type", tt.contentType)
+			if res.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", res.Code, res.Body, tt.status)
+			}
+			if tt.code != "" && problemCode(t, res) != tt.code {
+				t.Fatalf("code = %s, want %s", problemCode(t, res), tt.code)
+			}
+			if tt.code == "" {
+				var got UserResource
+				if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil || got.FirstName != tt.first || got.LastName != tt.last {
+					t.Errorf("response = %s, want %s %s", res.Body, tt.first, tt.last)
+				}
+			}
+			if user := repo.user("alice"); user.FirstName != tt.first || user.LastName != tt.last {
+				t.Errorf("stored user = %+v, want %s %s", user, tt.first, tt.last)
+			}
+		})
+	}
+}
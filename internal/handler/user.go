@@ -1,7 +1,7 @@
 package handler
 
 import (
-	"fmt"
+	"context"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"sceyt_task/internal/cache"
@@ -10,16 +10,15 @@ import (
 	"sceyt_task/internal/repository"
 	"sceyt_task/internal/validation"
 	"sceyt_task/pkg/logging"
-	"strings"
 )
 
 var (
-	ErrUserNotFound = fmt.Sprintf("No user account exists with given email. Please sign in first")
-	PgNoRowsMsg     = "no rows in result set"
+	ErrUserNotFound = "no user exists with the given username"
+	ErrUserExists   = "a user with the given username already exists"
 )
 
-// UserKey is used as a key for storing the User object in context at middleware
-type UserKey struct{}
+// RequestKey is used as a key for storing the decoded request in context at middleware
+type RequestKey struct{}
 
 // UserHandler wraps instances needed to perform operations on user object
 type UserHandler struct {
@@ -27,29 +26,34 @@ type UserHandler struct {
 	validator *validation.Validation
 	repo      repository.UserRepository
 	userCache cache.UserCache
+	policy    *Policy
 }
 
 // NewUserHandler returns a new UserHandler instance
-func NewUserHandler(l logging.Logger, v *validation.Validation, r repository.UserRepository, cache cache.UserCache) *UserHandler {
+func NewUserHandler(l logging.Logger, v *validation.Validation, r repository.UserRepository, cache cache.UserCache, p *Policy) *UserHandler {
 	return &UserHandler{
 		logger:    l,
 		validator: v,
 		repo:      r,
 		userCache: cache,
+		policy:    p,
 	}
 }
 
-func (u *UserHandler) Routes(engine *gin.Engine) {
+// Routes registers the legacy user routes, the guard checks the scope of every route
+// before the middlewares run
+func (u *UserHandler) Routes(engine *gin.Engine, guard RouteGuard, middlewares ...gin.HandlerFunc) {
 	user := engine.Group(config.GroupPath)
 	{
-		user.Use(u.MiddlewareValidateUser)
-		user.POST(config.AddPath, u.Add)
-		user.POST(config.UpdatePath, u.Update)
-		user.POST(config.SearchPath, u.Search)
-		user.DELETE(config.DeletePath, u.Delete)
-
+		user.POST(config.AddPath, route(guard, data.ScopeWrite, middlewares,
+			u.MiddlewareValidateRequest(func() interface{} { return &CreateUserRequest{} }), u.Add)...)
+		user.POST(config.UpdatePath, route(guard, data.ScopeWrite, middlewares,
+			u.MiddlewareValidateRequest(func() interface{} { return &UpdateUserRequest{} }), u.Update)...)
+		user.POST(config.SearchPath, route(guard, data.ScopeRead, middlewares,
+			u.MiddlewareValidateRequest(func() interface{} { return &UsernameRequest{} }), u.Search)...)
+		user.DELETE(config.DeletePath, route(guard, data.ScopeWrite, middlewares,
+			u.MiddlewareValidateRequest(func() interface{} { return &UsernameRequest{} }), u.Delete)...)
 	}
-
 }
 
 // GenericResponse is the format of our response
@@ -59,11 +63,6 @@ type GenericResponse struct {
 	Data    interface{} `json:"data"`
 }
 
-// ValidationError is a collection of validation error messages
-type ValidationError struct {
-	Errors []string `json:"errors"`
-}
-
 type SearchResponse struct {
 	ID        string `json:"id"`
 	Username  string `json:"username" validate:"required"`
@@ -80,21 +79,30 @@ type AddResponse struct {
 // @Tags user
 // @Description add user
 // @ID user-add
+// @Security APIKey
+// @Security Bearer
 // @Accept json
 // @Produce json
-// @Param input body swagger.UserAddUpdate true "add user"
+// @Param input body CreateUserRequest true "add user"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
 // @Success 200 {integer} integer 1
-// @Failure 400,404,500 {integer} integer 2
+// @Failure 400,401,403,404,409,422,500 {object} Problem
 // @Router /add/ [post]
 func (u *UserHandler) Add(ctx *gin.Context) {
 	ctx.Set("Content-Type", "application/json")
-	reqUser := ctx.Request.Context().Value(UserKey{}).(data.User)
+	reqUser := ctx.Request.Context().Value(RequestKey{}).(*CreateUserRequest).User()
+	if !u.policy.authorize(ctx, ActionCreate, reqUser.Username) {
+		return
+	}
 
-	err := u.repo.Create(&reqUser)
+	err := u.repo.Create(ctx.Request.Context(), reqUser)
+	if err == repository.ErrUserExists {
+		abortWithProblem(ctx, CodeUserExists, ErrUserExists)
+		return
+	}
 	if err != nil {
-		u.logger.Error("error while adding user", "error", err)
-		ctx.AbortWithStatus(http.StatusInternalServerError)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: "error while adding user"}, ctx.Writer)
+		requestLogger(ctx, u.logger).Error("error while adding user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while adding user")
 		return
 	}
 	ctx.AbortWithStatus(http.StatusOK)
@@ -110,24 +118,60 @@ func (u *UserHandler) Add(ctx *gin.Context) {
 // @Tags user
 // @Description update user
 // @ID user-update
+// @Security APIKey
+// @Security Bearer
 // @Accept json
 // @Produce json
-// @Param input body swagger.UserAddUpdate true "update user"
+// @Param input body UpdateUserRequest true "update user"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
 // @Success 200 {integer} integer 1
-// @Failure 400,404,500 {integer} integer 2
+// @Failure 400,401,403,404,409,422,500 {object} Problem
 // @Router /update/ [post]
 func (u *UserHandler) Update(ctx *gin.Context) {
 	ctx.Set("Content-Type", "application/json")
-	reqUser := ctx.Request.Context().Value(UserKey{}).(data.User)
+	reqUser := ctx.Request.Context().Value(RequestKey{}).(*UpdateUserRequest)
+	if !u.policy.authorize(ctx, ActionUpdate, reqUser.Username) {
+		return
+	}
+
+	// the current state is read from the database, the cache may lag behind
+	current, err := u.repo.GetUserByUserName(ctx.Request.Context(), reqUser.Username)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error fetching the user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "unable to retrieve user from database, please try again later")
+		return
+	}
+
+	// the body is applied as a merge patch, fields that are not sent stay unchanged and
+	// validation is applied to the merged user like PatchUser does
+	changes := reqUser.Changes()
+	user := *current
+	if changes.FirstName != nil {
+		user.FirstName = *changes.FirstName
+	}
+	if changes.LastName != nil {
+		user.LastName = *changes.LastName
+	}
+	if errs := u.validator.Validate(&user); len(errs) != 0 {
+		abortWithValidationErrors(ctx, u.validator, errs)
+		return
+	}
 
-	_ = u.userCache.Del(reqUser.Username)
-	err := u.repo.Update(&reqUser)
+	err = u.repo.Patch(ctx.Request.Context(), reqUser.Username, changes)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
 	if err != nil {
-		u.logger.Error("error while updating user", "error", err)
-		ctx.AbortWithStatus(http.StatusInternalServerError)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: "error while updating user"}, ctx.Writer)
+		requestLogger(ctx, u.logger).Error("error while updating user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error while updating user")
 		return
 	}
+	u.invalidate(ctx.Request.Context(), reqUser.Username)
 	ctx.AbortWithStatus(http.StatusOK)
 	_ = data.ToJSON(&GenericResponse{
 		Status:  true,
@@ -141,24 +185,33 @@ func (u *UserHandler) Update(ctx *gin.Context) {
 // @Tags user
 // @Description delete user
 // @ID user-delete
+// @Security APIKey
+// @Security Bearer
 // @Accept json
 // @Produce json
-// @Param input body swagger.UserSearchDelete true "delete user"
+// @Param input body UsernameRequest true "delete user"
+// @Param Idempotency-Key header string false "makes retries of the request safe"
 // @Success 200 {integer} integer 1
-// @Failure 400,404,500 {integer} integer 2
+// @Failure 400,401,403,404,409,422,500 {object} Problem
 // @Router /delete/ [delete]
 func (u *UserHandler) Delete(ctx *gin.Context) {
 	ctx.Set("Content-Type", "application/json")
-	reqUser := ctx.Request.Context().Value(UserKey{}).(data.User)
+	reqUser := ctx.Request.Context().Value(RequestKey{}).(*UsernameRequest)
+	if !u.policy.authorize(ctx, ActionDelete, reqUser.Username) {
+		return
+	}
 
-	_ = u.userCache.Del(reqUser.Username)
-	err := u.repo.Delete(reqUser.Username)
+	err := u.repo.Delete(ctx.Request.Context(), reqUser.Username)
+	if err == repository.ErrUserNotFound {
+		abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		return
+	}
 	if err != nil {
-		u.logger.Error("error when deleting user", "error", err)
-		ctx.AbortWithStatus(http.StatusInternalServerError)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: "error when deleting user"}, ctx.Writer)
+		requestLogger(ctx, u.logger).Error("error when deleting user", "error", err)
+		abortWithProblem(ctx, CodeDatabaseError, "error when deleting user")
 		return
 	}
+	u.invalidate(ctx.Request.Context(), reqUser.Username)
 	ctx.AbortWithStatus(http.StatusOK)
 	_ = data.ToJSON(&GenericResponse{
 		Status:  true,
@@ -172,42 +225,31 @@ func (u *UserHandler) Delete(ctx *gin.Context) {
 // @Tags user
 // @Description user search
 // @ID user-search
+// @Security APIKey
+// @Security Bearer
 // @Accept json
 // @Produce json
-// @Param input body swagger.UserSearchDelete true "user search"
+// @Param input body UsernameRequest true "user search"
 // @Success 200 {integer} integer 1
-// @Failure 400,404,500 {integer} integer 2
+// @Failure 400,401,403,404,409,500 {object} Problem
 // @Router /search/ [post]
 func (u *UserHandler) Search(ctx *gin.Context) {
 	ctx.Set("Content-Type", "application/json")
 
-	reqUser := ctx.Request.Context().Value(UserKey{}).(data.User)
-
-	user, err := u.userCache.Get(reqUser.Username)
-	if err != nil {
-		u.logger.Error("error fetching the user", "error", err)
-		ctx.AbortWithStatus(http.StatusInternalServerError)
-		_ = data.ToJSON(&GenericResponse{Status: false, Message: "An error occured while getting the user from Redis. Please try again later."}, ctx.Writer)
+	reqUser := ctx.Request.Context().Value(RequestKey{}).(*UsernameRequest)
+	if !u.policy.authorize(ctx, ActionRead, reqUser.Username) {
 		return
 	}
-	if user == nil {
-		user, err = u.repo.GetUserByUserName(reqUser.Username)
-		if err != nil {
-			u.logger.Error("error fetching the user", "error", err)
-			errMsg := err.Error()
-			if strings.Contains(errMsg, PgNoRowsMsg) {
-				ctx.AbortWithStatus(http.StatusBadRequest)
-				_ = data.ToJSON(&GenericResponse{Status: false, Message: ErrUserNotFound}, ctx.Writer)
-			} else {
-				ctx.AbortWithStatus(http.StatusInternalServerError)
-				_ = data.ToJSON(&GenericResponse{Status: false, Message: "Unable to retrieve user from database.Please try again later"}, ctx.Writer)
-			}
-			return
-		}
-		err = u.userCache.Set(user.Username, user)
-		if err != nil {
-			u.logger.Error("error while adding user to Redis", "error", err)
+
+	user, err := u.loadUser(ctx.Request.Context(), reqUser.Username)
+	if err != nil {
+		requestLogger(ctx, u.logger).Error("error fetching the user", "error", err)
+		if err == repository.ErrUserNotFound {
+			abortWithProblem(ctx, CodeUserNotFound, ErrUserNotFound)
+		} else {
+			abortWithProblem(ctx, CodeDatabaseError, "unable to retrieve user from database, please try again later")
 		}
+		return
 	}
 
 	ctx.AbortWithStatus(http.StatusOK)
@@ -217,3 +259,46 @@ func (u *UserHandler) Search(ctx *gin.Context) {
 		Data:    &SearchResponse{ID: user.ID, Username: user.Username, FirstName: user.FirstName, LastName: user.LastName},
 	}, ctx.Writer)
 }
+
+// loadUser returns the user from the cache, or from the database when it is not cached
+func (u *UserHandler) loadUser(c context.Context, username string) (*data.User, error) {
+	logger := logging.FromContext(c, u.logger)
+	user, err := u.userCache.Get(c, username)
+	if err != nil {
+		logger.Error("error while getting the user from Redis", "error", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	// the version has to be read before the database, so that an update
+	// committed in between is detected when writing the user back to Redis
+	version, versionErr := u.userCache.Version(c, username)
+	if versionErr != nil && versionErr != cache.ErrCircuitOpen {
+		logger.Error("error while reading cache version", "error", versionErr)
+	}
+	user, err = u.repo.GetUserByUserName(c, username)
+	if err != nil {
+		return nil, err
+	}
+	if versionErr == nil {
+		err = u.userCache.SetIfVersion(c, user.Username, user, version)
+		if err == cache.ErrStaleVersion || err == cache.ErrCircuitOpen {
+			logger.Debug("skipping Redis write", "reason", err)
+		} else if err != nil {
+			logger.Error("error while adding user to Redis", "error", err)
+		}
+	}
+	return user, nil
+}
+
+// invalidate removes the user from the cache after a write. It must only be called
+// after the database write, a concurrent load that read the old row before this
+// point will fail its versioned write to the cache. A failure is only logged, the
+// write is committed and the circuit breaker queues the invalidation until Redis
+// accepts it, reads skip the cache in the meantime.
+func (u *UserHandler) invalidate(ctx context.Context, username string) {
+	if err := u.userCache.Invalidate(ctx, username); err != nil {
+		logging.FromContext(ctx, u.logger).Error("error while invalidating cached user", "error", err)
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sceyt_task/internal/data"
+	"testing"
+)
+
+func newTestRouter(u *UserHandler) *gin.Engine {
+	router := gin.New()
+	u.Routes(router, testGuard)
+	u.RoutesV2(router, testGuard)
+	return router
+}
+
+func TestWritesInvalidateTheCachedUser(t *testing.T) {
+	repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Old"})
+	userCache := newFakeUserCache()
+	router := newTestRouter(newTestUserHandler(repo, userCache))
+
+	// the first search caches the user
+	res := do(t, router, http.MethodPost, "/search", `{"username":"alice"}`, principalHeader, writer)
+	if res.Code != http.StatusOK {
+		t.Fatalf("search = %d %s", res.Code, res.Body)
+	}
+	if user, _ := userCache.Get(context.Background(), "alice"); user == nil {
+		t.Fatal("the searched user was not cached")
+	}
+
+	res = do(t, router, http.MethodPost, "/update/", `{"username":"alice","lastname":"New"}`, principalHeader, writer)
+	if res.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", res.Code, res.Body)
+	}
+	if user, _ := userCache.Get(context.Background(), "alice"); user != nil {
+		t.Fatalf("cached user = %+v after the update, want it invalidated", user)
+	}
+	if len(userCache.invalidated) != 1 {
+		t.Fatalf("invalidated = %v, want [alice]", userCache.invalidated)
+	}
+}
+
+// a failed invalidation is queued by the circuit breaker, the committed write must
+// not be reported as failed
+func TestWritesSucceedWhenInvalidationFails(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"legacy update", http.MethodPost, "/update/", `{"username":"alice","firstname":"Al"}`, http.StatusOK},
+		{"legacy delete", http.MethodDelete, "/delete/", `{"username":"alice"}`, http.StatusOK},
+		{"v2 replace", http.MethodPut, "/v2/users/alice", `{"firstname":"Al","lastname":"Smith"}`, http.StatusOK},
+		{"v2 patch", http.MethodPatch, "/v2/users/alice", `{"firstname":"Al"}`, http.StatusOK},
+		{"v2 delete", http.MethodDelete, "/v2/users/alice", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"})
+			userCache := newFakeUserCache()
+			userCache.failInvalidate = errors.New("redis is down")
+			router := newTestRouter(newTestUserHandler(repo, userCache))
+
+			headers := []string{principalHeader, writer}
+			if tt.method == http.MethodPatch {
+				headers = append(headers, "Content-Type", "application/merge-patch+json")
+			}
+			res := do(t, router, tt.method, tt.path, tt.body, headers...)
+			if res.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", res.Code, res.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestLegacyRequestsAreDecodedStrictly(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   ErrorCode
+	}{
+		{"add with id", http.MethodPost, "/add/", `{"id":"x","username":"bob","firstname":"Bob","lastname":"Jones"}`, CodeInvalidBody},
+		{"add with deleted_at", http.MethodPost, "/add/", `{"username":"bob","firstname":"Bob","lastname":"Jones","deleted_at":"2021-01-01"}`, CodeInvalidBody},
+		{"add without names", http.MethodPost, "/add/", `{"username":"bob"}`, CodeValidationFailed},
+		{"add with trailing data", http.MethodPost, "/add/", `{"username":"bob","firstname":"Bob","lastname":"Jones"} {}`, CodeInvalidBody},
+		{"add with a trailing brace", http.MethodPost, "/add/", `{"username":"bob","firstname":"Bob","lastname":"Jones"} }`, CodeInvalidBody},
+		{"add with a trailing bracket", http.MethodPost, "/add/", `{"username":"bob","firstname":"Bob","lastname":"Jones"}]`, CodeInvalidBody},
+		{"update with created_at", http.MethodPost, "/update/", `{"username":"alice","created_at":"2021-01-01"}`, CodeInvalidBody},
+		{"update without username", http.MethodPost, "/update/", `{"firstname":"Al"}`, CodeValidationFailed},
+		{"search with unknown field", http.MethodPost, "/search", `{"username":"alice","id":"x"}`, CodeInvalidBody},
+		{"delete without username", http.MethodDelete, "/delete/", `{}`, CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"})
+			router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+			res := do(t, router, tt.method, tt.path, tt.body, principalHeader, writer)
+			if res.Code != http.StatusBadRequest || problemCode(t, res) != tt.code {
+				t.Fatalf("response = %d %s, want 400 %s", res.Code, res.Body, tt.code)
+			}
+			if repo.user("bob") != nil {
+				t.Error("bob was created")
+			}
+			if user := repo.user("alice"); user == nil || user.FirstName != "Alice" || user.LastName != "Smith" {
+				t.Errorf("alice = %+v, want her unchanged", user)
+			}
+		})
+	}
+}
+
+func TestLegacyUpdateMergesTheChanges(t *testing.T) {
+	tests := []struct {
+		body        string
+		first, last string
+	}{
+		{`{"username":"alice","lastname":"Jones"}`, "Alice", "Jones"},
+		{`{"username":"alice","firstname":"Al","lastname":"Jones"}`, "Al", "Jones"},
+		{`{"username":"alice","firstname":null}`, "", "Smith"},
+		{`{"username":"alice"}`, "Alice", "Smith"},
+	}
+	for _, tt := range tests {
+		repo := newFakeUserRepository(&data.User{Username: "alice", FirstName: "Alice", LastName: "Smith"})
+		router := newTestRouter(newTestUserHandler(repo, newFakeUserCache()))
+
+		res := do(t, router, http.MethodPost, "/update/", tt.body, principalHeader, writer)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: update = %d %s", tt.body, res.Code, res.Body)
+		}
+		if user := repo.user("alice"); user.FirstName != tt.first || user.LastName != tt.last {
+			t.Errorf("%s: alice = %+v, want %s %s", tt.body, user, tt.first, tt.last)
+		}
+	}
+}
+
+func TestLegacyUpdateOfAMissingUser(t *testing.T) {
+	router := newTestRouter(newTestUserHandler(newFakeUserRepository(), newFakeUserCache()))
+	res := do(t, router, http.MethodPost, "/update/", `{"username":"nobody","lastname":"Jones"}`, principalHeader, writer)
+	if res.Code != http.StatusNotFound || problemCode(t, res) != CodeUserNotFound {
+		t.Fatalf("update = %d %s, want 404", res.Code, res.Body)
+	}
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/trace"
+	"sceyt_task/internal/data"
+	"sceyt_task/pkg/logging"
+	"time"
+)
+
+var (
+	// ErrAPIKeyNotFound is returned when no API key exists with the given id
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyRevoked is returned when the API key was revoked
+	ErrAPIKeyRevoked = errors.New("api key is revoked")
+)
+
+// APIKeyRepository is an interface for the storage of API keys, only the hash of the secret is stored
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *data.APIKey, secretHash string) error
+	Get(ctx context.Context, id string) (*data.APIKey, string, error)
+	List(ctx context.Context) ([]*data.APIKey, error)
+	Rotate(ctx context.Context, id string, secretHash string) error
+	Revoke(ctx context.Context, id string) error
+}
+
+// apiKeyRepository has the implementation of the db methods.
+type apiKeyRepository struct {
+	session *gocql.Session
+	logger  logging.Logger
+	tracer  trace.Tracer
+}
+
+// NewAPIKeyRepository returns a new apiKeyRepository instance, its queries are traced with tp
+func NewAPIKeyRepository(s *gocql.Session, l logging.Logger, tp trace.TracerProvider) APIKeyRepository {
+	return &apiKeyRepository{s, l, tp.Tracer(tracerName)}
+}
+
+const apiKeyColumns = `id, name, scopes, createdat, rotatedat, expiresat, revokedat, secrethash`
+
+// optionalTime maps the zero time scanned from a null timestamp to nil
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// scanAPIKey reads one row selected with apiKeyColumns
+func scanAPIKey(scan func(dest ...interface{}) error) (*data.APIKey, string, error) {
+	key := &data.APIKey{}
+	var rotatedAt, expiresAt, revokedAt time.Time
+	var secretHash string
+	if err := scan(&key.ID, &key.Name, &key.Scopes, &key.CreatedAt, &rotatedAt, &expiresAt, &revokedAt, &secretHash); err != nil {
+		return nil, "", err
+	}
+	key.RotatedAt, key.ExpiresAt, key.RevokedAt = optionalTime(rotatedAt), optionalTime(expiresAt), optionalTime(revokedAt)
+	return key, secretHash, nil
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *data.APIKey, secretHash string) error {
+	key.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	sqlStr := `INSERT INTO api_keys (id, name, scopes, createdat, expiresat, secrethash) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+
+	var expiresAt interface{}
+	if key.ExpiresAt != nil {
+		expiresAt = *key.ExpiresAt
+	}
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, key.ID, key.Name, key.Scopes, key.CreatedAt, expiresAt, secretHash).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return errors.New("api key id collision")
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) Get(ctx context.Context, id string) (*data.APIKey, string, error) {
+	sqlStr := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ?`
+	key, secretHash, err := scanAPIKey(query(ctx, r.session, r.tracer, sqlStr, id).Scan)
+	if err == gocql.ErrNotFound {
+		return nil, "", ErrAPIKeyNotFound
+	}
+	return key, secretHash, err
+}
+
+func (r *apiKeyRepository) List(ctx context.Context) ([]*data.APIKey, error) {
+	sqlStr := `SELECT ` + apiKeyColumns + ` FROM api_keys`
+	iter := query(ctx, r.session, r.tracer, sqlStr).Iter()
+	scanner := iter.Scanner()
+	keys := []*data.APIKey{}
+	for scanner.Next() {
+		key, _, err := scanAPIKey(scanner.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rotate replaces the secret of a key that is not revoked, the previous secret stops working
+func (r *apiKeyRepository) Rotate(ctx context.Context, id string, secretHash string) error {
+	sqlStr := `UPDATE api_keys SET secrethash = ?, rotatedat = ? WHERE id = ? IF revokedat = null`
+	return r.updateActive(ctx, id, sqlStr, secretHash, time.Now().UTC(), id)
+}
+
+// Revoke marks the key as revoked, revoked keys are kept so they can be listed
+func (r *apiKeyRepository) Revoke(ctx context.Context, id string) error {
+	sqlStr := `UPDATE api_keys SET revokedat = ? WHERE id = ? IF revokedat = null`
+	return r.updateActive(ctx, id, sqlStr, time.Now().UTC(), id)
+}
+
+// updateActive runs a lightweight transaction conditioned on the key not being revoked.
+// The condition revokedat = null is also met when the row does not exist and the update
+// would insert a row for the unknown id, so the key is read first. Keys are never
+// deleted, a key that exists can not disappear before the update.
+func (r *apiKeyRepository) updateActive(ctx context.Context, id string, sqlStr string, values ...interface{}) error {
+	if _, _, err := r.Get(ctx, id); err != nil {
+		return err
+	}
+	existing := map[string]interface{}{}
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, values...).MapScanCAS(existing)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	// the current values are only returned when the row exists
+	if _, ok := existing["revokedat"]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	return ErrAPIKeyRevoked
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"sceyt_task/internal/data"
+	"time"
+)
+
+// queryBuckets are the upper bounds in seconds of the query latency histogram
+var queryBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// instrumentedUserRepository measures the latency and counts the errors of the queries of a UserRepository
+type instrumentedUserRepository struct {
+	next     UserRepository
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewInstrumentedUserRepository returns a UserRepository recording the queries of next in r
+func NewInstrumentedUserRepository(next UserRepository, r prometheus.Registerer) UserRepository {
+	i := &instrumentedUserRepository{
+		next: next,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cassandra_query_duration_seconds",
+			Help:    "Latency of the cassandra queries of the user repository.",
+			Buckets: queryBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cassandra_query_errors_total",
+			Help: "Number of failed cassandra queries of the user repository.",
+		}, []string{"operation"}),
+	}
+	r.MustRegister(i.duration, i.errors)
+	return i
+}
+
+// observe records the query, missing and existing users are answers of the database and not errors
+func (i *instrumentedUserRepository) observe(operation string, start time.Time, err error) error {
+	i.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != ErrUserNotFound && err != ErrUserExists {
+		i.errors.WithLabelValues(operation).Inc()
+	}
+	return err
+}
+
+func (i *instrumentedUserRepository) Create(ctx context.Context, user *data.User) error {
+	start := time.Now()
+	err := i.next.Create(ctx, user)
+	return i.observe("create", start, err)
+}
+
+func (i *instrumentedUserRepository) Update(ctx context.Context, user *data.User) error {
+	start := time.Now()
+	err := i.next.Update(ctx, user)
+	return i.observe("update", start, err)
+}
+
+func (i *instrumentedUserRepository) Patch(ctx context.Context, userName string, changes data.UserChanges) error {
+	start := time.Now()
+	err := i.next.Patch(ctx, userName, changes)
+	return i.observe("patch", start, err)
+}
+
+func (i *instrumentedUserRepository) Delete(ctx context.Context, userName string) error {
+	start := time.Now()
+	err := i.next.Delete(ctx, userName)
+	return i.observe("delete", start, err)
+}
+
+func (i *instrumentedUserRepository) GetUserByUserName(ctx context.Context, userName string) (*data.User, error) {
+	start := time.Now()
+	user, err := i.next.GetUserByUserName(ctx, userName)
+	return user, i.observe("get", start, err)
+}
+
+func (i *instrumentedUserRepository) List(ctx context.Context, limit int, pageState []byte) ([]*data.User, []byte, error) {
+	start := time.Now()
+	users, next, err := i.next.List(ctx, limit, pageState)
+	return users, next, i.observe("list", start, err)
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sceyt_task/internal/data"
+	"strings"
+	"testing"
+)
+
+// stubUserRepository answers every call with err
+type stubUserRepository struct {
+	err error
+}
+
+func (s stubUserRepository) Create(ctx context.Context, user *data.User) error { return s.err }
+func (s stubUserRepository) Update(ctx context.Context, user *data.User) error { return s.err }
+func (s stubUserRepository) Patch(ctx context.Context, userName string, changes data.UserChanges) error {
+	return s.err
+}
+func (s stubUserRepository) Delete(ctx context.Context, userName string) error { return s.err }
+func (s stubUserRepository) GetUserByUserName(ctx context.Context, userName string) (*data.User, error) {
+	return nil, s.err
+}
+func (s stubUserRepository) List(ctx context.Context, limit int, pageState []byte) ([]*data.User, []byte, error) {
+	return nil, nil, s.err
+}
+
+func TestInstrumentedUserRepository(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	stub := &stubUserRepository{}
+	repo := NewInstrumentedUserRepository(stub, registry)
+	ctx := context.Background()
+
+	_, _ = repo.GetUserByUserName(ctx, "alice")
+	stub.err = ErrUserNotFound
+	_, _ = repo.GetUserByUserName(ctx, "bob")
+	stub.err = ErrUserExists
+	_ = repo.Create(ctx, &data.User{})
+	timeout := errors.New("gocql: no response received from cassandra within timeout period")
+	stub.err = timeout
+	if err := repo.Delete(ctx, "alice"); err != timeout {
+		t.Fatalf("Delete() = %v, want the error of the repository", err)
+	}
+	_, _, _ = repo.List(ctx, 10, nil)
+
+	// missing and existing users are answers of the database and not errors
+	want := `
+# HELP cassandra_query_errors_total Number of failed cassandra queries of the user repository.
+# TYPE cassandra_query_errors_total counter
+cassandra_query_errors_total{operation="delete"} 1
+cassandra_query_errors_total{operation="list"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "cassandra_query_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+	// every query is measured, failed or not
+	if n := testutil.CollectAndCount(registry, "cassandra_query_duration_seconds"); n != 4 {
+		t.Fatalf("%d latency series, want get, create, delete and list", n)
+	}
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the query spans
+const tracerName = "sceyt_task/internal/repository"
+
+// queryObserver records a client span for every execution of a query, retries and
+// pages are executions of their own
+type queryObserver struct {
+	tracer trace.Tracer
+	query  *gocql.Query
+}
+
+func (o *queryObserver) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	attributes := []attribute.KeyValue{
+		attribute.String("db.system", "cassandra"),
+		attribute.String("db.statement", q.Statement),
+		attribute.String("db.cassandra.consistency_level", o.query.GetConsistency().String()),
+		attribute.String("db.cassandra.keyspace", q.Keyspace),
+		attribute.Int("db.cassandra.attempt", q.Attempt),
+		attribute.Int("db.cassandra.rows", q.Rows),
+	}
+	if q.Host != nil {
+		attributes = append(attributes, attribute.String("net.peer.name", q.Host.ConnectAddress().String()))
+	}
+	_, span := o.tracer.Start(ctx, "cassandra.query", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(q.Start), trace.WithAttributes(attributes...))
+	if q.Err != nil {
+		span.RecordError(q.Err)
+		span.SetStatus(codes.Error, q.Err.Error())
+	}
+	span.End(trace.WithTimestamp(q.End))
+}
+
+// query returns the statement bound to the context, its executions are traced as
+// children of the span of the context
+func query(ctx context.Context, s *gocql.Session, t trace.Tracer, stmt string, values ...interface{}) *gocql.Query {
+	q := s.Query(stmt, values...).WithContext(ctx)
+	return q.Observer(&queryObserver{tracer: t, query: q})
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+func TestQueryObserverRecordsAnExecution(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+
+	const stmt = `SELECT id FROM users WHERE username = ?`
+	q := (&gocql.Session{}).Query(stmt, "alice").Consistency(gocql.One)
+	observer := &queryObserver{tracer: provider.Tracer(tracerName), query: q}
+	start := time.Now().Add(-time.Second)
+	timeout := errors.New("gocql: no response received from cassandra within timeout period")
+	observer.ObserveQuery(ctx, gocql.ObservedQuery{Keyspace: "users", Statement: stmt, Start: start,
+		End: start.Add(20 * time.Millisecond), Attempt: 2, Err: timeout})
+	parent.End()
+
+	span := recorder.Ended()[0]
+	if span.Name() != "cassandra.query" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("span = %s, want a child of the request span", span.Name())
+	}
+	if !span.StartTime().Equal(start) || span.EndTime().Sub(span.StartTime()) != 20*time.Millisecond {
+		t.Errorf("span lasted from %v to %v, want the times of the execution", span.StartTime(), span.EndTime())
+	}
+	attributes := map[string]string{}
+	for _, a := range span.Attributes() {
+		attributes[string(a.Key)] = a.Value.Emit()
+	}
+	if attributes["db.statement"] != stmt || attributes["db.cassandra.consistency_level"] != "ONE" ||
+		attributes["db.cassandra.attempt"] != "2" || attributes["db.cassandra.keyspace"] != "users" {
+		t.Errorf("attributes = %v", attributes)
+	}
+	if span.Status().Code != codes.Error || span.Status().Description != timeout.Error() {
+		t.Errorf("status = %v, want the error of the execution", span.Status())
+	}
+}
@@ -1,73 +1,179 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"github.com/gocql/gocql"
 	uuid "github.com/satori/go.uuid"
 	"github.com/uniplaces/carbon"
+	"go.opentelemetry.io/otel/trace"
 	"sceyt_task/internal/data"
 	"sceyt_task/pkg/logging"
+	"strings"
 )
 
-// UserRepository is an interface for the storage implementation of the authRepository service
+var (
+	// ErrUserNotFound is returned when no active user exists with the given username
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when an active user with the given username already exists
+	ErrUserExists = errors.New("user already exists")
+)
+
+const (
+	statusActive  = 1
+	statusDeleted = 2
+)
+
+// UserRepository is an interface for the storage implementation of the authRepository service,
+// the context carries the logger of the request and cancels the query
 type UserRepository interface {
-	Create(user *data.User) error
-	Update(user *data.User) error
-	Delete(userName string) error
-	GetUserByUserName(userName string) (*data.User, error)
+	Create(ctx context.Context, user *data.User) error
+	Update(ctx context.Context, user *data.User) error
+	Patch(ctx context.Context, userName string, changes data.UserChanges) error
+	Delete(ctx context.Context, userName string) error
+	GetUserByUserName(ctx context.Context, userName string) (*data.User, error)
+	// List returns a page of at most limit active users, in token order. pageState is nil for
+	// the first page, the returned state is nil after the last page.
+	List(ctx context.Context, limit int, pageState []byte) ([]*data.User, []byte, error)
 }
 
 // userRepository has the implementation of the db methods.
 type userRepository struct {
 	session *gocql.Session
 	logger  logging.Logger
+	tracer  trace.Tracer
 }
 
-// NewUserRepository returns a new userRepository instance
-func NewUserRepository(s *gocql.Session, l logging.Logger) UserRepository {
-	return &userRepository{s, l}
+// NewUserRepository returns a new userRepository instance, its queries are traced with tp
+func NewUserRepository(s *gocql.Session, l logging.Logger, tp trace.TracerProvider) UserRepository {
+	return &userRepository{s, l, tp.Tracer(tracerName)}
 }
 
-func (r *userRepository) Create(user *data.User) error {
+// Create inserts a new user, a deleted user with the same username is replaced.
+// The writes are lightweight transactions, so concurrent creates of the same
+// username can not overwrite each other.
+func (r *userRepository) Create(ctx context.Context, user *data.User) error {
 	user.ID = uuid.NewV4().String()
 	user.CreatedAt = carbon.Now().String()
 	user.UpdatedAt = carbon.Now().String()
 
-	sqlStr := `INSERT INTO users (id, username, firstname, lastname, createdat, updatedat, status) VALUES ( ?, ?, ?, ?, ?, ?, ?)`
+	sqlStr := `INSERT INTO users (id, username, firstname, lastname, createdat, updatedat, status) VALUES ( ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`
 
-	if err := r.session.Query(sqlStr, user.ID, user.Username, user.FirstName, user.LastName, user.CreatedAt, user.UpdatedAt, 1).Exec(); err != nil {
+	existing := map[string]interface{}{}
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, user.ID, user.Username, user.FirstName, user.LastName, user.CreatedAt, user.UpdatedAt, statusActive).MapScanCAS(existing)
+	if err != nil {
 		return err
 	}
+	if applied {
+		return nil
+	}
+	if status, _ := existing["status"].(int); status != statusDeleted {
+		return ErrUserExists
+	}
+
+	sqlStr = `UPDATE users SET id = ?, firstname = ?, lastname = ?, createdat = ?, updatedat = ?, deletedat = null, status = ? WHERE username = ? IF status = ?`
+
+	applied, err = query(ctx, r.session, r.tracer, sqlStr, user.ID, user.FirstName, user.LastName, user.CreatedAt, user.UpdatedAt, statusActive, user.Username, statusDeleted).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrUserExists
+	}
 	return nil
 }
 
-func (r *userRepository) Update(user *data.User) error {
+func (r *userRepository) Update(ctx context.Context, user *data.User) error {
 	user.UpdatedAt = carbon.Now().String()
-	sqlStr := `UPDATE users SET firstname = ?, lastname = ?, updatedat = ? WHERE username = ?`
+	sqlStr := `UPDATE users SET firstname = ?, lastname = ?, updatedat = ? WHERE username = ? IF status = ?`
+
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, user.FirstName, user.LastName, user.UpdatedAt, user.Username, statusActive).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Patch writes only the changed columns, so concurrent patches of different fields do not overwrite each other
+func (r *userRepository) Patch(ctx context.Context, userName string, changes data.UserChanges) error {
+	var columns []string
+	var values []interface{}
+	if changes.FirstName != nil {
+		columns = append(columns, "firstname = ?")
+		values = append(values, *changes.FirstName)
+	}
+	if changes.LastName != nil {
+		columns = append(columns, "lastname = ?")
+		values = append(values, *changes.LastName)
+	}
+	columns = append(columns, "updatedat = ?")
+	values = append(values, carbon.Now().String(), userName, statusActive)
+
+	sqlStr := `UPDATE users SET ` + strings.Join(columns, ", ") + ` WHERE username = ? IF status = ?`
 
-	if err := r.session.Query(sqlStr, user.FirstName, user.LastName, user.UpdatedAt, user.Username).Exec(); err != nil {
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, values...).MapScanCAS(map[string]interface{}{})
+	if err != nil {
 		return err
 	}
+	if !applied {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
-func (r *userRepository) Delete(userName string) error {
+func (r *userRepository) Delete(ctx context.Context, userName string) error {
 	deletedAt := carbon.Now().String()
-	sqlStr := `UPDATE users SET deletedat = ?, status = ? WHERE username = ? `
+	sqlStr := `UPDATE users SET deletedat = ?, status = ? WHERE username = ? IF status = ?`
 
-	if err := r.session.Query(sqlStr, deletedAt, 2, userName).Exec(); err != nil {
+	applied, err := query(ctx, r.session, r.tracer, sqlStr, deletedAt, statusDeleted, userName, statusActive).MapScanCAS(map[string]interface{}{})
+	if err != nil {
 		return err
 	}
+	if !applied {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
-func (r *userRepository) GetUserByUserName(userName string) (*data.User, error) {
-	r.logger.Info("user delivered from database")
-	sqlStr := `SELECT id,username, firstname, lastname FROM users WHERE username = ? and status = 1`
+func (r *userRepository) GetUserByUserName(ctx context.Context, userName string) (*data.User, error) {
+	logging.FromContext(ctx, r.logger).Info("user delivered from database")
+	sqlStr := `SELECT id,username, firstname, lastname FROM users WHERE username = ? and status = ?`
 	user := &data.User{}
-	if err := r.session.Query(sqlStr,
-		userName).Consistency(gocql.One).Scan(&user.ID, &user.Username, &user.FirstName, &user.LastName); err != nil {
+	if err := query(ctx, r.session, r.tracer, sqlStr,
+		userName, statusActive).Consistency(gocql.One).Scan(&user.ID, &user.Username, &user.FirstName, &user.LastName); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 
 	return user, nil
 }
+
+func (r *userRepository) List(ctx context.Context, limit int, pageState []byte) ([]*data.User, []byte, error) {
+	sqlStr := `SELECT id, username, firstname, lastname, status FROM users`
+	// setting the page state disables automatic paging, so only one page is read
+	iter := query(ctx, r.session, r.tracer, sqlStr).PageSize(limit).PageState(pageState).Iter()
+	next := iter.PageState()
+
+	var users []*data.User
+	var status int
+	user := &data.User{}
+	// deleted users are kept with their status, so a page may hold less than limit users
+	for iter.Scan(&user.ID, &user.Username, &user.FirstName, &user.LastName, &status) {
+		if status == statusActive {
+			users = append(users, user)
+		}
+		user = &data.User{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+	if len(next) == 0 {
+		next = nil
+	}
+	return users, next, nil
+}
@@ -0,0 +1,212 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sceyt_task/pkg/logging"
+	"sync"
+	"time"
+)
+
+// jsonWebKey is a key of a JWKS document (RFC 7517), only the fields of RSA, P-256 and Ed25519 public keys are read
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Key is a public key a token can be verified with
+type Key struct {
+	ID  string
+	Alg string
+	Key crypto.PublicKey
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parseKey returns the public key and the algorithm it is used with
+func parseKey(k jsonWebKey) (*Key, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa key %q is shorter than 2048 bits", k.Kid)
+		}
+		return &Key{ID: k.Kid, Alg: AlgRS256, Key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q of key %q", k.Crv, k.Kid)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %q is not on the P-256 curve", k.Kid)
+		}
+		return &Key{ID: k.Kid, Alg: AlgES256, Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q of key %q", k.Crv, k.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key %q", k.Kid)
+		}
+		return &Key{ID: k.Kid, Alg: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q of key %q", k.Kty, k.Kid)
+}
+
+// ParseJWKS returns the signing keys of a JWKS document, keys meant for encryption are skipped
+func ParseJWKS(b []byte) ([]*Key, error) {
+	doc := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		if k.Alg != "" && k.Alg != key.Alg {
+			return nil, fmt.Errorf("algorithm %q does not match the type of key %q", k.Alg, k.Kid)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeySet holds the keys of a local JWKS file and reloads them when the file changes,
+// so keys can be rotated without a restart
+type KeySet struct {
+	path     string
+	interval time.Duration
+	logger   logging.Logger
+
+	mu      sync.RWMutex
+	keys    []*Key
+	modTime time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewKeySet loads the JWKS file, it is checked for changes every interval once Start is called
+func NewKeySet(path string, interval time.Duration, l logging.Logger) (*KeySet, error) {
+	s := &KeySet{path: path, interval: interval, logger: l, stop: make(chan struct{})}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the JWKS file if it changed since it was last read, the current keys
+// are kept when the file can not be read
+func (s *KeySet) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	s.keys, s.modTime = keys, info.ModTime()
+	s.mu.Unlock()
+	s.logger.Info("loaded ", len(keys), " token verification keys from ", s.path)
+	return nil
+}
+
+// Keys returns the keys a token signed with alg and kid may be verified with,
+// every key of the algorithm when the token has no kid
+func (s *KeySet) Keys(alg string, kid string) []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*Key
+	for _, k := range s.keys {
+		if k.Alg == alg && (kid == "" || k.ID == kid) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Start checks the file for changes every interval
+func (s *KeySet) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					s.logger.Error("error while reloading token verification keys", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the reload and waits for it to finish
+func (s *KeySet) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
@@ -0,0 +1,118 @@
+package token
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseJWKS(t *testing.T) {
+	keys, err := ParseJWKS([]byte(`{"keys":[
+		{"kty":"OKP","kid":"enc","use":"enc","crv":"X25519","x":"AA"},
+		{"kty":"OKP","kid":"ed","use":"sig","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the encryption key is skipped
+	if len(keys) != 1 || keys[0].ID != "ed" || keys[0].Alg != AlgEdDSA {
+		t.Fatalf("keys = %+v", keys)
+	}
+
+	invalid := map[string]string{
+		"not json":           `{"keys":`,
+		"unknown type":       `{"keys":[{"kty":"oct","k":"AA"}]}`,
+		"short rsa key":      `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`,
+		"unsupported curve":  `{"keys":[{"kty":"EC","crv":"P-384","x":"AA","y":"AA"}]}`,
+		"point not on curve": `{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		"short ed25519 key":  `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AAAA"}]}`,
+		"wrong algorithm":    `{"keys":[{"kty":"OKP","crv":"Ed25519","alg":"RS256","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+		"invalid base64":     `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"!"}]}`,
+	}
+	for name, doc := range invalid {
+		if _, err := ParseJWKS([]byte(doc)); err == nil {
+			t.Errorf("%s: ParseJWKS() succeeded", name)
+		}
+	}
+}
+
+func TestParseJWKSRejectsShortRSAKeys(t *testing.T) {
+	key := newRSAKey(t, "rsa", 1024)
+	set, err := ParseJWKS([]byte(`{"keys":[{"kty":"RSA","n":"` + key.jwk["n"] + `","e":"` + key.jwk["e"] + `"}]}`))
+	if err == nil {
+		t.Fatalf("ParseJWKS() = %+v, want an error for a 1024 bit key", set)
+	}
+}
+
+func TestKeySetReloadsTheFile(t *testing.T) {
+	old, rotated := newEd25519Key(t, "old"), newEd25519Key(t, "new")
+	set, path := newTestKeySet(t, old)
+	verifier := NewVerifier(set, "", "", 0)
+	if _, err := verifier.Verify(rotated.token(t, validClaims())); err != ErrSignature {
+		t.Fatalf("Verify() with the key not published yet = %v", err)
+	}
+
+	writeJWKS(t, path, rotated)
+	// the modification time decides whether the file is read again
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(rotated.token(t, validClaims())); err != nil {
+		t.Fatalf("Verify() with the rotated key = %v", err)
+	}
+	if _, err := verifier.Verify(old.token(t, validClaims())); err != ErrSignature {
+		t.Fatalf("Verify() with the removed key = %v", err)
+	}
+
+	// a broken file keeps the current keys
+	if err := os.WriteFile(path, []byte(`{"keys":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Reload(); err == nil {
+		t.Fatal("Reload() of a broken file succeeded")
+	}
+	if keys := set.Keys(AlgEdDSA, "new"); len(keys) != 1 {
+		t.Fatalf("keys after a failed reload = %+v", keys)
+	}
+}
+
+func TestKeySetReloadsInTheBackground(t *testing.T) {
+	old, rotated := newECKey(t, "old"), newECKey(t, "new")
+	set, path := newTestKeySet(t, old)
+	set.interval = 5 * time.Millisecond
+	set.Start()
+	defer set.Stop()
+
+	writeJWKS(t, path, old, rotated)
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(set.Keys(AlgES256, "new")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the rotated key was not loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if keys := set.Keys(AlgES256, ""); len(keys) != 2 {
+		t.Fatalf("keys without a kid = %d, want both", len(keys))
+	}
+	if keys := set.Keys(AlgRS256, ""); len(keys) != 0 {
+		t.Fatalf("keys of another algorithm = %d", len(keys))
+	}
+}
+
+func TestNewKeySetWithoutTheFile(t *testing.T) {
+	if _, err := NewKeySet(t.TempDir()+"/missing.json", time.Hour, testLogger()); err == nil {
+		t.Fatal("NewKeySet() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,177 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// supported signature algorithms (RFC 7518, RFC 8037)
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	// ErrMalformed is returned for tokens that are not a signed JWT in compact serialization
+	ErrMalformed = errors.New("token is malformed")
+	// ErrSignature is returned when no key verifies the signature of the token
+	ErrSignature = errors.New("token signature is invalid")
+	// ErrExpired is returned for tokens used after their expiry or before their not before time
+	ErrExpired = errors.New("token is expired or not valid yet")
+	// ErrClaims is returned when the token has no subject or its issuer or audience is not accepted
+	ErrClaims = errors.New("token subject, issuer or audience is not accepted")
+)
+
+// Audience is the aud claim, a single string or an array of strings
+type Audience []string
+
+// UnmarshalJSON reads both forms of the aud claim
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims are the registered claims of a token, its scopes and the roles of the subject
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	// Scope is the space separated scope claim (RFC 8693), Scp the array used by some issuers
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+	Roles []string `json:"roles"`
+}
+
+// Scopes returns the scopes granted by the token
+func (c *Claims) Scopes() []string {
+	return append(strings.Fields(c.Scope), c.Scp...)
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verifier checks the signature and the claims of bearer tokens
+type Verifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// NewVerifier returns a Verifier accepting tokens of the issuer for the audience, signed
+// with a key of the set. leeway is the clock skew tolerated for exp and nbf.
+func NewVerifier(keys *KeySet, issuer string, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{keys: keys, issuer: issuer, audience: audience, leeway: leeway}
+}
+
+// Verify returns the claims of a valid token
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	h := &header{}
+	if err := decodeSegment(parts[0], h); err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.keys.Keys(h.Alg, h.Kid) {
+		if verify(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	claims := &Claims{}
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err = v.validate(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) validate(c *Claims, now time.Time) error {
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(v.leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrExpired
+	}
+	if c.Subject == "" || (v.issuer != "" && c.Issuer != v.issuer) {
+		return ErrClaims
+	}
+	if v.audience != "" {
+		for _, aud := range c.Audience {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return ErrClaims
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verify checks the signature with the key, the algorithm was already matched to the key type
+func verify(key *Key, signed []byte, signature []byte) bool {
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// the signature is r and s as 32 byte big endian integers (RFC 7518 section 3.4)
+		if len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, signed, signature)
+	}
+	return false
+}
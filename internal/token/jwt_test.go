@@ -0,0 +1,268 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sceyt_task/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger() logging.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return logging.Logger{Entry: logrus.NewEntry(l)}
+}
+
+// signingKey is a private key tokens are signed with in the tests and its public JWK
+type signingKey struct {
+	kid  string
+	alg  string
+	jwk  map[string]string
+	sign func(signed []byte) []byte
+}
+
+func encodeInt(i *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, size)))
+}
+
+func newRSAKey(t *testing.T, kid string, bits int) *signingKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingKey{
+		kid: kid,
+		alg: AlgRS256,
+		jwk: map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "alg": AlgRS256,
+			"n": encodeInt(private.N, bits/8), "e": encodeInt(big.NewInt(int64(private.E)), 3)},
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			signature, err := rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		},
+	}
+}
+
+func newECKey(t *testing.T, kid string) *signingKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingKey{
+		kid: kid,
+		alg: AlgES256,
+		jwk: map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+			"x": encodeInt(private.X, 32), "y": encodeInt(private.Y, 32)},
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		},
+	}
+}
+
+func newEd25519Key(t *testing.T, kid string) *signingKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingKey{
+		kid: kid,
+		alg: AlgEdDSA,
+		jwk: map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(public)},
+		sign: func(signed []byte) []byte {
+			return ed25519.Sign(private, signed)
+		},
+	}
+}
+
+// token returns the compact serialization of the claims signed with the key
+func (k *signingKey) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	h, err := json.Marshal(header{Alg: k.alg, Kid: k.kid, Typ: "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(k.sign([]byte(signed)))
+}
+
+// writeJWKS writes the public keys to the JWKS file at path
+func writeJWKS(t *testing.T, path string, keys ...*signingKey) {
+	t.Helper()
+	doc := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	for _, k := range keys {
+		doc.Keys = append(doc.Keys, k.jwk)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestKeySet(t *testing.T, keys ...*signingKey) (*KeySet, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys...)
+	set, err := NewKeySet(path, time.Hour, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set, path
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://gateway",
+		"sub":   "alice",
+		"aud":   "user-server",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"scope": "users:read users:write",
+		"roles": []string{"user"},
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	keys := []*signingKey{newRSAKey(t, "rsa", 2048), newECKey(t, "ec"), newEd25519Key(t, "ed")}
+	set, _ := newTestKeySet(t, keys...)
+	verifier := NewVerifier(set, "https://gateway", "user-server", 0)
+
+	for _, key := range keys {
+		claims, err := verifier.Verify(key.token(t, validClaims()))
+		if err != nil {
+			t.Fatalf("%s: Verify() = %v", key.alg, err)
+		}
+		if claims.Subject != "alice" || strings.Join(claims.Scopes(), " ") != "users:read users:write" || len(claims.Roles) != 1 {
+			t.Errorf("%s: claims = %+v", key.alg, claims)
+		}
+	}
+}
+
+func TestVerifyRejectsTokens(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa", 2048), newECKey(t, "ec")
+	set, _ := newTestKeySet(t, rsaKey, ecKey)
+	verifier := NewVerifier(set, "https://gateway", "user-server", time.Minute)
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := rsaKey.token(t, validClaims())
+	parts := strings.Split(valid, ".")
+	unknown := newRSAKey(t, "rsa", 2048)
+	// a key of another algorithm with the kid of the RSA key
+	confused := newEd25519Key(t, "rsa")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := map[string]struct {
+		token string
+		err   error
+	}{
+		"two segments":         {parts[0] + "." + parts[1], ErrMalformed},
+		"invalid signature":    {parts[0] + "." + parts[1] + ".!", ErrMalformed},
+		"header is not base64": {"!." + parts[1] + "." + parts[2], ErrMalformed},
+		"unsigned":             {unsigned, ErrSignature},
+		"changed claims":       {parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"root"}`)) + "." + parts[2], ErrSignature},
+		"unknown key":          {unknown.token(t, validClaims()), ErrSignature},
+		"other algorithm":      {confused.token(t, validClaims()), ErrSignature},
+		"expired":              {ecKey.token(t, with("exp", time.Now().Add(-2*time.Minute).Unix())), ErrExpired},
+		"no expiry":            {ecKey.token(t, with("exp", nil)), ErrExpired},
+		"not valid yet":        {ecKey.token(t, with("nbf", time.Now().Add(2*time.Minute).Unix())), ErrExpired},
+		"other issuer":         {ecKey.token(t, with("iss", "https://other")), ErrClaims},
+		"other audience":       {ecKey.token(t, with("aud", []string{"billing", "search"})), ErrClaims},
+		"no subject":           {ecKey.token(t, with("sub", nil)), ErrClaims},
+	}
+	for name, tt := range tests {
+		if _, err := verifier.Verify(tt.token); err != tt.err {
+			t.Errorf("%s: Verify() = %v, want %v", name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyToleratesTheLeeway(t *testing.T) {
+	key := newECKey(t, "ec")
+	set, _ := newTestKeySet(t, key)
+	verifier := NewVerifier(set, "", "", time.Minute)
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	claims["nbf"] = time.Now().Add(30 * time.Second).Unix()
+	claims["aud"] = []string{"anyone"}
+	// without an issuer and an audience configured both are not checked
+	claims["iss"] = "https://other"
+	if _, err := verifier.Verify(key.token(t, claims)); err != nil {
+		t.Fatalf("Verify() = %v, want the token accepted within the leeway", err)
+	}
+}
+
+func TestVerifyWithoutKid(t *testing.T) {
+	first, second := newEd25519Key(t, "first"), newEd25519Key(t, "second")
+	set, _ := newTestKeySet(t, first, second)
+	verifier := NewVerifier(set, "", "", 0)
+
+	// without a kid every key of the algorithm is tried
+	second.kid = ""
+	if _, err := verifier.Verify(second.token(t, validClaims())); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+}
+
+func TestAudience(t *testing.T) {
+	tests := map[string]Audience{
+		`"a"`:         {"a"},
+		`["a","b"]`:   {"a", "b"},
+		`[]`:          {},
+		`{"aud":"a"}`: nil,
+	}
+	for in, want := range tests {
+		var aud Audience
+		err := json.Unmarshal([]byte(in), &aud)
+		if want == nil {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) succeeded", in)
+			}
+			continue
+		}
+		if err != nil || strings.Join(aud, ",") != strings.Join(want, ",") {
+			t.Errorf("Unmarshal(%s) = %v, %v, want %v", in, aud, err, want)
+		}
+	}
+}
@@ -2,10 +2,68 @@ package validation
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/go-playground/validator"
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	"github.com/go-playground/locales/id"
+	"github.com/go-playground/locales/ja"
+	"github.com/go-playground/locales/nl"
+	"github.com/go-playground/locales/pt"
+	"github.com/go-playground/locales/pt_BR"
+	"github.com/go-playground/locales/tr"
+	"github.com/go-playground/locales/zh"
+	"github.com/go-playground/locales/zh_Hant_TW"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	id_translations "github.com/go-playground/validator/v10/translations/id"
+	ja_translations "github.com/go-playground/validator/v10/translations/ja"
+	nl_translations "github.com/go-playground/validator/v10/translations/nl"
+	pt_translations "github.com/go-playground/validator/v10/translations/pt"
+	pt_BR_translations "github.com/go-playground/validator/v10/translations/pt_BR"
+	tr_translations "github.com/go-playground/validator/v10/translations/tr"
+	zh_translations "github.com/go-playground/validator/v10/translations/zh"
+	zh_tw_translations "github.com/go-playground/validator/v10/translations/zh_tw"
 )
 
+// DefaultLanguage is used when none of the accepted languages is supported
+const DefaultLanguage = "en"
+
+type translation struct {
+	locale   locales.Translator
+	register func(v *validator.Validate, trans ut.Translator) error
+}
+
+// translations are the languages validation messages are available in
+var translations = []translation{
+	{en.New(), en_translations.RegisterDefaultTranslations},
+	{es.New(), es_translations.RegisterDefaultTranslations},
+	{fr.New(), fr_translations.RegisterDefaultTranslations},
+	{id.New(), id_translations.RegisterDefaultTranslations},
+	{ja.New(), ja_translations.RegisterDefaultTranslations},
+	{nl.New(), nl_translations.RegisterDefaultTranslations},
+	{pt.New(), pt_translations.RegisterDefaultTranslations},
+	{pt_BR.New(), pt_BR_translations.RegisterDefaultTranslations},
+	{tr.New(), tr_translations.RegisterDefaultTranslations},
+	{zh.New(), zh_translations.RegisterDefaultTranslations},
+	{zh_Hant_TW.New(), zh_tw_translations.RegisterDefaultTranslations},
+}
+
+// languageAliases maps language tags that differ from the locale names
+var languageAliases = map[string]string{
+	"zh_tw":      "zh_Hant_TW",
+	"zh_hant":    "zh_Hant_TW",
+	"zh_hant_tw": "zh_Hant_TW",
+}
+
 // ValidationError wraps the validation FieldError so we do not
 // expose this to outside code
 type ValidationError struct {
@@ -39,13 +97,98 @@ func (v ValidationErrors) Errors() []string {
 
 // Validation is the type for validation
 type Validation struct {
-	validate *validator.Validate
+	validate   *validator.Validate
+	translator *ut.UniversalTranslator
 }
 
-// NewValidation returns a Validator instance
+// NewValidation returns a Validator instance, fields are reported by their json
+// names and messages are available in every language of translations
 func NewValidation() *Validation {
 	validate := validator.New()
-	return &Validation{validate}
+	validate.RegisterTagNameFunc(jsonName)
+
+	fallback := translations[0].locale
+	supported := make([]locales.Translator, 0, len(translations))
+	for _, t := range translations {
+		supported = append(supported, t.locale)
+	}
+	uni := ut.New(fallback, supported...)
+	for _, t := range translations {
+		trans, _ := uni.GetTranslator(t.locale.Locale())
+		if err := t.register(validate, trans); err != nil {
+			panic(fmt.Sprintf("registering %s validation messages: %v", t.locale.Locale(), err))
+		}
+	}
+	return &Validation{validate, uni}
+}
+
+// jsonName returns the name of the field in the json document
+func jsonName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Translator returns the translator of the most preferred supported language
+// of an Accept-Language header, English if none of them is supported
+func (v *Validation) Translator(acceptLanguage string) ut.Translator {
+	trans, found := v.translator.FindTranslator(ParseAcceptLanguage(acceptLanguage)...)
+	if !found {
+		trans = v.translator.GetFallback()
+	}
+	return trans
+}
+
+// ParseAcceptLanguage returns the locales of an Accept-Language header ordered by
+// preference. Every tag is followed by its base language, so a region that is
+// not supported falls back to the language.
+func ParseAcceptLanguage(header string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+	var languages []language
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		languages = append(languages, language{tag, quality})
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	var tags []string
+	for _, l := range languages {
+		tag := strings.ToLower(strings.ReplaceAll(l.tag, "-", "_"))
+		if alias, ok := languageAliases[tag]; ok {
+			tag = alias
+		}
+		tags = append(tags, tag)
+		if i := strings.Index(tag, "_"); i > 0 {
+			tags = append(tags, tag[:i])
+		}
+	}
+	return tags
 }
 
 // Validate method validates the given struct based on the validate tags
@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"fr", []string{"fr"}},
+		{"fr-CA, en;q=0.8", []string{"fr_ca", "fr", "en"}},
+		{"en;q=0.5, nl;q=0.9, de", []string{"de", "nl", "en"}},
+		{"es;q=0, ja", []string{"ja"}},
+		{"*, tr;q=0.1", []string{"tr"}},
+		{"zh-TW", []string{"zh_Hant_TW", "zh"}},
+		{"zh-Hant", []string{"zh_Hant_TW", "zh"}},
+		{"pt-BR;q=x", []string{"pt_br", "pt"}},
+	}
+	for _, tt := range tests {
+		if got := ParseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	v := NewValidation()
+	tests := []struct{ header, locale string }{
+		{"", "en"},
+		{"fr-CA,en;q=0.5", "fr"},
+		{"pt-BR", "pt_BR"},
+		{"pt-PT", "pt"},
+		{"zh-TW", "zh_Hant_TW"},
+		{"de, ja;q=0.3", "ja"},
+		{"de", "en"},
+	}
+	for _, tt := range tests {
+		if got := v.Translator(tt.header).Locale(); got != tt.locale {
+			t.Errorf("Translator(%q) = %s, want %s", tt.header, got, tt.locale)
+		}
+	}
+}
+
+func TestValidateReportsJSONNames(t *testing.T) {
+	type request struct {
+		FirstName string `json:"firstname,omitempty" validate:"required"`
+		Nickname  string `validate:"required"`
+		Age       int    `json:"age" validate:"min=1"`
+	}
+	v := NewValidation()
+	errs := v.Validate(&request{})
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field())
+	}
+	if want := []string{"firstname", "Nickname", "age"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %q, want %q", fields, want)
+	}
+	if errs[0].Error() != "firstname is required" {
+		t.Errorf("Error() = %q", errs[0].Error())
+	}
+	if v.Validate(&request{FirstName: "a", Nickname: "b", Age: 1}) != nil {
+		t.Error("Validate() of a valid request failed")
+	}
+}
+
+func TestValidationMessagesAreTranslated(t *testing.T) {
+	type request struct {
+		FirstName string `json:"firstname" validate:"required"`
+	}
+	v := NewValidation()
+	err := v.Validate(&request{})[0]
+	messages := map[string]bool{}
+	for _, header := range []string{"en", "fr", "es", "ja", "nl", "de"} {
+		messages[err.Translate(v.Translator(header))] = true
+	}
+	// de falls back to the english message
+	if len(messages) != 5 {
+		t.Fatalf("messages = %v, want one per supported language", messages)
+	}
+	if msg := err.Translate(v.Translator("en")); msg != "firstname is a required field" {
+		t.Errorf("english message = %q", msg)
+	}
+}
@@ -37,3 +37,8 @@ func NewSessionFactory() (*SessionFactory, error) {
 func (sf *SessionFactory) GetSession() *gocql.Session {
 	return sf.session
 }
+
+// Close closes the session, queries started afterwards fail
+func (sf *SessionFactory) Close() {
+	sf.session.Close()
+}